@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Functions for importing and exporting user accounts.
+
+type account_record struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	UserType string `json:"usertype"`
+	Rights   string `json:"rights"`
+	Note     string `json:"note"`
+	InitChan string `json:"initchan"`
+}
+
+var account_record_fields = []string{"username", "password", "usertype", "rights", "note", "initchan"}
+
+func (record account_record) Values() []string {
+	return []string{record.Username, record.Password, record.UserType, record.Rights, record.Note, record.InitChan}
+}
+
+func (server *tt_server) Accounts_read() map[string]map[string]string {
+	defer server.Unlock()
+	server.Lock()
+	accounts := make(map[string]map[string]string)
+	for name, fields := range server.accounts {
+		accounts[name] = make(map[string]string)
+		for k, v := range fields {
+			accounts[name][k] = v
+		}
+	}
+	return accounts
+}
+
+func account_records_from_map(accounts map[string]map[string]string) []account_record {
+	names := []string{}
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	records := []account_record{}
+	for _, name := range names {
+		records = append(records, account_record{
+			Username: name,
+			Password: accounts[name]["password"],
+			UserType: accounts[name]["usertype"],
+			Rights:   accounts[name]["rights"],
+			Note:     accounts[name]["note"],
+			InitChan: accounts[name]["initchan"],
+		})
+	}
+	return records
+}
+
+func account_records_format(fname string) (string, error) {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".csv":
+		return "csv", nil
+	case ".json":
+		return "json", nil
+	}
+	return "", errors.New("Unsupported file type. The file name must end in .csv or .json.")
+}
+
+func account_records_write(fname string, records []account_record) error {
+	format, err := account_records_format(fname)
+	if err != nil {
+		return err
+	}
+	if err := dir_create(filepath.Dir(fname)); err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		return err
+	default:
+		w := csv.NewWriter(f)
+		w.Write(account_record_fields)
+		for _, record := range records {
+			w.Write(record.Values())
+		}
+		w.Flush()
+		return w.Error()
+	}
+}
+
+func account_records_read(fname string) ([]account_record, error) {
+	format, err := account_records_format(fname)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := []account_record{}
+	if format == "json" {
+		if err := json.NewDecoder(f).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return records, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, exists := columns["username"]; !exists {
+		return nil, errors.New("The first row of the file must name the columns, including a username column.")
+	}
+	field := func(row []string, name string) string {
+		i, exists := columns[name]
+		if !exists || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+	for _, row := range rows[1:] {
+		records = append(records, account_record{
+			Username: field(row, "username"),
+			Password: field(row, "password"),
+			UserType: field(row, "usertype"),
+			Rights:   field(row, "rights"),
+			Note:     field(row, "note"),
+			InitChan: field(row, "initchan"),
+		})
+	}
+	return records, nil
+}
+
+func (server *tt_server) Accounts_export(fname string) bool {
+	if server.User_type_read() != TT_USERTYPE_ADMIN {
+		console_write("Unable to export user accounts. Insufficient permission.")
+		return false
+	}
+	if !server.cmd_list_accounts() {
+		return false
+	}
+	records := account_records_from_map(server.Accounts_read())
+	if err := account_records_write(fname, records); err != nil {
+		console_write("Failed to export user accounts to " + fname + ".\r\nError: " + err.Error())
+		return false
+	}
+	msg := "Exported " + strconv.Itoa(len(records)) + " user account"
+	if len(records) != 1 {
+		msg += "s"
+	}
+	console_write(msg + " to " + fname + ".")
+	return true
+}
+
+func (server *tt_server) Accounts_import(fname string, dryrun bool) bool {
+	if server.User_type_read() != TT_USERTYPE_ADMIN {
+		console_write("Unable to import user accounts. Insufficient permission.")
+		return false
+	}
+	records, err := account_records_read(fname)
+	if err != nil {
+		console_write("Failed to read user accounts from " + fname + ".\r\nError: " + err.Error())
+		return false
+	}
+	if len(records) == 0 {
+		console_write("No user accounts were found in " + fname + ".")
+		return false
+	}
+	if !server.cmd_list_accounts() {
+		return false
+	}
+	accounts := server.Accounts_read()
+	report := []string{}
+	created := 0
+	updated := 0
+	unchanged := 0
+	failed := 0
+	for i, record := range records {
+		row := "Entry " + strconv.Itoa(i+1)
+		if record.Username != "" {
+			row += " (" + record.Username + ")"
+		}
+		row += ": "
+		if record.Username == "" {
+			report = append(report, row+"failed, empty usernames aren't supported in imports.")
+			failed++
+			continue
+		}
+		utype, ok := teamtalk_flags_usertype_parse(record.UserType)
+		if !ok || (utype != TT_USERTYPE_DEFAULT && utype != TT_USERTYPE_ADMIN) {
+			report = append(report, row+"failed, unrecognized user type "+record.UserType+".")
+			failed++
+			continue
+		}
+		urights, ok := teamtalk_flags_userrights_parse(record.Rights)
+		if !ok {
+			report = append(report, row+"failed, unrecognized user rights "+record.Rights+".")
+			failed++
+			continue
+		}
+		if utype == TT_USERTYPE_ADMIN {
+			urights = TT_USERRIGHT_NONE
+		}
+		action := "create"
+		if account, exists := accounts[record.Username]; exists {
+			changes := []string{}
+			if account["password"] != record.Password {
+				changes = append(changes, "password")
+			}
+			if account["usertype"] != teamtalk_flags_usertype_str(utype) {
+				changes = append(changes, "user type")
+			}
+			if utype != TT_USERTYPE_ADMIN && account["rights"] != teamtalk_flags_userrights_str(urights) {
+				changes = append(changes, "rights")
+			}
+			if account["note"] != record.Note {
+				changes = append(changes, "note")
+			}
+			if account["initchan"] != record.InitChan {
+				changes = append(changes, "initial channel")
+			}
+			if len(changes) == 0 {
+				report = append(report, row+"unchanged.")
+				unchanged++
+				continue
+			}
+			action = "update " + strings.Join(changes, ", ")
+		}
+		if dryrun {
+			report = append(report, row+action+".")
+			if action == "create" {
+				created++
+			} else {
+				updated++
+			}
+			continue
+		}
+		if !server.cmd_new_account(record.Username, record.Password, utype, urights, record.Note, record.InitChan, false) {
+			reason := "the server rejected the account"
+			if err := server.Cmderror_read(); err != nil {
+				reason = err.Error()
+			}
+			report = append(report, row+"failed to "+action+", "+reason+".")
+			failed++
+			continue
+		}
+		if action == "create" {
+			report = append(report, row+"created.")
+			created++
+		} else {
+			report = append(report, row+"updated "+strings.TrimPrefix(action, "update ")+".")
+			updated++
+		}
+	}
+	if !dryrun {
+		server.cmd_list_accounts()
+	}
+	header := "Import of " + fname
+	if dryrun {
+		header = "Planned import of " + fname + ". No changes have been made"
+	}
+	summary := strconv.Itoa(created) + " to create, " + strconv.Itoa(updated) + " to update"
+	if !dryrun {
+		summary = strconv.Itoa(created) + " created, " + strconv.Itoa(updated) + " updated"
+	}
+	summary += ", " + strconv.Itoa(unchanged) + " unchanged, " + strconv.Itoa(failed) + " failed."
+	console_write(header + ".\r\n" + strings.Join(report, "\r\n") + "\r\n" + summary)
+	return failed == 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Functions for taking action against users who break the rules of a server.
+
+const (
+	ACTION_NONE = iota
+	ACTION_LOG
+	ACTION_WARN
+	ACTION_KICK_CHANNEL
+	ACTION_KICK_SERVER
+	ACTION_BAN
+)
+
+const (
+	ACTION_NONE_STR         = "none"
+	ACTION_LOG_STR          = "log"
+	ACTION_WARN_STR         = "warn"
+	ACTION_KICK_CHANNEL_STR = "channelkick"
+	ACTION_KICK_SERVER_STR  = "kick"
+	ACTION_BAN_STR          = "ban"
+)
+
+func action_str(action int) string {
+	switch action {
+	case ACTION_LOG:
+		return ACTION_LOG_STR
+	case ACTION_WARN:
+		return ACTION_WARN_STR
+	case ACTION_KICK_CHANNEL:
+		return ACTION_KICK_CHANNEL_STR
+	case ACTION_KICK_SERVER:
+		return ACTION_KICK_SERVER_STR
+	case ACTION_BAN:
+		return ACTION_BAN_STR
+	}
+	return ACTION_NONE_STR
+}
+
+func action_parse(str string) (int, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	str = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(str)
+	switch str {
+	case ACTION_NONE_STR, "":
+		return ACTION_NONE, true
+	case ACTION_LOG_STR:
+		return ACTION_LOG, true
+	case ACTION_WARN_STR, "warning":
+		return ACTION_WARN, true
+	case ACTION_KICK_CHANNEL_STR, "kickchannel":
+		return ACTION_KICK_CHANNEL, true
+	case ACTION_KICK_SERVER_STR, "serverkick", "kickserver":
+		return ACTION_KICK_SERVER, true
+	case ACTION_BAN_STR, "tempban":
+		return ACTION_BAN, true
+	}
+	return ACTION_NONE, false
+}
+
+func actions_str(actions []int) string {
+	strs := []string{}
+	for _, action := range actions {
+		strs = append(strs, action_str(action))
+	}
+	return strings.Join(strs, ", ")
+}
+
+func actions_parse(str string) ([]int, bool) {
+	actions := []int{}
+	for _, field := range strings.Split(str, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		action, ok := action_parse(field)
+		if !ok {
+			return nil, false
+		}
+		actions = append(actions, action)
+	}
+	return actions, true
+}
+
+// Reports whether usr is left alone by automatic moderation.
+// The bot itself and administrators are never acted upon.
+func (server *tt_server) Moderation_exempt(usr *tt_user) bool {
+	if usr == nil {
+		return true
+	}
+	if usr.Uid_read() == server.Uid_read() {
+		return true
+	}
+	return usr.UserType_read() == TT_USERTYPE_ADMIN
+}
+
+// Returns the strongest action no stronger than action that the bot is able to take against usr.
+func (server *tt_server) Action_allowed(usr *tt_user, action int) int {
+	if action == ACTION_BAN && !(server.User_rights_check(TT_USERRIGHT_BAN_USERS) && server.User_rights_check(TT_USERRIGHT_KICK_USERS)) {
+		action = ACTION_KICK_SERVER
+	}
+	if action == ACTION_KICK_SERVER && !server.Kick_check(nil) {
+		action = ACTION_KICK_CHANNEL
+	}
+	if action == ACTION_KICK_CHANNEL {
+		if ch := usr.Channel_read(); ch == nil || !server.Kick_check(ch) {
+			action = ACTION_WARN
+		}
+	}
+	return action
+}
+
+// Takes action against usr for breaking a rule, and logs reason along with the offending text.
+// Bans last for bantime, or are permanent if bantime is 0.
+func (server *tt_server) Moderate(usr *tt_user, action int, reason, text string, bantime time.Duration) bool {
+	if action == ACTION_NONE || server.Moderation_exempt(usr) {
+		return false
+	}
+	taken := server.Action_allowed(usr, action)
+	lnickname := usr.NickName_log()
+	username := usr.UserName_read()
+	msg := lnickname + ": " + reason + "."
+	if text != "" {
+		msg += "\r\nOffending text: " + text
+	}
+	msg += "\r\nAction: " + action_str(taken)
+	if taken != action {
+		msg += ", reduced from " + action_str(action) + " due to insufficient permission"
+	}
+	server.Log_username_set(username)
+	server.Log_write(msg+".", false)
+	res := true
+	switch taken {
+	case ACTION_WARN:
+		res = server.cmd_message_user(usr.Uid_read(), "Warning: "+reason+". Please stop, or further action will be taken.")
+	case ACTION_KICK_CHANNEL:
+		res = server.Kick_user(usr, usr.Channel_read(), reason)
+	case ACTION_KICK_SERVER:
+		res = server.Kick_user(usr, nil, reason)
+	case ACTION_BAN:
+		res = server.Ban_user(usr, ban_type_default(usr), nil, bantime, reason)
+		if res && server.cmd_kick(usr.Uid_read(), 0) {
+			server.Log_username_set(username)
+			server.Log_write(lnickname+" has been kicked from the server.", false)
+		}
+	}
+	return res
+}
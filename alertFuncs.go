@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for alerts when the server or channels are near capacity, or the number of users drops sharply.
+// An alert is raised when its value reaches the threshold, and cleared once it falls below the threshold by the hysteresis, so it doesn't repeat as users come and go.
+
+const (
+	ALERT_HYSTERESIS_DEFAULT  = 10
+	ALERT_DROP_WINDOW_DEFAULT = 5 * time.Minute
+	ALERT_DROP_MIN_DEFAULT    = 5
+	ALERT_SERVER              = "server"
+	ALERT_DROP                = "drop"
+)
+
+type tt_alert_config struct {
+	Disabled       bool     `xml:"disabled,attr,omitempty"`
+	ServerPercent  int      `xml:"serverPercent,omitempty"`
+	ChannelPercent int      `xml:"channelPercent,omitempty"`
+	QuotaPercent   int      `xml:"quotaPercent,omitempty"`
+	DropPercent    int      `xml:"dropPercent,omitempty"`
+	DropWindow     string   `xml:"dropWindow,omitempty"`
+	DropMinimum    int      `xml:"dropMinimum,omitempty"`
+	Hysteresis     int      `xml:"hysteresis,omitempty"`
+	Notify         []string `xml:"notify,omitempty"`
+}
+
+// The number of users logged in at a point in time, used to detect drops.
+type tt_alert_count struct {
+	time  time.Time
+	users int
+}
+
+func (conf tt_alert_config) Hysteresis_read() int {
+	if conf.Hysteresis <= 0 {
+		return ALERT_HYSTERESIS_DEFAULT
+	}
+	return conf.Hysteresis
+}
+
+func (conf tt_alert_config) DropWindow_read() time.Duration {
+	return duration_or(conf.DropWindow, ALERT_DROP_WINDOW_DEFAULT)
+}
+
+func (conf tt_alert_config) DropMinimum_read() int {
+	if conf.DropMinimum <= 0 {
+		return ALERT_DROP_MIN_DEFAULT
+	}
+	return conf.DropMinimum
+}
+
+func alert_percent_str(percent int) string {
+	if percent <= 0 {
+		return "off"
+	}
+	return strconv.Itoa(percent) + "%"
+}
+
+func (conf tt_alert_config) Info_str() string {
+	str := "Alerts are "
+	if conf.Disabled {
+		str += "disabled.\r\n"
+	} else {
+		str += "enabled.\r\n"
+	}
+	str += "Server users of maximum users: " + alert_percent_str(conf.ServerPercent) + "\r\n"
+	str += "Channel users of channel maximum users: " + alert_percent_str(conf.ChannelPercent) + "\r\n"
+	str += "Channel files of disk quota: " + alert_percent_str(conf.QuotaPercent) + "\r\n"
+	str += "Drop in users: " + alert_percent_str(conf.DropPercent)
+	if conf.DropPercent > 0 {
+		str += " within " + time_duration_str(conf.DropWindow_read()) + ", from at least " + strconv.Itoa(conf.DropMinimum_read()) + " users"
+	}
+	str += "\r\n"
+	str += "Alerts clear " + strconv.Itoa(conf.Hysteresis_read()) + "% below their threshold.\r\n"
+	if len(conf.Notify) == 0 {
+		str += "Alerts are only displayed on the console."
+	} else {
+		str += "Alerts are also sent to " + strings.Join(conf.Notify, ", ") + "."
+	}
+	return str
+}
+
+// Changes an option by name, returning an error if the value isn't valid.
+func (conf *tt_alert_config) Option_set(name, value string) error {
+	value = strings.TrimSpace(value)
+	switch name {
+	case "server", "channel", "quota", "drop", "minimum", "hysteresis":
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || n < 0 || (n > 100 && name != "minimum") {
+			return errors.New(value + " isn't a valid number for " + name + ".")
+		}
+		switch name {
+		case "server":
+			conf.ServerPercent = n
+		case "channel":
+			conf.ChannelPercent = n
+		case "quota":
+			conf.QuotaPercent = n
+		case "drop":
+			conf.DropPercent = n
+		case "minimum":
+			conf.DropMinimum = n
+		case "hysteresis":
+			conf.Hysteresis = n
+		}
+	case "window":
+		if _, ok := time_duration_parse(value); !ok {
+			return errors.New(value + " isn't a valid duration.")
+		}
+		conf.DropWindow = value
+	default:
+		return errors.New("Unrecognized option: " + name)
+	}
+	return nil
+}
+
+func (server *tt_server) AlertConfig_read() tt_alert_config {
+	defer server.Unlock()
+	server.Lock()
+	if server.Alerts == nil {
+		return tt_alert_config{}
+	}
+	conf := *server.Alerts
+	conf.Notify = append([]string{}, conf.Notify...)
+	return conf
+}
+
+func (server *tt_server) AlertConfig_set(conf tt_alert_config) {
+	defer server.Unlock()
+	server.Lock()
+	server.Alerts = &conf
+}
+
+// Returns the messages of the alerts currently raised, sorted.
+func (server *tt_server) Alerts_active() []string {
+	server.Lock()
+	alerts := []string{}
+	for _, msg := range server.alert_active {
+		alerts = append(alerts, msg)
+	}
+	server.Unlock()
+	sort.Strings(alerts)
+	return alerts
+}
+
+func (server *tt_server) Alert_notify(conf tt_alert_config, msg string) {
+	for _, to := range conf.Notify {
+		if reason := server.Message_send(to, msg); reason != "" {
+			server.Log_write("Unable to send an alert to "+to+": "+reason+".", false)
+		}
+	}
+}
+
+// Raises or clears the alert named key, given its value and threshold as percentages.
+func (server *tt_server) Alert_check(conf tt_alert_config, key string, value, threshold int, msg string) {
+	server.Lock()
+	if server.alert_active == nil {
+		server.alert_active = make(map[string]string)
+	}
+	_, active := server.alert_active[key]
+	raise := !active && threshold > 0 && value >= threshold
+	clear := active && (threshold <= 0 || value < threshold-conf.Hysteresis_read())
+	if raise {
+		server.alert_active[key] = msg
+	} else if clear {
+		delete(server.alert_active, key)
+	} else if active {
+		server.alert_active[key] = msg
+	}
+	server.Unlock()
+	if raise {
+		server.Log_write("Alert: "+msg, true)
+		server.Alert_notify(conf, "Alert: "+msg)
+	} else if clear {
+		server.Log_write("Alert cleared: "+msg, false)
+		server.Alert_notify(conf, "Alert cleared: "+msg)
+	}
+}
+
+// Clears alerts which weren't checked, such as those for channels which have been removed.
+func (server *tt_server) Alerts_forget(checked map[string]bool) {
+	defer server.Unlock()
+	server.Lock()
+	for key := range server.alert_active {
+		if !checked[key] {
+			delete(server.alert_active, key)
+		}
+	}
+}
+
+// Returns the percentage drop in users since the most within the drop window, and that most.
+func (server *tt_server) Alert_drop(conf tt_alert_config, users int) (int, int) {
+	now := time.Now()
+	cutoff := now.Add(-conf.DropWindow_read())
+	defer server.Unlock()
+	server.Lock()
+	counts := []tt_alert_count{}
+	most := users
+	for _, count := range server.alert_counts {
+		if count.time.After(cutoff) {
+			counts = append(counts, count)
+			if count.users > most {
+				most = count.users
+			}
+		}
+	}
+	server.alert_counts = append(counts, tt_alert_count{time: now, users: users})
+	if most < conf.DropMinimum_read() {
+		return 0, most
+	}
+	return (most - users) * 100 / most, most
+}
+
+// Checks every alert, called regularly while logged in.
+func (server *tt_server) Alerts_check() {
+	conf := server.AlertConfig_read()
+	if conf.Disabled || !server.Logged_in_read() || server.Cmdid_read() == TT_CMD_LOGIN {
+		return
+	}
+	checked := make(map[string]bool)
+	server.Lock()
+	users := len(server.users)
+	server.Unlock()
+	if maxusers := server.MaxUsers_read(); maxusers > 0 {
+		percent := users * 100 / maxusers
+		checked[ALERT_SERVER] = true
+		server.Alert_check(conf, ALERT_SERVER, percent, conf.ServerPercent, strconv.Itoa(users)+" of "+strconv.Itoa(maxusers)+" users are logged in ("+strconv.Itoa(percent)+"%).")
+	}
+	for _, ch := range server.Channels_sort() {
+		path := ch.Path_read()
+		if maxusers := ch.Maxusers_read(); maxusers > 0 {
+			count := len(ch.Users_read())
+			percent := count * 100 / maxusers
+			key := "channel:" + path
+			checked[key] = true
+			server.Alert_check(conf, key, percent, conf.ChannelPercent, path+" has "+strconv.Itoa(count)+" of "+strconv.Itoa(maxusers)+" users ("+strconv.Itoa(percent)+"%).")
+		}
+		if quota := ch.Quota_read(); quota > 0 {
+			size := ch.Files_size()
+			percent := int(int64(size) * 100 / int64(quota))
+			key := "quota:" + path
+			checked[key] = true
+			server.Alert_check(conf, key, percent, conf.QuotaPercent, "Files in "+path+" use "+size_str(size)+" of the "+size_str(quota)+" disk quota ("+strconv.Itoa(percent)+"%).")
+		}
+	}
+	percent, most := server.Alert_drop(conf, users)
+	checked[ALERT_DROP] = true
+	server.Alert_check(conf, ALERT_DROP, percent, conf.DropPercent, "The number of users dropped from "+strconv.Itoa(most)+" to "+strconv.Itoa(users)+" within "+time_duration_str(conf.DropWindow_read())+" ("+strconv.Itoa(percent)+"%), which may mean a problem with the server.")
+	server.Alerts_forget(checked)
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Functions for recording user sessions, and reporting on them.
+
+const (
+	ANALYTICS_RETENTION_DEFAULT = 90 * 24 * time.Hour
+	REPORT_DAILY                = "daily"
+	REPORT_WEEKLY               = "weekly"
+	REPORT_CHANNELS_SHOWN       = 10
+	REPORT_DAY_FORMAT           = "2006-01-02"
+)
+
+type tt_analytics_config struct {
+	Disabled  bool   `xml:"disabled,attr,omitempty"`
+	Retention string `xml:"retention,omitempty"`
+}
+
+// A channel a user was in during a session.
+type tt_session_channel struct {
+	Path   string    `xml:"path,attr"`
+	Joined time.Time `xml:"joined"`
+	Left   time.Time `xml:"left"`
+}
+
+// A session from login to logout. Partial sessions were already logged in when the bot logged in, so their login time is unknown.
+type tt_session struct {
+	UserName string                `xml:"username,attr,omitempty"`
+	NickName string                `xml:"nickname,attr,omitempty"`
+	Partial  bool                  `xml:"partial,attr,omitempty"`
+	Login    time.Time             `xml:"login"`
+	Logout   time.Time             `xml:"logout"`
+	Channels []*tt_session_channel `xml:"channel"`
+}
+
+// The most users logged in at once during a day, including the bot.
+type tt_peak struct {
+	Day      string    `xml:"day,attr"`
+	Users    int       `xml:"users,attr"`
+	MaxUsers int       `xml:"maxusers,attr"`
+	Time     time.Time `xml:"time,attr"`
+}
+
+type tt_stats struct {
+	XMLName  xml.Name      `xml:"sessions"`
+	Sessions []*tt_session `xml:"session"`
+	Peaks    []*tt_peak    `xml:"peak"`
+}
+
+type tt_report_period struct {
+	Start          string `json:"start"`
+	End            string `json:"end"`
+	UniqueUsers    int    `json:"uniqueUsers"`
+	NewUsers       int    `json:"newUsers"`
+	ReturningUsers int    `json:"returningUsers"`
+	Sessions       int    `json:"sessions"`
+	AverageSession int64  `json:"averageSessionSeconds"`
+	PeakUsers      int    `json:"peakUsers"`
+	PeakTime       string `json:"peakTime,omitempty"`
+	MaxUsers       int    `json:"maxUsers"`
+}
+
+type tt_report_channel struct {
+	Path    string `json:"path"`
+	Users   int    `json:"users"`
+	Seconds int64  `json:"seconds"`
+}
+
+type tt_report_hour struct {
+	Hour         int     `json:"hour"`
+	AverageUsers float64 `json:"averageUsers"`
+}
+
+type tt_report struct {
+	Server    string               `json:"server"`
+	Generated time.Time            `json:"generated"`
+	Period    string               `json:"period"`
+	Periods   []*tt_report_period  `json:"periods"`
+	Channels  []*tt_report_channel `json:"channels"`
+	Hours     []*tt_report_hour    `json:"hours"`
+}
+
+func (conf tt_analytics_config) Retention_read() time.Duration {
+	return duration_or(conf.Retention, ANALYTICS_RETENTION_DEFAULT)
+}
+
+func (conf tt_analytics_config) Info_str() string {
+	if conf.Disabled {
+		return "Session recording is disabled."
+	}
+	return "Session recording is enabled.\r\nSessions are kept for " + time_duration_str(conf.Retention_read())
+}
+
+func (session *tt_session) Key() string {
+	return seen_key(session.UserName, session.NickName)
+}
+
+func (server *tt_server) AnalyticsConfig_read() tt_analytics_config {
+	defer server.Unlock()
+	server.Lock()
+	if server.Analytics == nil {
+		return tt_analytics_config{}
+	}
+	return *server.Analytics
+}
+
+func (server *tt_server) AnalyticsConfig_set(conf tt_analytics_config) {
+	defer server.Unlock()
+	server.Lock()
+	server.Analytics = &conf
+}
+
+func (server *tt_server) Stats_path() string {
+	path := server.State_path()
+	if path == "" {
+		return ""
+	}
+	return path + "sessions.xml"
+}
+
+// Loads the recorded sessions the first time they are needed.
+// The stats lock is held until they are loaded, so nothing sees or changes them before then.
+func (server *tt_server) Stats_load() {
+	server.Lock()
+	loaded := server.stats_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	defer server.stats_lock.Unlock()
+	server.stats_lock.Lock()
+	server.Lock()
+	loaded = server.stats_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	fname := server.Stats_path()
+	stats := &tt_stats{}
+	err := state_read(fname, stats)
+	server.Lock()
+	server.stats_loaded = true
+	server.stats_failed = err != nil
+	if err != nil {
+		stats = &tt_stats{}
+	}
+	server.stats = stats
+	server.Unlock()
+	if err != nil {
+		server.Log_write("Error reading sessions from "+fname+": "+err.Error()+". The file won't be changed until the bot is restarted.", true)
+	}
+}
+
+// Writes the finished sessions and peaks if they have changed, after removing those older than the retention period.
+// Called regularly and on disconnecting, so the file isn't written for every logout.
+func (server *tt_server) Stats_write() bool {
+	server.Stats_load()
+	cutoff := time.Now().Add(-server.AnalyticsConfig_read().Retention_read())
+	defer server.stats_lock.Unlock()
+	server.stats_lock.Lock()
+	server.Lock()
+	if !server.stats_dirty || server.stats_failed {
+		server.Unlock()
+		return false
+	}
+	server.stats_dirty = false
+	sessions := []*tt_session{}
+	for _, session := range server.stats.Sessions {
+		if session.Logout.After(cutoff) {
+			sessions = append(sessions, session)
+		}
+	}
+	// Peaks are copied, since they change as users log in.
+	peaks := []*tt_peak{}
+	stats := &tt_stats{Sessions: sessions}
+	for _, peak := range server.stats.Peaks {
+		if peak.Time.After(cutoff) {
+			peaks = append(peaks, peak)
+			p := *peak
+			stats.Peaks = append(stats.Peaks, &p)
+		}
+	}
+	server.stats.Sessions = sessions
+	server.stats.Peaks = peaks
+	server.Unlock()
+	fname := server.Stats_path()
+	if fname == "" {
+		return false
+	}
+	if err := state_write(fname, stats); err != nil {
+		server.Lock()
+		server.stats_dirty = true
+		server.Unlock()
+		server.Log_write("Error writing sessions to "+fname+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// Starts a session for usr, and updates the peak number of users for today.
+func (server *tt_server) Session_login(usr *tt_user) {
+	if server.AnalyticsConfig_read().Disabled || usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	server.Stats_load()
+	now := time.Now()
+	session := &tt_session{
+		UserName: usr.UserName_read(),
+		NickName: usr.NickName_read(),
+		Login:    now,
+	}
+	if usr.Conntime_isSet() {
+		session.Login = usr.Conntime_read()
+	} else {
+		session.Partial = true
+	}
+	maxusers := server.MaxUsers_read()
+	defer server.Unlock()
+	server.Lock()
+	if server.stats_open == nil {
+		server.stats_open = make(map[int]*tt_session)
+	}
+	server.stats_open[usr.Uid_read()] = session
+	count := len(server.users)
+	day := now.Format(REPORT_DAY_FORMAT)
+	for _, peak := range server.stats.Peaks {
+		if peak.Day == day {
+			if count > peak.Users {
+				peak.Users = count
+				peak.MaxUsers = maxusers
+				peak.Time = now
+				server.stats_dirty = true
+			}
+			return
+		}
+	}
+	server.stats.Peaks = append(server.stats.Peaks, &tt_peak{Day: day, Users: count, MaxUsers: maxusers, Time: now})
+	server.stats_dirty = true
+}
+
+// Returns the open session of usr. Must be called with the server locked.
+func (server *tt_server) session_open(usr *tt_user) *tt_session {
+	if server.stats_open == nil {
+		return nil
+	}
+	return server.stats_open[usr.Uid_read()]
+}
+
+func (session *tt_session) channel_leave(now time.Time) {
+	if n := len(session.Channels); n > 0 && session.Channels[n-1].Left.IsZero() {
+		session.Channels[n-1].Left = now
+	}
+}
+
+func (server *tt_server) Session_join(usr *tt_user, ch *tt_channel) {
+	path := ch.Path_read()
+	now := time.Now()
+	defer server.Unlock()
+	server.Lock()
+	session := server.session_open(usr)
+	if session == nil {
+		return
+	}
+	session.channel_leave(now)
+	session.Channels = append(session.Channels, &tt_session_channel{Path: path, Joined: now})
+}
+
+func (server *tt_server) Session_leave(usr *tt_user) {
+	defer server.Unlock()
+	server.Lock()
+	if session := server.session_open(usr); session != nil {
+		session.channel_leave(time.Now())
+	}
+}
+
+func (server *tt_server) Session_logout(usr *tt_user) {
+	now := time.Now()
+	defer server.Unlock()
+	server.Lock()
+	session := server.session_open(usr)
+	if session == nil {
+		return
+	}
+	session.channel_leave(now)
+	session.Logout = now
+	delete(server.stats_open, usr.Uid_read())
+	server.stats.Sessions = append(server.stats.Sessions, session)
+	server.stats_dirty = true
+}
+
+// Ends every open session, used when the bot disconnects.
+func (server *tt_server) Sessions_close_all() {
+	now := time.Now()
+	server.Lock()
+	for _, session := range server.stats_open {
+		session.channel_leave(now)
+		session.Logout = now
+		server.stats.Sessions = append(server.stats.Sessions, session)
+		server.stats_dirty = true
+	}
+	server.stats_open = nil
+	server.Unlock()
+	server.Stats_write()
+}
+
+// Returns the start of the day or week containing t.
+func report_period_start(period string, t time.Time) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == REPORT_WEEKLY {
+		// Weeks start on Monday.
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start
+}
+
+func report_period_next(period string, t time.Time) time.Time {
+	if period == REPORT_WEEKLY {
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// Adds the seconds between from and to into the hour of the day they fall in.
+func report_hours_add(hours *[24]float64, from, to time.Time) {
+	for from.Before(to) {
+		next := time.Date(from.Year(), from.Month(), from.Day(), from.Hour()+1, 0, 0, 0, from.Location())
+		if next.After(to) {
+			next = to
+		}
+		hours[from.Hour()] += next.Sub(from).Seconds()
+		from = next
+	}
+}
+
+func time_max(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func time_min(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// Builds a report of count days or weeks, ending with the current one.
+func (server *tt_server) Report(period string, count int) *tt_report {
+	server.Stats_load()
+	now := time.Now()
+	server.Lock()
+	sessions := []tt_session{}
+	for _, session := range server.stats.Sessions {
+		sessions = append(sessions, *session)
+	}
+	for _, session := range server.stats_open {
+		s := *session
+		s.Logout = now
+		s.Channels = nil
+		for _, sc := range session.Channels {
+			c := *sc
+			if c.Left.IsZero() {
+				c.Left = now
+			}
+			s.Channels = append(s.Channels, &c)
+		}
+		sessions = append(sessions, s)
+	}
+	peaks := []tt_peak{}
+	for _, peak := range server.stats.Peaks {
+		peaks = append(peaks, *peak)
+	}
+	server.Unlock()
+	report := &tt_report{
+		Server:    server.DisplayName_read(),
+		Generated: now,
+		Period:    period,
+	}
+	// Partial sessions began at an unknown time, so they don't show when a user was first seen.
+	first := make(map[string]time.Time)
+	for _, session := range sessions {
+		if session.Partial {
+			continue
+		}
+		key := session.Key()
+		if t, exists := first[key]; !exists || session.Login.Before(t) {
+			first[key] = session.Login
+		}
+	}
+	start := report_period_start(period, now)
+	for i := 1; i < count; i++ {
+		if period == REPORT_WEEKLY {
+			start = start.AddDate(0, 0, -7)
+		} else {
+			start = start.AddDate(0, 0, -1)
+		}
+	}
+	for pstart := start; pstart.Before(now); pstart = report_period_next(period, pstart) {
+		pend := report_period_next(period, pstart)
+		p := &tt_report_period{
+			Start:    pstart.Format(REPORT_DAY_FORMAT),
+			End:      pend.AddDate(0, 0, -1).Format(REPORT_DAY_FORMAT),
+			MaxUsers: server.MaxUsers_read(),
+		}
+		users := make(map[string]bool)
+		counted := make(map[string]bool)
+		var total time.Duration
+		ended := 0
+		for _, session := range sessions {
+			if !session.Login.Before(pend) || session.Logout.Before(pstart) {
+				continue
+			}
+			key := session.Key()
+			users[key] = true
+			if session.Partial {
+				continue
+			}
+			if !counted[key] {
+				counted[key] = true
+				if t := first[key]; !t.Before(pstart) && t.Before(pend) {
+					p.NewUsers++
+				} else {
+					p.ReturningUsers++
+				}
+			}
+			if !session.Login.Before(pstart) {
+				p.Sessions++
+			}
+			if !session.Logout.Before(pstart) && session.Logout.Before(pend) {
+				total += session.Logout.Sub(session.Login)
+				ended++
+			}
+		}
+		p.UniqueUsers = len(users)
+		if ended != 0 {
+			p.AverageSession = int64(total / time.Duration(ended) / time.Second)
+		}
+		for _, peak := range peaks {
+			if peak.Time.Before(pstart) || !peak.Time.Before(pend) || peak.Users <= p.PeakUsers {
+				continue
+			}
+			p.PeakUsers = peak.Users
+			p.PeakTime = peak.Time.Format("2006-01-02 15:04")
+			if peak.MaxUsers != 0 {
+				p.MaxUsers = peak.MaxUsers
+			}
+		}
+		report.Periods = append(report.Periods, p)
+	}
+	channels := make(map[string]*tt_report_channel)
+	channel_users := make(map[string]map[string]bool)
+	var user_hours, range_hours [24]float64
+	report_hours_add(&range_hours, start, now)
+	for _, session := range sessions {
+		from := time_max(session.Login, start)
+		to := session.Logout
+		if !from.Before(to) {
+			continue
+		}
+		report_hours_add(&user_hours, from, to)
+		for _, sc := range session.Channels {
+			cfrom := time_max(sc.Joined, start)
+			cto := time_min(sc.Left, now)
+			if !cfrom.Before(cto) {
+				continue
+			}
+			rc, exists := channels[sc.Path]
+			if !exists {
+				rc = &tt_report_channel{Path: sc.Path}
+				channels[sc.Path] = rc
+				channel_users[sc.Path] = make(map[string]bool)
+			}
+			rc.Seconds += int64(cto.Sub(cfrom) / time.Second)
+			channel_users[sc.Path][session.Key()] = true
+		}
+	}
+	for path, rc := range channels {
+		rc.Users = len(channel_users[path])
+		report.Channels = append(report.Channels, rc)
+	}
+	sort.Slice(report.Channels, func(i, j int) bool {
+		if report.Channels[i].Seconds != report.Channels[j].Seconds {
+			return report.Channels[i].Seconds > report.Channels[j].Seconds
+		}
+		return report.Channels[i].Path < report.Channels[j].Path
+	})
+	for h := 0; h < 24; h++ {
+		rh := &tt_report_hour{Hour: h}
+		if range_hours[h] > 0 {
+			rh.AverageUsers = float64(int(user_hours[h]/range_hours[h]*100+0.5)) / 100
+		}
+		report.Hours = append(report.Hours, rh)
+	}
+	return report
+}
+
+func (p *tt_report_period) Name_str() string {
+	if p.Start == p.End {
+		return p.Start
+	}
+	return p.Start + " to " + p.End
+}
+
+func (p *tt_report_period) Peak_str() string {
+	str := strconv.Itoa(p.PeakUsers)
+	if p.MaxUsers > 0 {
+		str += " of " + strconv.Itoa(p.MaxUsers) + " (" + strconv.Itoa(p.PeakUsers*100/p.MaxUsers) + "%)"
+	}
+	return str
+}
+
+func report_table(header []string, rows [][]string) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	w.Write([]byte(strings.Join(header, "\t") + "\n"))
+	for _, row := range rows {
+		w.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+	w.Flush()
+	return strings.Replace(strings.TrimRight(b.String(), "\n"), "\n", "\r\n", -1)
+}
+
+func (report *tt_report) Periods_rows() [][]string {
+	rows := [][]string{}
+	for _, p := range report.Periods {
+		average := "none"
+		if p.AverageSession > 0 {
+			average = time_duration_str((time.Duration(p.AverageSession) * time.Second).Round(time.Minute))
+		}
+		rows = append(rows, []string{p.Name_str(), strconv.Itoa(p.UniqueUsers), strconv.Itoa(p.NewUsers), strconv.Itoa(p.ReturningUsers), strconv.Itoa(p.Sessions), average, p.Peak_str()})
+	}
+	return rows
+}
+
+func (report *tt_report) Str() string {
+	str := "Report for " + report.Server + ", " + report.Period + ":\r\n"
+	str += report_table([]string{"Period", "Users", "New", "Returning", "Sessions", "Average session", "Peak"}, report.Periods_rows())
+	if len(report.Channels) == 0 {
+		str += "\r\n\r\nNo channel activity was recorded."
+	} else {
+		rows := [][]string{}
+		for i, rc := range report.Channels {
+			if i == REPORT_CHANNELS_SHOWN {
+				break
+			}
+			rows = append(rows, []string{rc.Path, strconv.Itoa(rc.Users), time_duration_str((time.Duration(rc.Seconds) * time.Second).Round(time.Minute))})
+		}
+		str += "\r\n\r\nBusiest channels:\r\n" + report_table([]string{"Channel", "Users", "Time spent"}, rows)
+	}
+	hours := make([]*tt_report_hour, len(report.Hours))
+	copy(hours, report.Hours)
+	sort.SliceStable(hours, func(i, j int) bool {
+		return hours[i].AverageUsers > hours[j].AverageUsers
+	})
+	rows := [][]string{}
+	for _, rh := range hours {
+		if rh.AverageUsers == 0 || len(rows) == 5 {
+			break
+		}
+		rows = append(rows, []string{strconv.Itoa(rh.Hour) + ":00", strconv.FormatFloat(rh.AverageUsers, 'f', 2, 64)})
+	}
+	if len(rows) != 0 {
+		str += "\r\n\r\nBusiest hours:\r\n" + report_table([]string{"Hour", "Average users"}, rows)
+	}
+	return str
+}
+
+// Writes the report as JSON, or as CSV with a table for the periods, channels and hours separated by blank lines.
+func (report *tt_report) Write(fname string) error {
+	format, err := account_records_format(fname)
+	if err != nil {
+		return err
+	}
+	if err := dir_create(filepath.Dir(fname)); err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"start", "end", "uniqueUsers", "newUsers", "returningUsers", "sessions", "averageSessionSeconds", "peakUsers", "peakTime", "maxUsers"})
+	for _, p := range report.Periods {
+		w.Write([]string{p.Start, p.End, strconv.Itoa(p.UniqueUsers), strconv.Itoa(p.NewUsers), strconv.Itoa(p.ReturningUsers), strconv.Itoa(p.Sessions), strconv.FormatInt(p.AverageSession, 10), strconv.Itoa(p.PeakUsers), p.PeakTime, strconv.Itoa(p.MaxUsers)})
+	}
+	w.Write([]string{})
+	w.Write([]string{"channel", "users", "seconds"})
+	for _, rc := range report.Channels {
+		w.Write([]string{rc.Path, strconv.Itoa(rc.Users), strconv.FormatInt(rc.Seconds, 10)})
+	}
+	w.Write([]string{})
+	w.Write([]string{"hour", "averageUsers"})
+	for _, rh := range report.Hours {
+		w.Write([]string{strconv.Itoa(rh.Hour), strconv.FormatFloat(rh.AverageUsers, 'f', 2, 64)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Parses the period and count of a report command, such as weekly 8.
+func report_params(params []string) (string, int, error) {
+	period := REPORT_DAILY
+	count := 0
+	for _, param := range params {
+		switch strings.ToLower(param) {
+		case REPORT_DAILY, REPORT_WEEKLY:
+			period = strings.ToLower(param)
+		default:
+			n, err := strconv.Atoi(param)
+			if err != nil || n < 1 {
+				return "", 0, errors.New(param + " isn't a valid period or number of periods.")
+			}
+			count = n
+		}
+	}
+	if count == 0 {
+		count = 7
+		if period == REPORT_WEEKLY {
+			count = 4
+		}
+	}
+	return period, count, nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for keeping a local copy of every file posted to chosen channels,
+// and optionally removing old files from the server once they have been copied.
+// Each archived channel has its own directory, with an index of the files copied into it.
+
+const (
+	ARCHIVE_INDEX       = "files.xml"
+	ARCHIVE_TEMP_PREFIX = ".download-"
+)
+
+type tt_archive_channel struct {
+	Path       string `xml:"path,attr"`
+	PruneAfter string `xml:"pruneAfter,omitempty"`
+	PruneAt    int    `xml:"pruneAtPercent,omitempty"`
+}
+
+type tt_archived_file struct {
+	Name     string    `xml:"name,attr"`
+	Local    string    `xml:"local,attr"`
+	Owner    string    `xml:"owner,omitempty"`
+	Size     int       `xml:"size"`
+	Added    time.Time `xml:"added"`
+	Archived time.Time `xml:"archived"`
+	Pruned   bool      `xml:"pruned,omitempty"`
+}
+
+type tt_archive_index struct {
+	XMLName xml.Name            `xml:"archive"`
+	Files   []*tt_archived_file `xml:"file"`
+}
+
+func (ac *tt_archive_channel) Info_str() string {
+	str := ac.Path
+	rules := []string{}
+	if d, ok := time_duration_parse(ac.PruneAfter); ok {
+		rules = append(rules, "files removed from the server after "+time_duration_str(d))
+	}
+	if ac.PruneAt > 0 {
+		rules = append(rules, "oldest files removed from the server at "+strconv.Itoa(ac.PruneAt)+"% of the disk quota")
+	}
+	if len(rules) != 0 {
+		str += ", " + strings.Join(rules, ", ")
+	}
+	return str
+}
+
+// Sets an option of the archive from a name and value, such as pruneafter and 30d.
+func (ac *tt_archive_channel) Option_set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "pruneafter":
+		if value == "" || value == "0" {
+			ac.PruneAfter = ""
+			break
+		}
+		if _, ok := time_duration_parse(value); !ok {
+			return errors.New(value + " isn't a valid duration.")
+		}
+		ac.PruneAfter = value
+	case "pruneat":
+		num, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || num < 0 || num > 100 {
+			return errors.New(value + " isn't a valid percentage.")
+		}
+		ac.PruneAt = num
+	default:
+		return errors.New("Unrecognized option: " + name)
+	}
+	return nil
+}
+
+// Returns a name which is safe to use as a file or directory name.
+func archive_name_clean(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
+
+func (server *tt_server) Archive_dir(path string) string {
+	dir := filepath.Join(wd, "archive", archive_name_clean(server.DisplayName_read()))
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name != "" {
+			dir = filepath.Join(dir, archive_name_clean(name))
+		}
+	}
+	return dir
+}
+
+func (server *tt_server) Archives_read() []tt_archive_channel {
+	defer server.Unlock()
+	server.Lock()
+	archives := []tt_archive_channel{}
+	for _, ac := range server.Archives {
+		archives = append(archives, *ac)
+	}
+	return archives
+}
+
+func (server *tt_server) Archive_find(path string) (tt_archive_channel, bool) {
+	path = strings.ToLower(channel_path_clean(path))
+	for _, ac := range server.Archives_read() {
+		if strings.ToLower(ac.Path) == path {
+			return ac, true
+		}
+	}
+	return tt_archive_channel{}, false
+}
+
+// Adds or replaces the archive settings for a channel.
+func (server *tt_server) Archive_set(ac tt_archive_channel) {
+	defer server.Unlock()
+	server.Lock()
+	path := strings.ToLower(ac.Path)
+	for i, a := range server.Archives {
+		if strings.ToLower(a.Path) == path {
+			server.Archives[i] = &ac
+			return
+		}
+	}
+	server.Archives = append(server.Archives, &ac)
+}
+
+func (server *tt_server) Archive_remove(path string) bool {
+	defer server.Unlock()
+	server.Lock()
+	path = strings.ToLower(channel_path_clean(path))
+	for i, a := range server.Archives {
+		if strings.ToLower(a.Path) == path {
+			server.Archives = append(server.Archives[:i], server.Archives[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func archive_index_read(dir string) (*tt_archive_index, error) {
+	index := &tt_archive_index{}
+	if err := state_read(filepath.Join(dir, ARCHIVE_INDEX), index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func archive_index_write(dir string, index *tt_archive_index) error {
+	return state_write(filepath.Join(dir, ARCHIVE_INDEX), index)
+}
+
+// Finds the entry for a file on the server, which is the latest entry with the same name, owner and size.
+// Entries for files which have been removed from the server are skipped, so a file posted again is archived again.
+func (index *tt_archive_index) Find(name, owner string, size int) *tt_archived_file {
+	for i := len(index.Files) - 1; i >= 0; i-- {
+		af := index.Files[i]
+		if !af.Pruned && af.Name == name && af.Owner == owner && af.Size == size {
+			return af
+		}
+	}
+	return nil
+}
+
+// Returns a local file name for name which isn't used by another file in the index.
+func (index *tt_archive_index) Local_name(name string) string {
+	used := make(map[string]bool)
+	for _, af := range index.Files {
+		used[strings.ToLower(af.Local)] = true
+	}
+	name = archive_name_clean(name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	local := name
+	for i := 2; used[strings.ToLower(local)] || local == ARCHIVE_INDEX; i++ {
+		local = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	return local
+}
+
+// Copies a file posted to ch into the archive, if the channel is archived and the file hasn't been copied already.
+// The file is downloaded without holding the archive lock, which is only taken to check and update the index.
+func (server *tt_server) Archive_file(ch *tt_channel, fname string) bool {
+	if _, exists := server.Archive_find(ch.Path_read()); !exists {
+		return false
+	}
+	f := ch.File_find_name(fname)
+	if f == nil {
+		return false
+	}
+	owner := ch.File_owner(f)
+	size := ch.File_size(f)
+	dir := server.Archive_dir(ch.Path_read())
+	key := dir + "|" + fname + "|" + owner + "|" + strconv.Itoa(size)
+	server.archive_lock.Lock()
+	index, err := archive_index_read(dir)
+	if err != nil {
+		server.archive_lock.Unlock()
+		server.Log_write("Failed to read the archive index of "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	if index.Find(fname, owner, size) != nil || server.archive_downloads[key] {
+		server.archive_lock.Unlock()
+		return false
+	}
+	if server.archive_downloads == nil {
+		server.archive_downloads = make(map[string]bool)
+	}
+	server.archive_downloads[key] = true
+	server.archive_lock.Unlock()
+	defer func() {
+		server.archive_lock.Lock()
+		delete(server.archive_downloads, key)
+		server.archive_lock.Unlock()
+	}()
+	added := time.Now()
+	tmpname, err := archive_temp_name(dir)
+	if err != nil {
+		server.Log_write("Failed to archive "+fname+" from "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	defer os.Remove(tmpname)
+	if _, err := server.File_download(ch, fname, tmpname); err != nil {
+		server.Log_write("Failed to archive "+fname+" from "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	server.archive_lock.Lock()
+	defer server.archive_lock.Unlock()
+	index, err = archive_index_read(dir)
+	if err != nil {
+		server.Log_write("Failed to read the archive index of "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	local := index.Local_name(fname)
+	if err := os.Rename(tmpname, filepath.Join(dir, local)); err != nil {
+		server.Log_write("Failed to archive "+fname+" from "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	index.Files = append(index.Files, &tt_archived_file{
+		Name:     fname,
+		Local:    local,
+		Owner:    owner,
+		Size:     size,
+		Added:    added,
+		Archived: time.Now(),
+	})
+	if err := archive_index_write(dir, index); err != nil {
+		server.Log_write("Failed to write the archive index of "+ch.Path_read()+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// Returns the name of a new empty file in dir to download into, which is renamed once the download is complete.
+func archive_temp_name(dir string) (string, error) {
+	if err := dir_create(dir); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, ARCHIVE_TEMP_PREFIX)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// Archives a file which has just been posted, then prunes the archived channels.
+func (server *tt_server) Archive_added(ch *tt_channel, fname string) {
+	if server.Archive_file(ch, fname) {
+		server.Archive_prune_all()
+	}
+}
+
+// Archives every file not yet copied in the archived channels, then prunes them.
+// Used after logging in, to catch up on files posted while the bot was away.
+func (server *tt_server) Archive_check_all() int {
+	count := 0
+	for _, ac := range server.Archives_read() {
+		ch := server.Channel_find_path_exact(ac.Path)
+		if ch == nil {
+			server.Log_write("Unable to archive "+ac.Path+". The channel doesn't exist.", true)
+			continue
+		}
+		for _, f := range ch.Files_read() {
+			if server.Archive_file(ch, ch.File_name(f)) {
+				count++
+			}
+		}
+	}
+	server.Archive_prune_all()
+	return count
+}
+
+// Returns the files of ch which the archive rules say should be removed from the server.
+// Only files with a local copy are ever removed.
+func (server *tt_server) Archive_prune_list(ac tt_archive_channel, ch *tt_channel, index *tt_archive_index) []*tt_archived_file {
+	type candidate struct {
+		af   *tt_archived_file
+		size int
+	}
+	candidates := []candidate{}
+	for _, f := range ch.Files_read() {
+		af := index.Find(ch.File_name(f), ch.File_owner(f), ch.File_size(f))
+		if af == nil {
+			continue
+		}
+		candidates = append(candidates, candidate{af, ch.File_size(f)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].af.Added.Before(candidates[j].af.Added)
+	})
+	prune := []*tt_archived_file{}
+	age, aged := time_duration_parse(ac.PruneAfter)
+	used := ch.Files_size()
+	limit := ch.Quota_read() * ac.PruneAt / 100
+	for _, c := range candidates {
+		if aged && time.Since(c.af.Added) >= age {
+			prune = append(prune, c.af)
+			used -= c.size
+			continue
+		}
+		if ac.PruneAt > 0 && ch.Quota_read() > 0 && used >= limit {
+			prune = append(prune, c.af)
+			used -= c.size
+		}
+	}
+	return prune
+}
+
+// Removes files from the server according to the archive rules of each channel.
+// A file which can't be removed is only tried again after the bot reconnects.
+// Only one prune runs at a time, and the archive lock isn't held while files are removed.
+func (server *tt_server) Archive_prune_all() int {
+	server.Lock()
+	if server.archive_pruning {
+		server.Unlock()
+		return 0
+	}
+	server.archive_pruning = true
+	server.Unlock()
+	defer func() {
+		server.Lock()
+		server.archive_pruning = false
+		server.Unlock()
+	}()
+	count := 0
+	for _, ac := range server.Archives_read() {
+		if ac.PruneAfter == "" && ac.PruneAt == 0 {
+			continue
+		}
+		ch := server.Channel_find_path_exact(ac.Path)
+		if ch == nil {
+			continue
+		}
+		dir := server.Archive_dir(ac.Path)
+		server.archive_lock.Lock()
+		index, err := archive_index_read(dir)
+		server.archive_lock.Unlock()
+		if err != nil {
+			continue
+		}
+		pruned := make(map[string]bool)
+		for _, af := range server.Archive_prune_list(ac, ch, index) {
+			key := ac.Path + af.Name
+			server.Lock()
+			failed := server.prune_failed[key]
+			server.Unlock()
+			if failed {
+				continue
+			}
+			if !server.cmd_delete_file(ch.Id_read(), af.Name) {
+				server.Lock()
+				if server.prune_failed == nil {
+					server.prune_failed = make(map[string]bool)
+				}
+				server.prune_failed[key] = true
+				server.Unlock()
+				server.Log_write("Failed to remove "+af.Name+" from "+ac.Path+". The local copy is kept in the archive.", true)
+				continue
+			}
+			pruned[af.Local] = true
+			count++
+			server.Log_write("Removed "+af.Name+" from "+ac.Path+". The local copy is kept in the archive.", false)
+		}
+		if len(pruned) == 0 {
+			continue
+		}
+		// The index is read again, since files may have been archived while others were being removed.
+		server.archive_lock.Lock()
+		index, err = archive_index_read(dir)
+		if err == nil {
+			for _, af := range index.Files {
+				if pruned[af.Local] {
+					af.Pruned = true
+				}
+			}
+			err = archive_index_write(dir, index)
+		}
+		server.archive_lock.Unlock()
+		if err != nil {
+			server.Log_write("Failed to update the archive index of "+ac.Path+": "+err.Error(), true)
+		}
+	}
+	return count
+}
+
+func (server *tt_server) Archive_status() string {
+	archives := server.Archives_read()
+	if len(archives) == 0 {
+		return "No channels are archived."
+	}
+	msg := ""
+	for _, ac := range archives {
+		dir := server.Archive_dir(ac.Path)
+		msg += ac.Info_str() + "\r\n"
+		msg += "Directory: " + dir + "\r\n"
+		server.archive_lock.Lock()
+		index, err := archive_index_read(dir)
+		server.archive_lock.Unlock()
+		if err != nil {
+			msg += "Unable to read the index: " + err.Error() + "\r\n"
+			continue
+		}
+		size := 0
+		pruned := 0
+		var last time.Time
+		for _, af := range index.Files {
+			size += af.Size
+			if af.Pruned {
+				pruned++
+			}
+			if af.Archived.After(last) {
+				last = af.Archived
+			}
+		}
+		msg += "Archived: " + strconv.Itoa(len(index.Files)) + " files, " + size_str(size) + ", " + strconv.Itoa(pruned) + " removed from the server\r\n"
+		if !last.IsZero() {
+			msg += "Last archived: " + time_duration_str(time.Since(last).Round(time.Second)) + " ago\r\n"
+		}
+		ch := server.Channel_find_path_exact(ac.Path)
+		if ch == nil {
+			msg += "The channel doesn't exist.\r\n"
+			continue
+		}
+		waiting := 0
+		for _, f := range ch.Files_read() {
+			if index.Find(ch.File_name(f), ch.File_owner(f), ch.File_size(f)) == nil {
+				waiting++
+			}
+		}
+		msg += "On the server: " + strconv.Itoa(len(ch.Files_read())) + " files, " + size_str(ch.Files_size()) + " of " + size_str(ch.Quota_read()) + " used"
+		if waiting != 0 {
+			msg += ", " + strconv.Itoa(waiting) + " not yet archived"
+		}
+		msg += "\r\n"
+	}
+	return msg
+}
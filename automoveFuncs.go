@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for automatically moving users between channels according to an ordered list of rules.
+// Channels are referred to by path, so rules keep working when channel ids change between connections.
+
+// Users who have just logged in are given a moment to join their initial channel before they are moved.
+const AUTOMOVE_LOGIN_DELAY = time.Millisecond * 500
+
+type tt_automove_rule struct {
+	From       string `xml:"from,attr,omitempty"`
+	To         string `xml:"to,attr"`
+	UserName   string `xml:"username,omitempty"`
+	NickName   string `xml:"nickname,omitempty"`
+	UserType   string `xml:"usertype,omitempty"`
+	ClientName string `xml:"clientname,omitempty"`
+	IpRange    string `xml:"ipRange,omitempty"`
+	Window     string `xml:"window,omitempty"`
+	Delay      string `xml:"delay,omitempty"`
+}
+
+func channel_path_clean(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// Reports whether ip is within rng, which may be a CIDR block such as 10.0.0.0/8,
+// a range such as 10.0.0.1-10.0.0.50, or a pattern such as 192.168.*.
+func ip_range_match(rng, ip string) bool {
+	addr := net.ParseIP(ip)
+	if _, block, err := net.ParseCIDR(rng); err == nil {
+		return addr != nil && block.Contains(addr)
+	}
+	if bounds := strings.Split(rng, "-"); len(bounds) == 2 {
+		low := net.ParseIP(strings.TrimSpace(bounds[0]))
+		high := net.ParseIP(strings.TrimSpace(bounds[1]))
+		if low != nil && high != nil {
+			return addr != nil && bytes.Compare(addr.To16(), low.To16()) >= 0 && bytes.Compare(addr.To16(), high.To16()) <= 0
+		}
+	}
+	return str_glob_match(rng, ip)
+}
+
+func ip_range_valid(rng string) bool {
+	if _, _, err := net.ParseCIDR(rng); err == nil {
+		return true
+	}
+	if bounds := strings.Split(rng, "-"); len(bounds) == 2 {
+		return net.ParseIP(strings.TrimSpace(bounds[0])) != nil && net.ParseIP(strings.TrimSpace(bounds[1])) != nil
+	}
+	return rng != ""
+}
+
+func (rule *tt_automove_rule) Delay_read() time.Duration {
+	d, _ := time_duration_parse(rule.Delay)
+	if rule.From == "" && d < AUTOMOVE_LOGIN_DELAY {
+		return AUTOMOVE_LOGIN_DELAY
+	}
+	return d
+}
+
+// Reports whether the rule applies to usr, who has just joined ch, or has just logged in if ch is nil.
+func (rule *tt_automove_rule) Matches(usr *tt_user, ch *tt_channel, now time.Time) bool {
+	if rule.From == "" {
+		if ch != nil {
+			return false
+		}
+	} else if ch == nil || strings.ToLower(ch.Path_read()) != strings.ToLower(rule.From) {
+		return false
+	}
+	if rule.Window != "" {
+		start, end, ok := time_window_parse(rule.Window)
+		if !ok || !time_window_check(start, end, now) {
+			return false
+		}
+	}
+	if rule.UserName != "" && !str_glob_match(rule.UserName, usr.UserName_read()) {
+		return false
+	}
+	if rule.NickName != "" && !str_glob_match(rule.NickName, usr.NickName_read()) {
+		return false
+	}
+	if rule.UserType != "" && rule.UserType != usr.UserType_read_str() {
+		return false
+	}
+	if rule.ClientName != "" && !str_glob_match(rule.ClientName, usr.ClientName_read()) {
+		return false
+	}
+	if rule.IpRange != "" && !ip_range_match(rule.IpRange, usr.Ip_read()) {
+		return false
+	}
+	return true
+}
+
+func (rule *tt_automove_rule) Info_str() string {
+	str := ""
+	if rule.From != "" {
+		str = "from " + rule.From
+	} else {
+		str = "on login"
+	}
+	str += " to " + rule.To
+	if rule.UserName != "" {
+		str += ", username " + rule.UserName
+	}
+	if rule.NickName != "" {
+		str += ", nickname " + rule.NickName
+	}
+	if rule.UserType != "" {
+		str += ", user type " + rule.UserType
+	}
+	if rule.ClientName != "" {
+		str += ", client " + rule.ClientName
+	}
+	if rule.IpRange != "" {
+		str += ", IP address " + rule.IpRange
+	}
+	if rule.Window != "" {
+		str += ", between " + strings.Replace(rule.Window, "-", " and ", 1)
+	}
+	if d, ok := time_duration_parse(rule.Delay); ok {
+		str += ", after " + time_duration_str(d)
+	}
+	return str
+}
+
+// Sets an option of the rule from a name and value, such as nickname and Guest*.
+func (rule *tt_automove_rule) Option_set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "from":
+		if value == "" {
+			rule.From = ""
+			break
+		}
+		rule.From = channel_path_clean(value)
+	case "to":
+		if value == "" {
+			return errors.New("The destination channel is required.")
+		}
+		rule.To = channel_path_clean(value)
+	case "username":
+		rule.UserName = value
+	case "nickname":
+		rule.NickName = value
+	case "usertype":
+		switch strings.ToLower(value) {
+		case "", "any":
+			rule.UserType = ""
+		case TT_USERTYPE_DEFAULT_STR, TT_USERTYPE_ADMIN_STR:
+			rule.UserType = strings.ToLower(value)
+		default:
+			return errors.New(value + " isn't a valid user type. The user type must be default or admin.")
+		}
+	case "client":
+		rule.ClientName = value
+	case "ip":
+		if value != "" && !ip_range_valid(value) {
+			return errors.New(value + " isn't a valid IP address range.")
+		}
+		rule.IpRange = value
+	case "time":
+		if value != "" {
+			if _, _, ok := time_window_parse(value); !ok {
+				return errors.New(value + " isn't a valid time window. Use a start and end time, such as 22:00-06:00.")
+			}
+		}
+		rule.Window = value
+	case "delay":
+		if value != "" {
+			if _, ok := time_duration_parse(value); !ok {
+				return errors.New(value + " isn't a valid duration.")
+			}
+		}
+		rule.Delay = value
+	default:
+		return errors.New("Unrecognized option: " + name)
+	}
+	return nil
+}
+
+func (server *tt_server) AutoMoveRules_read() []*tt_automove_rule {
+	defer server.Unlock()
+	server.Lock()
+	rules := make([]*tt_automove_rule, len(server.AutoMoveRules))
+	copy(rules, server.AutoMoveRules)
+	return rules
+}
+
+func (server *tt_server) AutoMove_add(rule *tt_automove_rule) {
+	defer server.Unlock()
+	server.Lock()
+	server.AutoMoveRules = append(server.AutoMoveRules, rule)
+}
+
+func (server *tt_server) AutoMove_remove(rule *tt_automove_rule) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, r := range server.AutoMoveRules {
+		if r == rule {
+			server.AutoMoveRules = append(server.AutoMoveRules[:i], server.AutoMoveRules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Moves a rule to a new position in the list, counting from 0.
+func (server *tt_server) AutoMove_reorder(rule *tt_automove_rule, index int) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, r := range server.AutoMoveRules {
+		if r == rule {
+			return slice_move(server.AutoMoveRules, i, index)
+		}
+	}
+	return false
+}
+
+func (server *tt_server) AutoMove_clear() {
+	defer server.Unlock()
+	server.Lock()
+	server.AutoMoveRules = nil
+}
+
+// Converts the automove settings of older versions, which referred to channels by id, into a rule.
+// Returns true if the configuration has changed.
+func (server *tt_server) AutoMove_migrate() bool {
+	server.Lock()
+	from := server.AutoMoveFrom
+	to := server.AutoMoveTo
+	server.Unlock()
+	if from == 0 && to == 0 {
+		return false
+	}
+	rule := &tt_automove_rule{}
+	ch_dest := server.Channel_find_id(to)
+	if ch_dest == nil {
+		server.Log_write("Automove settings refer to a destination channel which doesn't exist. They have been kept, but can't be used.", true)
+		return false
+	}
+	rule.To = ch_dest.Path_read()
+	if from != 0 {
+		ch_src := server.Channel_find_id(from)
+		if ch_src == nil || ch_src == ch_dest {
+			server.Log_write("Automove settings refer to a source channel which doesn't exist. They have been kept, but can't be used.", true)
+			return false
+		}
+		rule.From = ch_src.Path_read()
+	}
+	server.AutoMove_add(rule)
+	server.Lock()
+	server.AutoMoveFrom = 0
+	server.AutoMoveTo = 0
+	server.Unlock()
+	server.Log_write("Automove settings converted to a rule: "+rule.Info_str(), false)
+	return true
+}
+
+// Returns the first rule which applies to usr, who has just joined ch, or has just logged in if ch is nil.
+func (server *tt_server) AutoMove_match(usr *tt_user, ch *tt_channel) *tt_automove_rule {
+	now := time.Now()
+	for _, rule := range server.AutoMoveRules_read() {
+		if rule.Matches(usr, ch, now) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Reports whether users in ch may be moved out of it by a rule.
+func (server *tt_server) AutoMove_source_check(ch *tt_channel) bool {
+	path := strings.ToLower(ch.Path_read())
+	for _, rule := range server.AutoMoveRules_read() {
+		if strings.ToLower(rule.From) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves usr according to the first rule which applies to them.
+// ch is the channel usr has just joined, or nil if they have just logged in.
+func (server *tt_server) automove(usr *tt_user, ch *tt_channel) {
+	if usr == nil {
+		return
+	}
+	uid := usr.Uid_read()
+	if uid == server.Uid_read() {
+		return
+	}
+	rule := server.AutoMove_match(usr, ch)
+	if rule == nil {
+		return
+	}
+	server.Lock()
+	if server.automove_wait == nil {
+		server.automove_wait = make(map[int]bool)
+	}
+	if server.automove_wait[uid] {
+		server.Unlock()
+		return
+	}
+	server.automove_wait[uid] = true
+	server.Unlock()
+	defer func() {
+		server.Lock()
+		delete(server.automove_wait, uid)
+		server.Unlock()
+	}()
+	lnickname := usr.NickName_log()
+	if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
+		server.Log_write("Unable to automatically move "+lnickname+". Insufficient user rights.", true)
+		return
+	}
+	time.Sleep(rule.Delay_read())
+	if server.User_find_id(uid) != usr {
+		return
+	}
+	ch_dest := server.Channel_find_path_exact(rule.To)
+	if ch_dest == nil {
+		server.Log_write("Unable to automatically move "+lnickname+". The destination channel "+rule.To+" doesn't exist.", true)
+		return
+	}
+	current := usr.Channel_read()
+	if current == ch_dest {
+		return
+	}
+	// The user has left the channel they were to be moved from.
+	if ch != nil && current != ch {
+		return
+	}
+	if !server.cmd_move_user(uid, ch_dest.Id_read()) {
+		server.Log_console("Automatic user move for "+lnickname+" failed.", true)
+		return
+	}
+	if ch != nil {
+		server.Log_console(lnickname+" automatically moved from "+ch.Path_read()+" to "+ch_dest.Path_read(), false)
+		return
+	}
+	server.Log_console(lnickname+" automatically moved to "+ch_dest.Path_read(), false)
+}
+
+// Applies the rules to every user who is in a channel, returning how many users a rule applies to.
+func (server *tt_server) AutoMove_apply() int {
+	count := 0
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		ch := usr.Channel_read()
+		if ch == nil || server.AutoMove_match(usr, ch) == nil {
+			continue
+		}
+		count++
+		go server.automove(usr, ch)
+	}
+	return count
+}
+
+func (server *tt_server) AutoMove_list() {
+	rules := server.AutoMoveRules_read()
+	if len(rules) == 0 {
+		console_write("No automove rules have been added.")
+		return
+	}
+	msg := strconv.Itoa(len(rules)) + " automove rule"
+	if len(rules) != 1 {
+		msg += "s"
+	}
+	msg += ", the first matching rule is used:\r\n"
+	for i, rule := range rules {
+		msg += strconv.Itoa(i+1) + ": " + rule.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
+
+// Selects a rule by its number, or with a menu if val isn't a rule number.
+func (server *tt_server) AutoMove_menu(val, prompt string) (*tt_automove_rule, bool) {
+	rules := server.AutoMoveRules_read()
+	menu := []string{}
+	for _, rule := range rules {
+		menu = append(menu, rule.Info_str())
+	}
+	res, aborted := rule_menu(menu, val, prompt, "No automove rules have been added.")
+	if aborted {
+		return nil, true
+	}
+	return rules[res], false
+}
+
+// Selects a channel path, preferring an exact match, then searching the channels of the server.
+func (server *tt_server) AutoMove_channel_select(val string) (string, bool) {
+	ch, aborted := server.Channel_select(val)
+	if aborted {
+		return "", true
+	}
+	return ch.Path_read(), false
+}
+
+func (server *tt_server) AutoMove_add_prompt() *tt_automove_rule {
+	rule := &tt_automove_rule{}
+	console_write("Selecting the channel to move users to.")
+	path, aborted := server.AutoMove_channel_select("")
+	if aborted {
+		return nil
+	}
+	rule.To = path
+	answer, aborted := console_read_confirm("Would you like to move users as they join a particular channel? Otherwise, users will be moved as they log in.\r\n")
+	if aborted {
+		return nil
+	}
+	if answer {
+		console_write("Selecting the channel to move users from.")
+		path, aborted := server.AutoMove_channel_select("")
+		if aborted {
+			return nil
+		}
+		if path == rule.To {
+			console_write("The source and destination channels are the same.")
+			return nil
+		}
+		rule.From = path
+	}
+	res, aborted := console_read_menu("Please select the type of user to move.\r\n", []string{"any", TT_USERTYPE_DEFAULT_STR, TT_USERTYPE_ADMIN_STR})
+	if aborted || res == -1 {
+		return nil
+	}
+	if res != 0 {
+		rule.UserType = []string{"", TT_USERTYPE_DEFAULT_STR, TT_USERTYPE_ADMIN_STR}[res]
+	}
+	prompts := []struct {
+		option string
+		prompt string
+	}{
+		{"username", "Please enter the usernames to move, such as guest*, or nothing for any username."},
+		{"nickname", "Please enter the nicknames to move, such as *bot, or nothing for any nickname."},
+		{"client", "Please enter the client names to move, such as TeamTalk*, or nothing for any client."},
+		{"ip", "Please enter the IP addresses to move, such as 10.0.0.0/8, 10.0.0.1-10.0.0.50 or 192.168.*, or nothing for any IP address."},
+		{"time", "Please enter the time of day during which the rule applies, such as 22:00-06:00, or nothing for any time."},
+		{"delay", "Please enter how long to wait before moving users, such as 30s, or nothing to move them straight away."},
+	}
+	for _, p := range prompts {
+		for {
+			val, err := console_read_prompt(p.prompt)
+			if err != nil {
+				return nil
+			}
+			if err := rule.Option_set(p.option, val); err != nil {
+				console_write(err.Error())
+				continue
+			}
+			break
+		}
+	}
+	return rule
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Functions for automatically subscribing to users according to an ordered list of rules.
+// Every matching rule is applied in turn, on top of the automatic subscriptions of the server,
+// or on top of the subscriptions the server gave the user if no automatic subscriptions are set.
+
+const (
+	AUTOSUB_GROUP_ANONYMOUS = "anonymous"
+	AUTOSUB_SOURCE_DEFAULT  = "automatic subscriptions"
+	AUTOSUB_SOURCE_SERVER   = "server defaults"
+)
+
+type tt_autosub_rule struct {
+	UserName string `xml:"username,attr,omitempty"`
+	Group    string `xml:"group,attr,omitempty"`
+	Channel  string `xml:"channel,attr,omitempty"`
+	Set      string `xml:"set,omitempty"`
+	Add      string `xml:"add,omitempty"`
+	Remove   string `xml:"remove,omitempty"`
+}
+
+func autosub_flags(str string) int {
+	flags, _ := teamtalk_flags_subscriptions_parse(str)
+	return flags
+}
+
+// Reports whether the rule applies to usr. Rules for a channel also apply to its subchannels.
+func (rule *tt_autosub_rule) Matches(usr *tt_user) bool {
+	if rule.UserName != "" && !str_glob_match(rule.UserName, usr.UserName_read()) {
+		return false
+	}
+	switch rule.Group {
+	case "":
+	case AUTOSUB_GROUP_ANONYMOUS:
+		if usr.UserName_read() != "" {
+			return false
+		}
+	default:
+		if rule.Group != usr.UserType_read_str() {
+			return false
+		}
+	}
+	if rule.Channel != "" {
+		ch := usr.Channel_read()
+		if ch == nil || !strings.HasPrefix(strings.ToLower(ch.Path_read()), strings.ToLower(rule.Channel)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the subscriptions produced by applying the rule to subs.
+func (rule *tt_autosub_rule) Apply(subs int) int {
+	if rule.Set != "" {
+		subs = autosub_flags(rule.Set)
+	}
+	subs |= autosub_flags(rule.Add)
+	subs &^= autosub_flags(rule.Remove)
+	return subs
+}
+
+func (rule *tt_autosub_rule) Info_str() string {
+	who := []string{}
+	if rule.UserName != "" {
+		who = append(who, "username "+rule.UserName)
+	}
+	if rule.Group != "" {
+		who = append(who, rule.Group+" users")
+	}
+	if rule.Channel != "" {
+		who = append(who, "in "+rule.Channel)
+	}
+	str := "everyone"
+	if len(who) != 0 {
+		str = strings.Join(who, ", ")
+	}
+	if rule.Set != "" {
+		str += ": set " + teamtalk_flags_subscriptions_str(autosub_flags(rule.Set))
+	}
+	if rule.Add != "" {
+		str += ": add " + teamtalk_flags_subscriptions_str(autosub_flags(rule.Add))
+	}
+	if rule.Remove != "" {
+		str += ": remove " + teamtalk_flags_subscriptions_str(autosub_flags(rule.Remove))
+	}
+	return str
+}
+
+// Sets an option of the rule from a name and value, such as group and anonymous.
+func (rule *tt_autosub_rule) Option_set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "username":
+		rule.UserName = value
+	case "group":
+		switch strings.ToLower(value) {
+		case "", "any":
+			rule.Group = ""
+		case AUTOSUB_GROUP_ANONYMOUS, TT_USERTYPE_DEFAULT_STR, TT_USERTYPE_ADMIN_STR:
+			rule.Group = strings.ToLower(value)
+		default:
+			return errors.New(value + " isn't a valid group. The group must be anonymous, default or admin.")
+		}
+	case "channel":
+		if value == "" {
+			rule.Channel = ""
+			break
+		}
+		rule.Channel = channel_path_clean(value)
+	case "set", "add", "remove":
+		flags, ok := teamtalk_flags_subscriptions_parse(value)
+		if !ok {
+			return errors.New(value + " isn't a valid list of subscriptions.")
+		}
+		str := teamtalk_flags_subscriptions_str(flags)
+		switch strings.ToLower(name) {
+		case "set":
+			if value == "" {
+				str = ""
+			} else if flags == TT_SUBSCRIBE_NONE {
+				str = TT_SUBSCRIBE_NONE_STR
+			}
+			rule.Set = str
+		case "add":
+			rule.Add = str
+		case "remove":
+			rule.Remove = str
+		}
+	default:
+		return errors.New("Unrecognized option: " + name)
+	}
+	return nil
+}
+
+func (server *tt_server) AutoSubRules_read() []*tt_autosub_rule {
+	defer server.Unlock()
+	server.Lock()
+	rules := make([]*tt_autosub_rule, len(server.AutoSubRules))
+	copy(rules, server.AutoSubRules)
+	return rules
+}
+
+func (server *tt_server) AutoSub_add(rule *tt_autosub_rule) {
+	defer server.Unlock()
+	server.Lock()
+	server.AutoSubRules = append(server.AutoSubRules, rule)
+}
+
+func (server *tt_server) AutoSub_remove(rule *tt_autosub_rule) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, r := range server.AutoSubRules {
+		if r == rule {
+			server.AutoSubRules = append(server.AutoSubRules[:i], server.AutoSubRules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Moves a rule to a new position in the list, counting from 0.
+func (server *tt_server) AutoSub_reorder(rule *tt_autosub_rule, index int) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, r := range server.AutoSubRules {
+		if r == rule {
+			return slice_move(server.AutoSubRules, i, index)
+		}
+	}
+	return false
+}
+
+// Returns the subscriptions usr should have, and what produced them.
+// Returns false if automatic subscriptions are disabled.
+func (server *tt_server) AutoSub_evaluate(usr *tt_user) (int, string, bool) {
+	rules := server.AutoSubRules_read()
+	subs := server.AutoSubscriptions_read()
+	source := AUTOSUB_SOURCE_DEFAULT
+	if subs == 0 {
+		if len(rules) == 0 {
+			return 0, "", false
+		}
+		subs = usr.AutoSubBase_read()
+		source = AUTOSUB_SOURCE_SERVER
+	}
+	applied := []string{}
+	for i, rule := range rules {
+		if rule.Matches(usr) {
+			subs = rule.Apply(subs)
+			applied = append(applied, strconv.Itoa(i+1))
+		}
+	}
+	if len(applied) == 1 {
+		source += ", rule " + applied[0]
+	} else if len(applied) > 1 {
+		source += ", rules " + strings.Join(applied, ", ")
+	}
+	return subs, source, true
+}
+
+// Applies automatic subscriptions to every user, returning how many users had their subscriptions changed.
+func (server *tt_server) AutoSub_apply() int {
+	count := 0
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		if server.autosubscribe(usr) {
+			count++
+		}
+	}
+	return count
+}
+
+func (server *tt_server) AutoSub_list() {
+	msg := "Automatic subscriptions: "
+	if sub_str := server.AutoSubscriptions_read_str(); sub_str != "" {
+		msg += sub_str + "\r\n"
+	} else {
+		msg += "disabled, rules apply to the subscriptions given by the server\r\n"
+	}
+	rules := server.AutoSubRules_read()
+	if len(rules) == 0 {
+		console_write(msg + "No automatic subscription rules have been added.")
+		return
+	}
+	msg += strconv.Itoa(len(rules)) + " automatic subscription rule"
+	if len(rules) != 1 {
+		msg += "s"
+	}
+	msg += ", applied in order:\r\n"
+	for i, rule := range rules {
+		msg += strconv.Itoa(i+1) + ": " + rule.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
+
+// Lists the local subscriptions of each user, and the rules which produced them.
+func (server *tt_server) AutoSub_users_list() {
+	users := server.Users_sort(server.Uid_read())
+	if len(users) == 0 {
+		console_write("No users are logged in.")
+		return
+	}
+	msg := ""
+	for _, usr := range users {
+		msg += usr.NickName_log() + ": " + usr.Subscriptions_local_read_str()
+		if source := usr.AutoSubSource_read(); source != "" {
+			msg += " (" + source + ")"
+		}
+		msg += "\r\n"
+	}
+	console_write(msg)
+}
+
+// Selects a rule by its number, or with a menu if val isn't a rule number.
+func (server *tt_server) AutoSub_menu(val, prompt string) (*tt_autosub_rule, bool) {
+	rules := server.AutoSubRules_read()
+	menu := []string{}
+	for _, rule := range rules {
+		menu = append(menu, rule.Info_str())
+	}
+	res, aborted := rule_menu(menu, val, prompt, "No automatic subscription rules have been added.")
+	if aborted {
+		return nil, true
+	}
+	return rules[res], false
+}
+
+func (server *tt_server) AutoSub_add_prompt() *tt_autosub_rule {
+	rule := &tt_autosub_rule{}
+	val, err := console_read_prompt("Please enter the usernames the rule applies to, such as guest*, or nothing for any username.")
+	if err != nil {
+		return nil
+	}
+	rule.UserName = val
+	groups := []string{"any", AUTOSUB_GROUP_ANONYMOUS, TT_USERTYPE_DEFAULT_STR, TT_USERTYPE_ADMIN_STR}
+	res, aborted := console_read_menu("Please select the group of users the rule applies to.\r\n", groups)
+	if aborted || res == -1 {
+		return nil
+	}
+	if res != 0 {
+		rule.Group = groups[res]
+	}
+	answer, aborted := console_read_confirm("Would you like the rule to apply only to users in a particular channel and its subchannels?\r\n")
+	if aborted {
+		return nil
+	}
+	if answer {
+		ch, aborted := server_menu_channel(server, "")
+		if aborted {
+			return nil
+		}
+		if ch == nil {
+			console_write("Channel not found. Aborted.")
+			return nil
+		}
+		rule.Channel = ch.Path_read()
+	}
+	res, aborted = console_read_menu("Please select what the rule does.\r\n", []string{"Replace the subscriptions", "Add and remove subscriptions"})
+	if aborted || res == -1 {
+		return nil
+	}
+	if res == 0 {
+		console_write("Selecting the subscriptions to replace them with.")
+		flags, aborted := teamtalk_flags_subscriptions_menu(TT_SUBSCRIBE_NONE)
+		if aborted {
+			return nil
+		}
+		rule.Set = teamtalk_flags_subscriptions_str(flags)
+		if flags == TT_SUBSCRIBE_NONE {
+			rule.Set = TT_SUBSCRIBE_NONE_STR
+		}
+		return rule
+	}
+	console_write("Selecting the subscriptions to add.")
+	flags, aborted := teamtalk_flags_subscriptions_menu(TT_SUBSCRIBE_NONE)
+	if aborted {
+		return nil
+	}
+	rule.Add = teamtalk_flags_subscriptions_str(flags)
+	console_write("Selecting the subscriptions to remove.")
+	flags, aborted = teamtalk_flags_subscriptions_menu(TT_SUBSCRIBE_NONE)
+	if aborted {
+		return nil
+	}
+	rule.Remove = teamtalk_flags_subscriptions_str(flags)
+	if rule.Add == "" && rule.Remove == "" {
+		console_write("The rule doesn't change any subscriptions. Aborted.")
+		return nil
+	}
+	return rule
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for listing and managing user bans.
+
+// Returns a key identifying a ban. A server can ban the same address by IP address and by username, so every field is needed to tell them apart.
+func ban_key(params map[string]string) string {
+	fields := []string{}
+	for _, field := range []string{"ipaddr", "username", "chanpath", "bantype"} {
+		fields = append(fields, teamtalk_param_str(params, field))
+	}
+	return strings.Join(fields, "|")
+}
+
+func ban_info_str(ban map[string]string) string {
+	str := ""
+	if ip := teamtalk_param_str(ban, "ipaddr"); ip != "" {
+		str += ip
+	}
+	if username := teamtalk_param_str(ban, "username"); username != "" {
+		if str != "" {
+			str += ", "
+		}
+		str += "username " + username
+	}
+	if nickname := teamtalk_param_str(ban, "nickname"); nickname != "" {
+		str += " (" + nickname + ")"
+	}
+	if chanpath := teamtalk_param_str(ban, "chanpath"); chanpath != "" {
+		str += ", banned from " + chanpath
+	} else {
+		str += ", banned from the server"
+	}
+	if owner := teamtalk_param_str(ban, "owner"); owner != "" {
+		str += " by " + owner
+	}
+	if bantime := teamtalk_param_str(ban, "bantime"); bantime != "" {
+		str += " on " + bantime
+	}
+	return str
+}
+
+func (server *tt_server) Bans_read() map[string]map[string]string {
+	defer server.Unlock()
+	server.Lock()
+	bans := make(map[string]map[string]string)
+	for key, ban := range server.bans {
+		bans[key] = make(map[string]string)
+		for k, v := range ban {
+			bans[key][k] = v
+		}
+	}
+	return bans
+}
+
+func (server *tt_server) Bans_find(filter string) []map[string]string {
+	bans := server.Bans_read()
+	found := []map[string]string{}
+	filter = strings.ToLower(filter)
+	for _, key := range mapKeys_sorted(bans) {
+		ban := bans[key]
+		if filter == "" {
+			found = append(found, ban)
+			continue
+		}
+		for _, field := range []string{"ipaddr", "username", "nickname", "chanpath", "owner"} {
+			if strings.Contains(strings.ToLower(teamtalk_param_str(ban, field)), filter) {
+				found = append(found, ban)
+				break
+			}
+		}
+	}
+	return found
+}
+
+func (server *tt_server) Bans_list(filter string) {
+	server.cmd_list_bans()
+	bans := server.Bans_find(filter)
+	if len(bans) == 0 {
+		if filter != "" {
+			console_write("No bans were found matching " + filter + ".")
+		} else {
+			console_write("No bans were found.")
+		}
+		return
+	}
+	msg := strconv.Itoa(len(bans)) + " ban"
+	if len(bans) != 1 {
+		msg += "s"
+	}
+	msg += " found"
+	if filter != "" {
+		msg += " matching " + filter
+	}
+	msg += ":\r\n"
+	for _, ban := range bans {
+		msg += ban_info_str(ban)
+		if tb := server.Tempban_find(ban); tb != nil {
+			msg += ", " + tb.Expiry_str()
+		}
+		msg += "\r\n"
+	}
+	console_write(msg)
+}
+
+func server_menu_bantype(usr *tt_user) (int, bool) {
+	menu := []string{TT_BANTYPE_IPADDR_STR}
+	menu_flags := []int{TT_BANTYPE_IPADDR}
+	if usr.UserName_read() != "" {
+		menu = append(menu, TT_BANTYPE_USERNAME_STR, TT_BANTYPE_IPADDR_STR+" and "+TT_BANTYPE_USERNAME_STR)
+		menu_flags = append(menu_flags, TT_BANTYPE_USERNAME, TT_BANTYPE_IPADDR|TT_BANTYPE_USERNAME)
+	}
+	if len(menu) == 1 {
+		return menu_flags[0], false
+	}
+	res, aborted := console_read_menu("Please select what to ban "+usr.NickName_log()+" by.\r\n", menu)
+	if aborted || res == -1 {
+		return TT_BANTYPE_NONE, true
+	}
+	return menu_flags[res], false
+}
+
+// Asks whether action applies to the entire server or only the channel usr is in.
+// Returns a nil channel for the entire server.
+func server_menu_user_scope(usr *tt_user, action string) (*tt_channel, bool) {
+	ch := usr.Channel_read()
+	if ch == nil {
+		return nil, false
+	}
+	menu := []string{"The entire server", "The channel " + ch.Path_read()}
+	res, aborted := console_read_menu("Please select where to "+action+" "+usr.NickName_log()+" from.\r\n", menu)
+	if aborted || res == -1 {
+		return nil, true
+	}
+	if res == 0 {
+		return nil, false
+	}
+	return ch, false
+}
+
+func ban_duration_read() (time.Duration, bool) {
+	for {
+		val, err := console_read_prompt("Please enter how long the ban should last, such as 30m, 2h, or 7d, or nothing for a permanent ban.")
+		if err != nil {
+			return 0, true
+		}
+		if val == "" {
+			return 0, false
+		}
+		d, ok := time_duration_parse(val)
+		if !ok {
+			console_write(val + " isn't a valid duration.")
+			continue
+		}
+		return d, false
+	}
+}
+
+func ban_duration_prompt() (time.Duration, string, bool) {
+	duration, aborted := ban_duration_read()
+	if aborted {
+		return 0, "", true
+	}
+	reason, err := console_read_prompt("Please enter a reason for the ban, or nothing for no reason.")
+	if err != nil {
+		return 0, "", true
+	}
+	return duration, reason, false
+}
+
+// Splits ban parameters into a user, an optional duration, and an optional reason.
+// A user containing spaces must be quoted.
+func ban_params_parse(param string) (string, time.Duration, string) {
+	params := stringSeperateParam(param, " ", "\"")
+	if len(params) == 0 {
+		return "", 0, ""
+	}
+	usrval := params[0]
+	if len(params) == 1 {
+		return usrval, 0, ""
+	}
+	var duration time.Duration
+	rest := params[1:]
+	if d, ok := time_duration_parse(rest[0]); ok {
+		duration = d
+		rest = rest[1:]
+	}
+	return usrval, duration, strings.Join(restoreParams(rest, " ", "\""), " ")
+}
+
+func (server *tt_server) Ban_user_prompt(usrval string, duration time.Duration, reason string, kick bool) bool {
+	if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		console_write("You don't have permission to ban users.")
+		return false
+	}
+	if kick && !server.User_rights_check(TT_USERRIGHT_KICK_USERS) {
+		console_write("You don't have permission to kick users.")
+		return false
+	}
+	guided := usrval == ""
+	usr, aborted := server_menu_user(server, usrval)
+	if aborted {
+		return false
+	}
+	if usr == nil {
+		console_write("No user selected. Aborted.")
+		return false
+	}
+	if usr.Uid_read() == server.Uid_read() {
+		console_write("Banning the bot isn't supported.")
+		return false
+	}
+	if guided {
+		duration, reason, aborted = ban_duration_prompt()
+		if aborted {
+			return false
+		}
+	}
+	bantype, aborted := server_menu_bantype(usr)
+	if aborted {
+		return false
+	}
+	ch, aborted := server_menu_user_scope(usr, "ban")
+	if aborted {
+		return false
+	}
+	cid := 0
+	where := "the server"
+	if ch != nil {
+		cid = ch.Id_read()
+		where = ch.Path_read()
+	}
+	lnickname := usr.NickName_log()
+	prompt := "You are about to ban " + lnickname + " by " + teamtalk_flags_bantype_str(bantype) + " from " + where
+	if duration > 0 {
+		prompt += " for " + time_duration_str(duration)
+	}
+	if reason != "" {
+		prompt += ", with the reason " + reason
+	}
+	if kick {
+		prompt += ", and kick them"
+	}
+	answer, aborted := console_read_confirm(prompt + ". Is this correct?\r\n")
+	if aborted {
+		return false
+	}
+	if !answer {
+		console_write("Aborted.")
+		return false
+	}
+	if !server.Ban_user(usr, bantype, ch, duration, reason) {
+		return false
+	}
+	if kick {
+		if !server.cmd_kick(usr.Uid_read(), cid) {
+			return false
+		}
+		server.Log_username_set(usr.UserName_read())
+		server.Log_write(lnickname+" has been kicked from "+where+".", false)
+	}
+	return true
+}
+
+// Bans usr from ch, or from the server if ch is nil.
+// The ban is removed automatically once duration has passed, unless duration is 0.
+func (server *tt_server) Ban_user(usr *tt_user, bantype int, ch *tt_channel, duration time.Duration, reason string) bool {
+	cid := 0
+	where := "the server"
+	if ch != nil {
+		bantype = teamtalk_flags_set(bantype, TT_BANTYPE_CHANNEL)
+		cid = ch.Id_read()
+		where = ch.Path_read()
+	}
+	if !server.cmd_ban(usr.Uid_read(), bantype, cid) {
+		return false
+	}
+	msg := usr.NickName_log() + " has been banned from " + where
+	if duration > 0 {
+		tb := &tt_tempban{
+			NickName: usr.NickName_read(),
+			BanType:  bantype,
+			Reason:   reason,
+			Owner:    server.AccountName_read(),
+			Created:  time.Now(),
+			Expires:  time.Now().Add(duration),
+		}
+		if ch != nil {
+			tb.ChanPath = where
+		}
+		if teamtalk_flags_read(bantype, TT_BANTYPE_IPADDR) {
+			tb.IpAddr = usr.Ip_read()
+		}
+		if teamtalk_flags_read(bantype, TT_BANTYPE_USERNAME) {
+			tb.UserName = usr.UserName_read()
+		}
+		if !server.Tempban_add(tb) {
+			server.Log_write("Warning: the expiry of the ban of "+usr.NickName_log()+" couldn't be saved. The ban must be removed manually.", true)
+		}
+		msg += " for " + time_duration_str(duration)
+	}
+	if reason != "" {
+		msg += ". Reason: " + reason
+	}
+	server.Log_username_set(usr.UserName_read())
+	server.Log_write(msg+".", false)
+	return true
+}
+
+// Returns the ban type to use when banning usr without asking,
+// which is by IP address and, if they have one, username.
+func ban_type_default(usr *tt_user) int {
+	if usr.UserName_read() != "" {
+		return TT_BANTYPE_IPADDR | TT_BANTYPE_USERNAME
+	}
+	return TT_BANTYPE_IPADDR
+}
+
+func (server *tt_server) Unban_prompt(filter string) bool {
+	if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		console_write("You don't have permission to remove bans.")
+		return false
+	}
+	server.cmd_list_bans()
+	bans := server.Bans_find(filter)
+	if len(bans) == 0 {
+		console_write("No bans were found to remove.")
+		return false
+	}
+	ban := bans[0]
+	if len(bans) > 1 {
+		menu := []string{}
+		for _, b := range bans {
+			menu = append(menu, ban_info_str(b))
+		}
+		res, aborted := console_read_menu("Please select a ban to remove.\r\n", menu)
+		if aborted || res == -1 {
+			return false
+		}
+		ban = bans[res]
+	}
+	answer, aborted := console_read_confirm("Do you wish to remove the ban " + ban_info_str(ban) + "?\r\n")
+	if aborted {
+		return false
+	}
+	if !answer {
+		console_write("Aborted.")
+		return false
+	}
+	bantype, _ := teamtalk_param_int(ban, "bantype")
+	if !server.cmd_unban(teamtalk_param_str(ban, "ipaddr"), teamtalk_param_str(ban, "username"), teamtalk_param_str(ban, "chanpath"), bantype) {
+		return false
+	}
+	if tb := server.Tempban_find(ban); tb != nil {
+		server.Tempban_remove(tb)
+	}
+	return true
+}
@@ -80,25 +80,18 @@ func (ch *tt_channel) Idparent_set(id int) {
 	ch.idparent = id
 }
 
+// Returns the path of the channel, built by following its parents up to the root channel.
 func (ch *tt_channel) Path_read() string {
-	ids := []int{}
-	channel := ch
-	server := ch.server
-	for {
-		ids = append(ids, channel.Id_read())
-		channel = server.Channel_find_id(channel.Idparent_read())
-		if channel == nil {
-			break
-		}
-	}
-	sort.Ints(ids)
+	server := ch.Server_read()
 	path := ""
-	for _, cid := range ids {
-		channel := server.Channel_find_id(cid)
-		if channel == nil {
+	seen := make(map[int]bool)
+	for channel := ch; channel != nil; channel = server.Channel_find_id(channel.Idparent_read()) {
+		cid := channel.Id_read()
+		if seen[cid] {
 			return ""
 		}
-		path += channel.Name_read() + "/"
+		seen[cid] = true
+		path = channel.Name_read() + "/" + path
 	}
 	return path
 }
@@ -230,6 +223,15 @@ func (ch *tt_channel) Operators_read_str() string {
 	return strings.TrimSuffix(str, ", ")
 }
 
+func (ch *tt_channel) Operator_check(uid int) bool {
+	for _, op := range ch.Operators_read() {
+		if op == uid {
+			return true
+		}
+	}
+	return false
+}
+
 func (ch *tt_channel) Operators_set(ops []int) {
 	defer ch.Unlock()
 	ch.Lock()
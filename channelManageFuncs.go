@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Functions for creating, updating and removing channels.
+
+var channel_properties = []string{"name", "topic", "password", "oppassword", "maxusers", "diskquota", "options", "audiocodec", "audiocfg"}
+
+// Parses a list of numbers such as 3,48000,2 or [3,48000,2].
+func int_list_parse(str string) ([]int, bool) {
+	str = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(str), "["), "]")
+	ints := []int{}
+	if str == "" {
+		return ints, true
+	}
+	for _, field := range strings.Split(str, ",") {
+		num, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, false
+		}
+		ints = append(ints, num)
+	}
+	return ints, true
+}
+
+// Returns the protocol parameter name and value used to set a channel property to value.
+func channel_property_param(name, value string) (string, string, error) {
+	switch strings.ToLower(name) {
+	case "name":
+		if value == "" || strings.Contains(value, "/") {
+			return "", "", errors.New("Channel names can't be empty or contain /.")
+		}
+		return "name", value, nil
+	case "topic", "password", "oppassword":
+		return strings.ToLower(name), value, nil
+	case "maxusers", "diskquota":
+		num, err := strconv.Atoi(value)
+		if err != nil || num < 0 {
+			return "", "", errors.New(value + " isn't a valid value for " + name + ".")
+		}
+		return strings.ToLower(name), strconv.Itoa(num), nil
+	case "options":
+		flags, ok := teamtalk_flags_channel_options_parse(value)
+		if !ok {
+			return "", "", errors.New(value + " isn't a valid list of channel options.")
+		}
+		return "type", strconv.Itoa(flags), nil
+	case "audiocodec", "audiocfg":
+		ints, ok := int_list_parse(value)
+		if !ok {
+			return "", "", errors.New(value + " isn't a valid list of numbers.")
+		}
+		return strings.ToLower(name), teamtalk_format_list(ints), nil
+	}
+	return "", "", errors.New("Unrecognized property: " + name + ". The properties are " + strings.Join(channel_properties, ", ") + ".")
+}
+
+// Parses properties given as name=value into protocol parameters.
+func channel_properties_parse(props []string) ([]string, error) {
+	params := []string{}
+	for _, prop := range props {
+		fields := strings.SplitN(prop, "=", 2)
+		if len(fields) != 2 {
+			return nil, errors.New("Unrecognized property: " + prop + ". Properties must be given as name=value.")
+		}
+		key, value, err := channel_property_param(fields[0], fields[1])
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, key, value)
+	}
+	return params, nil
+}
+
+func channel_params_find(params []string, key string) bool {
+	for i := 0; i < len(params)-1; i += 2 {
+		if params[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *tt_channel) Property_read_str(name string) string {
+	switch name {
+	case "name":
+		return ch.Name_read()
+	case "topic":
+		return ch.Topic_read()
+	case "password":
+		return ch.Password_read()
+	case "oppassword":
+		return ch.Oppassword_read()
+	case "maxusers":
+		return strconv.Itoa(ch.Maxusers_read())
+	case "diskquota":
+		return ch.Quota_read_str()
+	case "options":
+		return ch.Options_read_str()
+	case "audiocodec":
+		return teamtalk_format_list(ch.Audiocodec_read())
+	case "audiocfg":
+		return teamtalk_format_list(ch.Audiocfg_read())
+	}
+	return ""
+}
+
+func (ch *tt_channel) Info_str() string {
+	str := "Path: " + ch.Path_read() + "\r\n"
+	str += "ID: " + strconv.Itoa(ch.Id_read()) + "\r\n"
+	for _, name := range channel_properties[1:] {
+		str += name + ": " + ch.Property_read_str(name) + "\r\n"
+	}
+	if ops := ch.Operators_read_str(); ops != "" {
+		str += "Operators: " + ops + "\r\n"
+	}
+	return str
+}
+
+// Returns why the bot can't create a channel with the given options in parent, or an empty string.
+func (server *tt_server) Channel_create_check(options int) string {
+	if server.User_rights_check(TT_USERRIGHT_MODIFY_CHANNELS) {
+		return ""
+	}
+	if !server.User_rights_check(TT_USERRIGHT_CREATE_TEMPORARY_CHANNEL) {
+		return "Insufficient permission to create channels."
+	}
+	if options&TT_CHANNEL_PERMANENT != 0 {
+		return "Insufficient permission to create permanent channels."
+	}
+	return ""
+}
+
+// Creates a channel named name in parent. Properties which aren't given are copied from parent where it makes sense.
+func (server *tt_server) Channel_create(parent *tt_channel, name string, params []string) bool {
+	if _, _, err := channel_property_param("name", name); err != nil {
+		console_write(err.Error())
+		return false
+	}
+	if server.Channel_find_path_exact(parent.Path_read()+name) != nil {
+		console_write("A channel named " + name + " already exists in " + parent.Path_read() + ".")
+		return false
+	}
+	options := TT_CHANNEL_DEFAULT
+	for i := 0; i < len(params)-1; i += 2 {
+		if params[i] == "type" {
+			options, _ = strconv.Atoi(params[i+1])
+		}
+	}
+	if reason := server.Channel_create_check(options); reason != "" {
+		console_write(reason)
+		return false
+	}
+	if !channel_params_find(params, "audiocodec") {
+		params = append(params, "audiocodec", teamtalk_format_list(parent.Audiocodec_read()))
+	}
+	if !channel_params_find(params, "audiocfg") && len(parent.Audiocfg_read()) != 0 {
+		params = append(params, "audiocfg", teamtalk_format_list(parent.Audiocfg_read()))
+	}
+	if !channel_params_find(params, "maxusers") {
+		params = append(params, "maxusers", strconv.Itoa(parent.Maxusers_read()))
+	}
+	return server.cmd_make_channel(name, parent.Id_read(), params...)
+}
+
+func (server *tt_server) Channel_update(ch *tt_channel, params []string) bool {
+	if len(params) == 0 {
+		console_write("No properties were changed.")
+		return false
+	}
+	if !server.User_rights_check(TT_USERRIGHT_MODIFY_CHANNELS) && !ch.Operator_check(server.Uid_read()) {
+		console_write("Insufficient permission to update " + ch.Path_read() + ".")
+		return false
+	}
+	return server.cmd_update_channel(ch.Id_read(), params...)
+}
+
+func (server *tt_server) Channel_delete(ch *tt_channel) bool {
+	if !server.User_rights_check(TT_USERRIGHT_MODIFY_CHANNELS) {
+		console_write("Insufficient permission to remove channels.")
+		return false
+	}
+	if ch.Idparent_read() == 0 {
+		console_write("The root channel can't be removed.")
+		return false
+	}
+	return server.cmd_remove_channel(ch.Id_read())
+}
+
+// Prompts for a new value of a channel property, showing its current value in ch if ch isn't nil.
+// Returns the protocol parameter name and value.
+func channel_property_prompt(name string, ch *tt_channel) (string, string, bool) {
+	current := ""
+	if ch != nil {
+		current = " Currently: " + ch.Property_read_str(name) + "."
+	}
+	if name == "options" {
+		flags := TT_CHANNEL_DEFAULT
+		if ch != nil {
+			flags = ch.Options_read()
+		}
+		flags, aborted := teamtalk_flags_channel_options_menu(flags)
+		if aborted {
+			return "", "", true
+		}
+		return "type", strconv.Itoa(flags), false
+	}
+	prompts := map[string]string{
+		"name":       "Please enter the name of the channel.",
+		"topic":      "Please enter the topic, or nothing for no topic.",
+		"password":   "Please enter the password, or nothing for no password.",
+		"oppassword": "Please enter the operator password, or nothing for no operator password.",
+		"maxusers":   "Please enter the maximum number of users.",
+		"diskquota":  "Please enter the disk quota in bytes, or 0 to disallow files.",
+		"audiocodec": "Please enter the audio codec as a list of numbers, in the same form the audio codec of a channel is displayed.",
+		"audiocfg":   "Please enter the audio configuration as a list of numbers, in the same form the audio configuration of a channel is displayed, or nothing for none.",
+	}
+	for {
+		val, err := console_read_prompt(prompts[name] + current)
+		if err != nil {
+			return "", "", true
+		}
+		key, value, err := channel_property_param(name, val)
+		if err != nil {
+			console_write(err.Error())
+			continue
+		}
+		return key, value, false
+	}
+}
+
+func (server *tt_server) Channel_create_prompt(parent *tt_channel) bool {
+	_, name, aborted := channel_property_prompt("name", nil)
+	if aborted {
+		return false
+	}
+	params := []string{}
+	for _, prop := range []string{"topic", "password", "oppassword", "options"} {
+		key, value, aborted := channel_property_prompt(prop, nil)
+		if aborted {
+			return false
+		}
+		params = append(params, key, value)
+	}
+	inherited := map[string]string{
+		"maxusers":   strconv.Itoa(parent.Maxusers_read()),
+		"diskquota":  strconv.Itoa(parent.Quota_read()),
+		"audiocodec": teamtalk_format_list(parent.Audiocodec_read()),
+		"audiocfg":   teamtalk_format_list(parent.Audiocfg_read()),
+	}
+	for _, prop := range []string{"maxusers", "diskquota", "audiocodec", "audiocfg"} {
+		answer, aborted := console_read_confirm("Would you like to use the " + prop + " of " + parent.Path_read() + ", " + parent.Property_read_str(prop) + "?\r\n")
+		if aborted {
+			return false
+		}
+		if answer {
+			params = append(params, prop, inherited[prop])
+			continue
+		}
+		key, value, aborted := channel_property_prompt(prop, nil)
+		if aborted {
+			return false
+		}
+		params = append(params, key, value)
+	}
+	return server.Channel_create(parent, name, params)
+}
+
+func (server *tt_server) Channel_update_prompt(ch *tt_channel) bool {
+	params := []string{}
+	for {
+		menu := []string{}
+		for _, prop := range channel_properties {
+			menu = append(menu, prop+" ("+ch.Property_read_str(prop)+")")
+		}
+		menu = append(menu, "done")
+		res, aborted := console_read_menu("Please select a property to change.\r\n", menu)
+		if aborted || res == -1 {
+			return false
+		}
+		if menu[res] == "done" {
+			break
+		}
+		key, value, aborted := channel_property_prompt(channel_properties[res], ch)
+		if aborted {
+			return false
+		}
+		params = append(params, key, value)
+	}
+	return server.Channel_update(ch, params)
+}
+
+// Selects a channel by exact path, or by searching the channels of the server.
+func (server *tt_server) Channel_select(val string) (*tt_channel, bool) {
+	if val != "" {
+		if ch := server.Channel_find_path_exact(val); ch != nil {
+			return ch, false
+		}
+	}
+	ch, aborted := server_menu_channel(server, val)
+	if aborted {
+		return nil, true
+	}
+	if ch == nil {
+		console_write("Channel not found.")
+		return nil, true
+	}
+	return ch, false
+}
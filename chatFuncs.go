@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Functions for commands users can send to the bot in a private message, or in a channel message starting with !.
+// Replies are always sent in a private message.
+
+const CHAT_COMMAND_PREFIX = "!"
+
+type tt_chat_command struct {
+	usage string
+	run   func(server *tt_server, usr *tt_user, param string) string
+}
+
+var chat_commands = make(map[string]*tt_chat_command)
+
+func chat_command_add(name, usage string, run func(server *tt_server, usr *tt_user, param string) string) {
+	chat_commands[name] = &tt_chat_command{usage: usage, run: run}
+}
+
+func init() {
+	chat_command_add("help", "help: lists the commands you can send me.",
+		func(server *tt_server, usr *tt_user, param string) string {
+			names := []string{}
+			for name := range chat_commands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			reply := []string{}
+			for _, name := range names {
+				reply = append(reply, chat_commands[name].usage)
+			}
+			return strings.Join(reply, "\r\n")
+		})
+	chat_command_add("tell", "tell <username> <message>: leaves a memo for a user, delivered the next time they log in.",
+		func(server *tt_server, usr *tt_user, param string) string {
+			return server.Memo_tell(usr, param)
+		})
+	chat_command_add("seen", "seen <name>: tells you when a user was last seen, by username or nickname.",
+		func(server *tt_server, usr *tt_user, param string) string {
+			return server.Seen_query(param)
+		})
+}
+
+// Runs a command sent by usr, replying to them privately.
+// Returns false if the message wasn't a command.
+func (server *tt_server) Chat_command(msg_type int, usr *tt_user, content string) bool {
+	if usr == nil || usr.Uid_read() == server.Uid_read() {
+		return false
+	}
+	content = strings.TrimSpace(content)
+	switch msg_type {
+	case TT_MSGTYPE_USER:
+		content = strings.TrimPrefix(content, CHAT_COMMAND_PREFIX)
+	case TT_MSGTYPE_CHANNEL:
+		if !strings.HasPrefix(content, CHAT_COMMAND_PREFIX) {
+			return false
+		}
+		content = strings.TrimPrefix(content, CHAT_COMMAND_PREFIX)
+	default:
+		return false
+	}
+	fields := strings.SplitN(content, " ", 2)
+	cmd, exists := chat_commands[strings.ToLower(fields[0])]
+	if !exists {
+		return false
+	}
+	param := ""
+	if len(fields) == 2 {
+		param = strings.TrimSpace(fields[1])
+	}
+	if reply := cmd.run(server, usr, param); reply != "" {
+		server.cmd_message_user(usr.Uid_read(), reply)
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Functions for enforcing which client versions and client names may be used on a server.
+
+const CLIENTPOLICY_MESSAGE_DEFAULT = "Your client isn't supported on this server: {reason}. Please upgrade to a supported client."
+
+type tt_client_policy struct {
+	Enabled        bool     `xml:"enabled,attr"`
+	MinVersion     string   `xml:"minVersion,omitempty"`
+	BlockedVersion []string `xml:"blockedVersion,omitempty"`
+	AllowedClient  []string `xml:"allowedClient,omitempty"`
+	DeniedClient   []string `xml:"deniedClient,omitempty"`
+	Message        string   `xml:"message,omitempty"`
+	Action         string   `xml:"action,omitempty"`
+	Quarantine     string   `xml:"quarantine,omitempty"`
+}
+
+// Compares two version numbers made up of numbers separated by other characters, such as 5.8.1.
+// Returns -1 if a is older than b, 1 if a is newer than b, or 0 if they are the same.
+func version_compare(a, b string) int {
+	split := func(version string) []int {
+		nums := []int{}
+		for _, field := range strings.FieldsFunc(version, func(r rune) bool {
+			return !unicode.IsDigit(r)
+		}) {
+			n, _ := strconv.Atoi(field)
+			nums = append(nums, n)
+		}
+		return nums
+	}
+	va := split(a)
+	vb := split(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		na := 0
+		nb := 0
+		if i < len(va) {
+			na = va[i]
+		}
+		if i < len(vb) {
+			nb = vb[i]
+		}
+		if na < nb {
+			return -1
+		}
+		if na > nb {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (policy tt_client_policy) Message_read() string {
+	if policy.Message == "" {
+		return CLIENTPOLICY_MESSAGE_DEFAULT
+	}
+	return policy.Message
+}
+
+func (policy tt_client_policy) Info_str() string {
+	if !policy.Enabled {
+		return "The client policy is disabled."
+	}
+	str := "The client policy is enabled.\r\n"
+	if policy.MinVersion != "" {
+		str += "Minimum version: " + policy.MinVersion + "\r\n"
+	} else {
+		str += "Minimum version: none\r\n"
+	}
+	if len(policy.BlockedVersion) != 0 {
+		str += "Blocked versions: " + strings.Join(policy.BlockedVersion, ", ") + "\r\n"
+	}
+	if len(policy.AllowedClient) != 0 {
+		str += "Allowed clients: " + strings.Join(policy.AllowedClient, ", ") + "\r\n"
+	}
+	if len(policy.DeniedClient) != 0 {
+		str += "Denied clients: " + strings.Join(policy.DeniedClient, ", ") + "\r\n"
+	}
+	str += "Message: " + policy.Message_read() + "\r\n"
+	switch policy.Action {
+	case "move":
+		str += "Action: move to " + policy.Quarantine
+	case "kick":
+		str += "Action: kick"
+	default:
+		str += "Action: message only"
+	}
+	return str
+}
+
+func (server *tt_server) ClientPolicy_read() tt_client_policy {
+	defer server.Unlock()
+	server.Lock()
+	if server.ClientPolicy == nil {
+		return tt_client_policy{}
+	}
+	policy := *server.ClientPolicy
+	policy.BlockedVersion = append([]string{}, policy.BlockedVersion...)
+	policy.AllowedClient = append([]string{}, policy.AllowedClient...)
+	policy.DeniedClient = append([]string{}, policy.DeniedClient...)
+	return policy
+}
+
+func (server *tt_server) ClientPolicy_set(policy tt_client_policy) {
+	defer server.Unlock()
+	server.Lock()
+	server.ClientPolicy = &policy
+}
+
+// Returns the reason the client of usr breaks the policy, or an empty string.
+// Clients which don't report a version aren't checked against the minimum version, and clients which don't report a name aren't checked against the allowed and denied clients.
+func (policy tt_client_policy) Violation(usr *tt_user) string {
+	version := usr.Version_read()
+	clientname := usr.ClientName_read()
+	if policy.MinVersion != "" && version != "" && version_compare(version, policy.MinVersion) < 0 {
+		return "version " + version + " is older than " + policy.MinVersion
+	}
+	for _, blocked := range policy.BlockedVersion {
+		if str_glob_match(blocked, version) {
+			return "version " + version + " is blocked"
+		}
+	}
+	if clientname == "" {
+		return ""
+	}
+	for _, denied := range policy.DeniedClient {
+		if str_glob_match(denied, clientname) {
+			return clientname + " is denied"
+		}
+	}
+	if len(policy.AllowedClient) == 0 {
+		return ""
+	}
+	for _, allowed := range policy.AllowedClient {
+		if str_glob_match(allowed, clientname) {
+			return ""
+		}
+	}
+	return clientname + " isn't an allowed client"
+}
+
+// Tells usr to upgrade if their client breaks the policy, and moves or kicks them if configured to.
+func (server *tt_server) ClientPolicy_check(usr *tt_user) {
+	policy := server.ClientPolicy_read()
+	if !policy.Enabled || server.Moderation_exempt(usr) {
+		return
+	}
+	reason := policy.Violation(usr)
+	if reason == "" {
+		return
+	}
+	lnickname := usr.NickName_log()
+	msg := strings.NewReplacer(
+		"{reason}", reason,
+		"{version}", usr.Version_read(),
+		"{client}", usr.ClientName_read(),
+		"{minversion}", policy.MinVersion,
+	).Replace(policy.Message_read())
+	server.Log_username_set(usr.UserName_read())
+	server.Log_write(lnickname+" doesn't meet the client policy: "+reason+".", false)
+	server.cmd_message_user(usr.Uid_read(), msg)
+	switch policy.Action {
+	case "move":
+		ch := server.Channel_find_path_exact(policy.Quarantine)
+		if ch == nil {
+			server.Log_write("Unable to move "+lnickname+" to the quarantine channel. "+policy.Quarantine+" doesn't exist.", true)
+			return
+		}
+		if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
+			server.Log_write("Unable to move "+lnickname+" to the quarantine channel. Insufficient permission.", true)
+			return
+		}
+		if server.cmd_move_user(usr.Uid_read(), ch.Id_read()) {
+			server.Log_username_set(usr.UserName_read())
+			server.Log_write(lnickname+" has been moved to "+ch.Path_read()+".", false)
+		}
+	case "kick":
+		server.Moderate(usr, ACTION_KICK_SERVER, "Client policy, "+reason, "", 0)
+	}
+}
+
+func (server *tt_server) ClientPolicy_report() string {
+	name := server.DisplayName_read()
+	if !server.connected() {
+		return name + ": not connected."
+	}
+	policy := server.ClientPolicy_read()
+	if !policy.Enabled {
+		return name + ": the client policy is disabled."
+	}
+	users := server.Users_sort(server.Uid_read())
+	violations := make(map[string][]string)
+	compliant := 0
+	for _, usr := range users {
+		reason := policy.Violation(usr)
+		if reason == "" {
+			compliant++
+			continue
+		}
+		violations[reason] = append(violations[reason], usr.NickName_log())
+	}
+	str := name + ": " + strconv.Itoa(compliant) + " of " + strconv.Itoa(len(users)) + " user"
+	if len(users) != 1 {
+		str += "s"
+	}
+	str += " meet the client policy."
+	reasons := []string{}
+	for reason := range violations {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		str += "\r\n" + str_capitalize(reason) + ": " + strings.Join(violations[reason], ", ")
+	}
+	return str
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -1040,8 +1043,8 @@ func init() {
 			if aborted {
 				return
 			}
-			if ch_dest != nil && ch_dest == server.Channel_find_id(server.AutoMoveFrom_read()) {
-				answer, aborted := console_read_confirm("You are already automoving from " + ch_src.Path_read() + ". Would you like to disable automatic user moving and continue?")
+			if ch_dest != nil && server.AutoMove_source_check(ch_dest) {
+				answer, aborted := console_read_confirm("Users who join " + ch_dest.Path_read() + " may be automatically moved away from it. Would you like to continue?\r\n")
 				if aborted {
 					return
 				}
@@ -1049,8 +1052,6 @@ func init() {
 					console_write("Aborted.")
 					return
 				}
-				server.AutoMove_clear()
-				console_write("Automatic user moving disabled.")
 			}
 			if ch_src == nil && ch_dest == nil && usr_src == nil {
 				console_write("Source and destination unselected for user moving. Command unsuccessful.")
@@ -1505,17 +1506,109 @@ func init() {
 		})
 
 	commands.AddHelp("autosubscriptions",
-		"Manages automatic user subscriptions for the active or selected server.\r\nTo disable automatic subscribing and unsubscribing, disable all subscriptions in the menu.\r\nPlease note that, if you begin enabling subscriptions in the menu, anything disabled will automatically be unsubscribed from when a user connects. For example, if you want to automatically intercept private messages from a user, so you enable that subscription and complete your selection, you will be subscribed to intercept users private messages, but unsubscribed from everything else, which would prevent you from receiving any channel or broadcast messages from them.")
+		"Manages automatic user subscriptions for the active or selected server.\r\nTo disable automatic subscribing and unsubscribing, disable all subscriptions in the menu.\r\nPlease note that, if you begin enabling subscriptions in the menu, anything disabled will automatically be unsubscribed from when a user connects. For example, if you want to automatically intercept private messages from a user, so you enable that subscription and complete your selection, you will be subscribed to intercept users private messages, but unsubscribed from everything else, which would prevent you from receiving any channel or broadcast messages from them.\r\nRules can change the subscriptions of particular users, groups of users, or users in particular channels. Every matching rule is applied in order, on top of the automatic subscriptions, or on top of the subscriptions given by the server if automatic subscriptions are disabled. Rules are applied again when users change channels or their user type changes.",
+		"autosubscriptions\r\nWill guide you through selecting the automatic subscriptions.",
+		"autosubscriptions rules\r\nWill list the automatic subscription rules.",
+		"autosubscriptions add\r\nWill guide you through prompts to add a rule.",
+		"autosubscriptions add group=anonymous \"add=intercept private messages\"\r\nWill intercept the private messages of users who log in without a username.",
+		"autosubscriptions add username=spammer remove=audio\r\nWill never subscribe to the audio of the user spammer. Usernames may contain * and ? wildcards.",
+		"autosubscriptions add channel=/lobby \"set=private messages, channel messages\"\r\nWill only subscribe to private and channel messages of users in the lobby channel and its subchannels.",
+		"autosubscriptions remove 2\r\nWill remove the second rule.",
+		"autosubscriptions order 3 1\r\nWill make the third rule the first.",
+		"autosubscriptions apply\r\nWill apply automatic subscriptions to everyone who is logged in.",
+		"autosubscriptions users\r\nWill list the local subscriptions of everyone who is logged in, along with the rules which produced them.")
 	commands.Add("autosubscriptions",
 		func(param string) {
 			server := server_active_check("")
 			if server == nil {
 				return
 			}
-			if c.Server_prompt_autosubscriptions(server, true) {
-				return
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			switch cmd {
+			case "":
+				if c.Server_prompt_autosubscriptions(server, true) {
+					return
+				}
+				console_write("Command complete.")
+			case "rules":
+				server.AutoSub_list()
+			case "add":
+				var rule *tt_autosub_rule
+				if len(params) == 1 {
+					rule = server.AutoSub_add_prompt()
+					if rule == nil {
+						return
+					}
+				} else {
+					rule = &tt_autosub_rule{}
+					if err := options_set(rule, params[1:]); err != nil {
+						console_write(err.Error())
+						return
+					}
+					if rule.Set == "" && rule.Add == "" && rule.Remove == "" {
+						console_write("The rule doesn't change any subscriptions. Command unsuccessful.")
+						return
+					}
+				}
+				server.AutoSub_add(rule)
+				c.Write()
+				console_write("Added automatic subscription rule: " + rule.Info_str())
+			case "remove":
+				val := ""
+				if len(params) >= 2 {
+					val = params[1]
+				}
+				rule, aborted := server.AutoSub_menu(val, "Please select a rule to remove.")
+				if aborted {
+					return
+				}
+				if !server.AutoSub_remove(rule) {
+					console_write("Command unsuccessful.")
+					return
+				}
+				c.Write()
+				console_write("Removed automatic subscription rule: " + rule.Info_str())
+			case "order":
+				if len(params) != 3 {
+					console_write(commands.HelpText("autosubscriptions"))
+					return
+				}
+				rule, aborted := server.AutoSub_menu(params[1], "Please select a rule to reorder.")
+				if aborted {
+					return
+				}
+				pos, err := strconv.Atoi(params[2])
+				if err != nil || !server.AutoSub_reorder(rule, pos-1) {
+					console_write(params[2] + " isn't a valid position. Command unsuccessful.")
+					return
+				}
+				c.Write()
+				server.AutoSub_list()
+			case "apply":
+				if !server.connected() {
+					console_write("Unable to apply automatic subscriptions. Not connected.")
+					return
+				}
+				count := server.AutoSub_apply()
+				msg := "Subscriptions changed for " + strconv.Itoa(count) + " user"
+				if count != 1 {
+					msg += "s"
+				}
+				console_write(msg + ".")
+			case "users":
+				if !server.connected() {
+					console_write("Not connected.")
+					return
+				}
+				server.AutoSub_users_list()
+			default:
+				console_write("Unrecognized option: " + cmd)
+				console_write(commands.HelpText("autosubscriptions"))
 			}
-			console_write("Command complete.")
 		})
 
 	commands.AddHelp("autosubs",
@@ -1531,274 +1624,161 @@ func init() {
 	})
 
 	commands.AddHelp("automove",
-		"Automatically move all users that connect to the server, or all users in a channel, to another channel.",
-		"automove\r\nWill guide you through prompts to automatically move users.",
-		"automove / /away\r\nWill configure automatic user moving for users that join the root channel to the away channel.",
-		"automove /admin\r\nWill configure automatic user moving of all users that connect to the server to the admin channel.",
-		"automove disable\r\nautomove off\r\nWill disable automoving if enabled.",
-		"automove\r\nWill report on the status of automatic user moving, and if enabled, will give you the option to disable it. You will also get prompts to select a source and/or destination channel for automoving if you choose.")
+		"Automatically move users to another channel as they log in, or as they join a channel, using an ordered list of rules. The first rule which matches a user is used. Rules can match usernames, nicknames, user types, client names and IP addresses, apply only at certain times of day, and wait before moving users. Channels are referred to by path.\r\nUsernames, nicknames and client names may contain * and ? wildcards. Options containing spaces must be quoted in full, such as \"nickname=Guest *\".",
+		"automove\r\nWill list the automove rules.",
+		"automove add\r\nWill guide you through prompts to add a rule.",
+		"automove add /away from=/ usertype=default time=22:00-06:00 delay=5m\r\nWill move users who aren't administrators from the root channel to the away channel 5 minutes after they join it, between 22:00 and 06:00.",
+		"automove add /guests nickname=guest* client=TeamTalk* ip=10.0.0.0/8\r\nWill move users who log in with a matching nickname, client name and IP address to the guests channel. IP addresses may also be a range such as 10.0.0.1-10.0.0.50, or a pattern such as 192.168.*.",
+		"automove / /away\r\nWill add a rule moving users who join the root channel to the away channel.",
+		"automove /admin\r\nWill add a rule moving all users who log in to the admin channel.",
+		"automove remove 2\r\nWill remove the second rule.",
+		"automove order 3 1\r\nWill make the third rule the first.",
+		"automove apply\r\nWill apply the rules to users who are already in a channel.",
+		"automove disable\r\nautomove off\r\nWill remove all automove rules.")
 	commands.Add("automove",
 		func(param string) {
 			server := server_active_check("")
 			if server == nil {
 				return
 			}
-			if !server.connected() {
-				console_write("Unable to set up automatic user moving. Not connected.")
-				return
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
 			}
-			var ch_src *tt_channel
-			var ch_dest *tt_channel
-			answer := false
-			aborted := false
-			src := ""
-			dest := ""
-			if param == "" {
-				if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
-					console_write("Automove settings disabled. Insufficient user rights to enable.")
+			switch cmd {
+			case "":
+				server.AutoMove_list()
+				return
+			case "off", "disable":
+				if len(server.AutoMoveRules_read()) == 0 {
+					console_write("Automatic user moving already disabled.")
 					return
 				}
-				msg := "Automove status: "
-				if server.AutoMove_enabled() {
-					msg += "enabled"
-				} else {
-					msg += "disabled"
-				}
-				msg += ".\r\n"
-				ch_src = server.Channel_find_id(server.AutoMoveFrom_read())
-				ch_dest = server.Channel_find_id(server.AutoMoveTo_read())
-				if ch_src != nil || ch_dest != nil {
-					msg += "Automatically moving "
-					if ch_src != nil {
-						msg += "users from " + ch_src.Path_read()
-					} else {
-						msg += "all users that connect"
-					}
-					msg += " to "
-					if ch_dest != nil {
-						msg += ch_dest.Path_read() + "\r\n"
-					} else {
-						console_write("Incorrect automove settings found. Disabling.")
-						server.AutoMove_clear()
-						ch_src = nil
-						ch_dest = nil
-						msg = ""
-					}
-				}
-				if msg != "" {
-					console_write(msg)
-				}
-				if ch_src != nil || ch_dest != nil {
-					answer, aborted = console_read_confirm("Would you like to disable automatic user moving?\r\n")
-					if aborted {
-						return
-					}
-					if !answer {
-						console_write("Automove settings will remain the same until disabled.")
-						return
-					}
-					server.AutoMove_clear()
-					console_write("Automove settings disabled.")
-					ch_src = nil
-					ch_dest = nil
-				}
-				if ch_src == nil && ch_dest == nil {
-					answer, aborted = console_read_confirm("Would you like to set up automatic user moving now?\r\n")
-					if aborted {
-						return
-					}
-					if !answer {
-						console_write("Aborted.")
-						return
-					}
-					ch_src, aborted = server_menu_channel(server, "")
-					if aborted {
-						return
-					}
-					if ch_src == nil {
-						console_write("Channel not found. Aborted.")
-						return
-					}
-					console_write("Channel selected: " + ch_src.Path_read())
-					answer, aborted = console_read_confirm("Would you like to move all users that connect to the server to this channel?\r\n")
-					if aborted {
-						return
-					}
-					if answer {
-						ch_dest = ch_src
-						ch_src = nil
-					} else {
-						console_write("Selecting destination channel.")
-						ch_dest, aborted = server_menu_channel(server, "")
-						if aborted {
-							return
-						}
-						if ch_dest == nil {
-							console_write("Channel not found. Aborted.")
-							return
-						}
-					}
-				}
-			} else {
-				if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
-					console_write("You don't have permission to move users. Unable to set up automatic user moving. Command unsuccessful.")
+				answer, aborted := console_read_confirm("Would you like to remove all automove rules?\r\n")
+				if aborted {
 					return
 				}
-				switch strings.ToLower(param) {
-				case "off", "disable":
-					if !server.AutoMove_enabled() {
-						console_write("Automatic user moving already disabled.")
-						return
-					}
-					server.AutoMove_clear()
-					console_write("Automatic user moving disabled.")
+				if !answer {
+					console_write("Aborted.")
 					return
 				}
-				params := stringSeperateParam(param, " ", "\"")
-				if len(params) >= 1 {
-					src = params[0]
-				}
+				server.AutoMove_clear()
+				c.Write()
+				console_write("Automatic user moving disabled.")
+				return
+			case "remove":
+				val := ""
 				if len(params) >= 2 {
-					dest = strings.Join(restoreParams(params[1:], " ", "\""), " ")
+					val = params[1]
 				}
-				ch_src, aborted = server_menu_channel(server, src)
+				rule, aborted := server.AutoMove_menu(val, "Please select a rule to remove.")
 				if aborted {
 					return
 				}
-				if len(params) == 1 {
-					if ch_src == nil {
-						console_write("Source and/or destination channel not found. Aborted.")
-						return
-					}
-					ch_dest = ch_src
-					ch_src = nil
-				}
-				if ch := server.Channel_find_id(server.AutoMoveFrom_read()); ch != nil {
-					answer, aborted := console_read_confirm("You are already automoving from " + ch.Path_read() + ". Would you like to disable automatic user moving and continue?")
-					if aborted {
-						return
-					}
-					if !answer {
-						console_write("Aborted.")
-						return
-					}
-					server.AutoMove_clear()
-					console_write("Automatic user moving disabled.")
-				}
-				if ch_dest == nil {
-					if ch := server.Channel_find_id(server.AutoMoveTo_read()); ch != nil {
-						answer, aborted := console_read_confirm("You are already automatically moving users to " + ch.Path_read() + ". Would you like to disable automatic user moving and continue?\r\n")
-						if aborted {
-							return
-						}
-						if !answer {
-							console_write("Aborted.")
-							return
-						}
-						server.AutoMove_clear()
-						console_write("Automatic user moving disabled.")
-						if dest == "" {
-							console_write("Selecting destination channel for automatic user moving.")
-						}
-					}
-					ch_dest, aborted = server_menu_channel(server, dest)
-					if aborted {
-						return
-					}
-				}
-			}
-			if ch_dest == nil {
-				console_write("Destination unselected for automatic user moving. Command unsuccessful.")
-				return
-			}
-			if ch := server.Channel_find_id(server.AutoMoveTo_read()); ch != nil {
-				answer, aborted := console_read_confirm("You are already automatically moving users to " + ch.Path_read() + ". Would you like to disable automatic user moving and continue?\r\n")
-				if aborted {
+				if !server.AutoMove_remove(rule) {
+					console_write("Command unsuccessful.")
 					return
 				}
-				if !answer {
-					console_write("Aborted.")
+				c.Write()
+				console_write("Removed automove rule: " + rule.Info_str())
+				return
+			case "order":
+				if len(params) != 3 {
+					console_write(commands.HelpText("automove"))
 					return
 				}
-				server.AutoMove_clear()
-				console_write("Automatic user moving disabled.")
-			}
-			if ch_src == nil && ch_dest != nil {
-				answer, aborted = console_read_confirm("You have selected to move all users that connect to the server to " + ch_dest.Path_read() + ". Is this correct?")
+				rule, aborted := server.AutoMove_menu(params[1], "Please select a rule to reorder.")
 				if aborted {
 					return
 				}
-				if !answer {
-					console_write("Aborted.")
+				pos, err := strconv.Atoi(params[2])
+				if err != nil || !server.AutoMove_reorder(rule, pos-1) {
+					console_write(params[2] + " isn't a valid position. Command unsuccessful.")
 					return
 				}
-				server.AutoMoveTo_set(ch_dest.Id_read())
-				answer, aborted = console_read_confirm("Would you like these settings to persist until you disable them? If you don't set this, you will have to set up automatic user moving again if the program terminates or you quit the program, then restart it.\r\n")
-				if aborted {
+				c.Write()
+				server.AutoMove_list()
+				return
+			case "apply":
+				if !server.connected() {
+					console_write("Unable to apply automove rules. Not connected.")
 					return
 				}
-				if answer {
-					server.AutoMoveTo_config_set(ch_dest.Id_read())
-					if c.Write() {
-						console_write("Automatic move settings saved to configuration file.")
-					}
+				count := server.AutoMove_apply()
+				msg := strconv.Itoa(count) + " user"
+				if count != 1 {
+					msg += "s"
 				}
-				console_write("Users will be moved to " + ch_dest.Path_read() + " as they connect.")
+				console_write(msg + " will be moved.")
 				return
 			}
-			if ch_src != nil && ch_dest != nil {
-				answer, aborted = console_read_confirm("You have selected to move users from " + ch_src.Path_read() + " to " + ch_dest.Path_read() + ". Is this correct?")
-				if aborted {
-					return
-				}
-				if !answer {
-					console_write("Aborted.")
-					return
-				}
-				server.AutoMoveFrom_set(ch_src.Id_read())
-				server.AutoMoveTo_set(ch_dest.Id_read())
-				answer, aborted = console_read_confirm("Would you like these settings to persist until you disable them? If you don't set this, you will have to set up automatic user moving again if the program terminates or you quit the program, then restart it.\r\n")
-				if aborted {
+			if !server.connected() {
+				console_write("Unable to set up automatic user moving. Not connected.")
+				return
+			}
+			if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
+				console_write("You don't have permission to move users. Unable to set up automatic user moving. Command unsuccessful.")
+				return
+			}
+			var rule *tt_automove_rule
+			if cmd == "add" && len(params) == 1 {
+				rule = server.AutoMove_add_prompt()
+				if rule == nil {
 					return
 				}
-				if answer {
-					server.AutoMoveFrom_config_set(ch_src.Id_read())
-					server.AutoMoveTo_config_set(ch_dest.Id_read())
-					if c.Write() {
-						console_write("Automatic move settings saved to configuration file.")
+			} else if cmd == "add" {
+				rule = &tt_automove_rule{}
+				for _, option := range params[1:] {
+					name, value, ok := option_split(option)
+					if !ok {
+						if rule.To != "" {
+							console_write("Unrecognized option: " + option)
+							return
+						}
+						path, aborted := server.AutoMove_channel_select(option)
+						if aborted {
+							return
+						}
+						rule.To = path
+						continue
+					}
+					if err := rule.Option_set(name, value); err != nil {
+						console_write(err.Error())
+						return
 					}
 				}
-				answer, aborted = console_read_confirm("Would you like to move the users now?\r\n")
-				if aborted {
+				if rule.To == "" {
+					console_write("The destination channel is required. Command unsuccessful.")
 					return
 				}
-				if !answer {
-					console_write("Users will be moved as they join " + ch_src.Path_read())
-					return
+				if rule.From != "" && server.Channel_find_path_exact(rule.From) == nil {
+					console_write("Warning: " + rule.From + " doesn't exist on the server.")
 				}
-				count := 0
-				users := ch_src.Users_read()
-				cid := ch_dest.Id_read()
-				for i, u := range users {
-					if u == nil {
-						console_write("Nil user found at " + strconv.Itoa(i))
-						continue
-					}
-					if server.cmd_move_user(u.Uid_read(), cid) {
-						count++
+			} else {
+				rule = &tt_automove_rule{}
+				dest := params[0]
+				if len(params) >= 2 {
+					path, aborted := server.AutoMove_channel_select(params[0])
+					if aborted {
+						return
 					}
+					rule.From = path
+					dest = strings.Join(params[1:], " ")
 				}
-				if count == 0 {
-					console_write("No users were moved.")
+				path, aborted := server.AutoMove_channel_select(dest)
+				if aborted {
 					return
 				}
-				prompt := "Successfully moved " + strconv.Itoa(count) + " user"
-				if count != 1 {
-					prompt += "s"
-				}
-				console_write(prompt + " to " + ch_dest.Path_read())
+				rule.To = path
+			}
+			if strings.ToLower(rule.From) == strings.ToLower(rule.To) {
+				console_write("The source and destination channels are the same. Command unsuccessful.")
 				return
 			}
+			server.AutoMove_add(rule)
+			c.Write()
+			console_write("Added automove rule: " + rule.Info_str())
 		})
 
 	commands.AddHelp("history",
@@ -1871,7 +1851,10 @@ func init() {
 		"account mod\r\naccount modify\r\nSame as accounts change.",
 		"account del\r\nWill give you a list of accounts to delete.",
 		"account del test\r\nWill delete the account named test.",
-		"account delete\r\naccount remove\r\nSame as account del.")
+		"account delete\r\naccount remove\r\nSame as account del.",
+		"account export accounts.csv\r\nWill export all user accounts to accounts.csv. Files ending in .json will be exported in JSON format.",
+		"account import accounts.csv\r\nWill create or update the user accounts found in accounts.csv, or a file ending in .json, and report on each entry.",
+		"account import accounts.csv dry-run\r\nWill display the changes an import would make without making them.")
 	commands.Add("account",
 		func(param string) {
 			server := server_active_check("")
@@ -1888,6 +1871,8 @@ func init() {
 					"Add a user account",
 					"Change a user account",
 					"Remove a user account",
+					"Export user accounts to a file",
+					"Import user accounts from a file",
 				}
 				res, aborted := console_read_menu("Please select your option.\r\n", menu)
 				if aborted || res == -1 {
@@ -1920,6 +1905,54 @@ func init() {
 			case "del", "delete", "remove":
 				console_write("Command not implemented.")
 				return
+			case "export":
+				fname := strings.Join(stringSeperateParam(strings.Join(params[1:], " "), " ", "\""), " ")
+				if fname == "" {
+					val, err := console_read_prompt("Please enter the name of the file to export user accounts to. The file name must end in .csv or .json.")
+					if err != nil {
+						return
+					}
+					fname = val
+				}
+				if fname == "" {
+					console_write("Empty file name unsupported. Aborted.")
+					return
+				}
+				if res := server.Accounts_export(fname); res {
+					console_write("Command successful.")
+				} else {
+					console_write("Command unsuccessful.")
+				}
+				return
+			case "import":
+				cmd_params := stringSeperateParam(strings.Join(params[1:], " "), " ", "\"")
+				dryrun := false
+				if len(cmd_params) >= 2 {
+					switch strings.ToLower(cmd_params[len(cmd_params)-1]) {
+					case "dry-run", "dryrun", "dry":
+						dryrun = true
+						cmd_params = cmd_params[:len(cmd_params)-1]
+					}
+				}
+				fname := strings.Join(cmd_params, " ")
+				if fname == "" {
+					val, err := console_read_prompt("Please enter the name of the file to import user accounts from. The file name must end in .csv or .json.")
+					if err != nil {
+						return
+					}
+					fname = val
+					if fname == "" {
+						console_write("Empty file name unsupported. Aborted.")
+						return
+					}
+					answer, aborted := console_read_confirm("Would you like to see the planned changes without making them?\r\n")
+					if aborted {
+						return
+					}
+					dryrun = answer
+				}
+				server.Accounts_import(fname, dryrun)
+				return
 			case "update":
 				res := server.cmd_list_accounts()
 				if res {
@@ -1931,6 +1964,1732 @@ func init() {
 			}
 		})
 
+	commands.AddHelp("ban",
+		"List, add, or remove user bans.",
+		"ban\r\nWill give you a menu of ban options.",
+		"ban list\r\nWill list all current bans, including when temporary bans expire.",
+		"ban list 192.168\r\nWill list the bans with an IP address, username, nickname, channel, or owner containing 192.168.",
+		"ban add\r\nWill guide you through prompts to ban a user.",
+		"ban add tech\r\nWill ban tech by IP address and/or username from the server or their channel.",
+		"ban tech\r\nSame as ban add tech.",
+		"ban tech 2h\r\nWill ban tech for 2 hours, after which the ban will be removed automatically. Durations may use s, m, h, d, and w, such as 30m or 1d12h.",
+		"ban tech 7d spamming the lobby\r\nWill ban tech for 7 days with the reason spamming the lobby.",
+		"ban \"tech support\" 1w\r\nUsers with spaces in their names must be quoted.",
+		"ban kick tech\r\nWill ban tech and kick them in one step.",
+		"ban kick tech 1d flooding\r\nWill kick tech and ban them for 1 day with the reason flooding.",
+		"ban remove\r\nWill give you a menu of bans to remove.",
+		"ban remove 10.0.0.1\r\nWill remove the ban matching 10.0.0.1.",
+		"ban del\r\nban delete\r\nban unban\r\nSame as ban remove.")
+	commands.Add("ban",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if !server.connected() {
+				console_write("Unable to manage bans. Not connected.")
+				return
+			}
+			cmd := ""
+			params := strings.Split(param, " ")
+			if len(params) >= 1 {
+				cmd = params[0]
+			}
+			if cmd == "" {
+				menu := []string{
+					"List bans",
+					"Add a ban",
+					"Kick and ban a user",
+					"Remove a ban",
+				}
+				res, aborted := console_read_menu("Please select your option.\r\n", menu)
+				if aborted || res == -1 {
+					return
+				}
+				cmd = []string{"list", "add", "kick", "remove"}[res]
+			}
+			val := strings.Join(restoreParams(stringSeperateParam(strings.Join(params[1:], " "), " ", "\""), " ", "\""), " ")
+			switch strings.ToLower(cmd) {
+			case "list":
+				if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+					console_write("You don't have permission to list bans. Command unsuccessful.")
+					return
+				}
+				server.Bans_list(val)
+				return
+			case "add":
+				usrval, duration, reason := ban_params_parse(strings.Join(params[1:], " "))
+				if server.Ban_user_prompt(usrval, duration, reason, false) {
+					console_write("Command successful.")
+				} else {
+					console_write("Command unsuccessful.")
+				}
+				return
+			case "kick":
+				usrval, duration, reason := ban_params_parse(strings.Join(params[1:], " "))
+				if server.Ban_user_prompt(usrval, duration, reason, true) {
+					console_write("Command successful.")
+				} else {
+					console_write("Command unsuccessful.")
+				}
+				return
+			case "remove", "del", "delete", "unban":
+				if server.Unban_prompt(val) {
+					console_write("Command successful.")
+				} else {
+					console_write("Command unsuccessful.")
+				}
+				return
+			}
+			usrval, duration, reason := ban_params_parse(param)
+			if server.Ban_user_prompt(usrval, duration, reason, false) {
+				console_write("Command successful.")
+			} else {
+				console_write("Command unsuccessful.")
+			}
+		})
+
+	commands.AddHelp("bans",
+		"Same as ban list.")
+	commands.Add("bans",
+		func(param string) {
+			commands.Exec("ban", strings.TrimSpace("list "+param))
+		})
+
+	commands.AddHelp("unban",
+		"Same as ban remove.")
+	commands.Add("unban",
+		func(param string) {
+			commands.Exec("ban", strings.TrimSpace("remove "+param))
+		})
+
+	commands.AddHelp("kick",
+		"Kick users from the server or a channel, and configure automatic bans for users who are kicked repeatedly.",
+		"kick\r\nWill guide you through prompts to kick a user.",
+		"kick tech\r\nWill ask whether to kick tech from the server or their channel.",
+		"kick tech stop shouting\r\nSame as above, with the reason stop shouting.",
+		"kick server tech\r\nWill kick tech from the server.",
+		"kick channel tech\r\nWill kick tech from their channel.",
+		"kick count\r\nWill list how many times users have been kicked recently.",
+		"kick escalate 3 1h 1d\r\nWill ban users for 1 day when they have been kicked 3 times within 1 hour.",
+		"kick escalate off\r\nWill no longer ban users for being kicked repeatedly.")
+	commands.Add("kick",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			switch cmd {
+			case "count", "counts":
+				server.Kicks_list()
+				return
+			case "escalate", "escalation":
+				if len(params) == 1 {
+					console_write(server.KickBan_read_str())
+					return
+				}
+				switch strings.ToLower(params[1]) {
+				case "off", "none", "0":
+					server.KickBan_set(0, 0, 0)
+					c.Write()
+					console_write(server.KickBan_read_str())
+					return
+				}
+				threshold, err := strconv.Atoi(params[1])
+				if err != nil || threshold < 1 {
+					console_write("The number of kicks must be a number above 0. Command unsuccessful.")
+					return
+				}
+				window := KICKBAN_WINDOW_DEFAULT
+				duration := KICKBAN_DURATION_DEFAULT
+				if len(params) >= 3 {
+					d, ok := time_duration_parse(params[2])
+					if !ok {
+						console_write(params[2] + " isn't a valid duration. Command unsuccessful.")
+						return
+					}
+					window = d
+				}
+				if len(params) >= 4 {
+					d, ok := time_duration_parse(params[3])
+					if !ok {
+						console_write(params[3] + " isn't a valid duration. Command unsuccessful.")
+						return
+					}
+					duration = d
+				}
+				server.KickBan_set(threshold, window, duration)
+				c.Write()
+				console_write(server.KickBan_read_str())
+				return
+			}
+			if !server.connected() {
+				console_write("Unable to kick users. Not connected.")
+				return
+			}
+			scope := ""
+			if cmd == "server" || cmd == "channel" {
+				scope = cmd
+				params = params[1:]
+			}
+			usrval := ""
+			reason := ""
+			if len(params) >= 1 {
+				usrval = params[0]
+			}
+			if len(params) >= 2 {
+				reason = strings.Join(restoreParams(params[1:], " ", "\""), " ")
+			}
+			if server.Kick_user_prompt(usrval, scope, reason) {
+				console_write("Command successful.")
+			} else {
+				console_write("Command unsuccessful.")
+			}
+		})
+
+	commands.AddHelp("flood",
+		"View or change the flood protection of the active server. Administrators are never acted upon. Setting a limit to 0 disables it.",
+		"flood\r\nWill display the current flood protection settings.",
+		"flood on\r\nflood off\r\nWill enable or disable flood protection.",
+		"flood messages 5 10s\r\nWill allow each user to send at most 5 messages within 10 seconds.",
+		"flood repeats 3\r\nWill allow each user to send the same message at most 3 times in a row.",
+		"flood length 400\r\nWill allow messages of at most 400 characters.",
+		"flood broadcasts 2 1m\r\nWill allow each user to send at most 2 broadcast messages within 1 minute.",
+		"flood actions warn, channelkick, kick, ban\r\nWill warn a user in a private message the first time they flood, kick them from their channel the second time, kick them from the server the third time, and ban them from then on. The available actions are log, warn, channelkick, kick, and ban.",
+		"flood reset 30m\r\nWill forget previous flooding by a user after 30 minutes.",
+		"flood ban 1h\r\nWill ban users for 1 hour when the ban action is taken.")
+	commands.Add("flood",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			conf := server.Flood_read()
+			params := strings.Fields(param)
+			if len(params) == 0 {
+				console_write(conf.Info_str())
+				return
+			}
+			opt := strings.ToLower(params[0])
+			switch opt {
+			case "enable":
+				opt = "on"
+			case "disable":
+				opt = "off"
+			}
+			value := ""
+			if len(params) >= 2 {
+				value = params[1]
+			}
+			count := 0
+			if opt != "on" && opt != "off" && opt != "actions" {
+				if value == "" {
+					console_write("A value is required for " + opt + ". Command unsuccessful.")
+					return
+				}
+				if opt != "reset" && opt != "ban" {
+					n, err := strconv.Atoi(value)
+					if err != nil || n < 0 {
+						console_write(value + " isn't a valid number. Command unsuccessful.")
+						return
+					}
+					count = n
+				} else if _, ok := time_duration_parse(value); !ok {
+					console_write(value + " isn't a valid duration. Command unsuccessful.")
+					return
+				}
+			}
+			interval := ""
+			if len(params) >= 3 {
+				if _, ok := time_duration_parse(params[2]); !ok {
+					console_write(params[2] + " isn't a valid duration. Command unsuccessful.")
+					return
+				}
+				interval = params[2]
+			}
+			switch opt {
+			case "on":
+				conf.Enabled = true
+			case "off":
+				conf.Enabled = false
+			case "messages":
+				conf.Messages = count
+				if interval != "" {
+					conf.Interval = interval
+				}
+			case "repeats":
+				conf.Repeats = count
+			case "length":
+				conf.MaxLength = count
+			case "broadcasts":
+				conf.Broadcasts = count
+				if interval != "" {
+					conf.BroadcastInterval = interval
+				}
+			case "actions":
+				actions, ok := actions_parse(strings.Join(params[1:], " "))
+				if !ok || len(actions) == 0 {
+					console_write("Unrecognized actions. The available actions are log, warn, channelkick, kick, and ban. Command unsuccessful.")
+					return
+				}
+				conf.Actions = actions_str(actions)
+			case "reset":
+				conf.ResetAfter = value
+			case "ban":
+				conf.BanDuration = value
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("flood"))
+				return
+			}
+			server.Flood_set(conf)
+			c.Write()
+			console_write(conf.Info_str())
+		})
+
+	commands.AddHelp("filter",
+		"Add, remove, list, or test rules which filter words and regular expressions out of channel, private, and broadcast messages, nicknames, and status messages. Each rule can log, warn, channelkick, kick, or ban. Administrators are never acted upon.",
+		"filter\r\nfilter list\r\nWill list the filter rules of the active server.",
+		"filter add\r\nWill guide you through prompts to add a filter rule.",
+		"filter add word idiot warn\r\nWill warn users who use the word idiot.",
+		"filter add regex \"fr[e3]{2} ?money\" ban 1d\r\nWill ban users for 1 day when anything they write matches the regular expression. Bans without a duration are permanent.",
+		"filter add word spam kick /lobby/\r\nWill kick users who use the word spam in the lobby channel or its subchannels.",
+		"filter remove\r\nWill give you a menu of rules to remove.",
+		"filter remove 2\r\nWill remove the second rule in the list.",
+		"filter test some text\r\nWill list the rules which match some text.",
+		"filter test /lobby/ some text\r\nWill list the rules which match some text when written in the lobby channel.")
+	commands.Add("filter",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := "list"
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			switch cmd {
+			case "list":
+				server.Filters_list()
+				return
+			case "add":
+				if len(params) == 1 {
+					if server.Filter_add_prompt() {
+						c.Write()
+						console_write("Command successful.")
+					} else {
+						console_write("Command unsuccessful.")
+					}
+					return
+				}
+				if len(params) < 4 {
+					console_write("A rule type, pattern, and action are required.")
+					console_write(commands.HelpText("filter"))
+					return
+				}
+				rule := &tt_filter_rule{}
+				switch strings.ToLower(params[1]) {
+				case "word":
+				case "regex", "regexp":
+					rule.Regex = true
+				default:
+					console_write("Unrecognized rule type: " + params[1] + ". The rule type must be word or regex.")
+					return
+				}
+				rule.Pattern = params[2]
+				action, ok := action_parse(params[3])
+				if !ok || action == ACTION_NONE {
+					console_write("Unrecognized action: " + params[3] + ". The available actions are log, warn, channelkick, kick, and ban.")
+					return
+				}
+				rule.Action = action_str(action)
+				for _, opt := range params[4:] {
+					if strings.HasPrefix(opt, "/") {
+						rule.Channel = opt
+						continue
+					}
+					if d, ok := time_duration_parse(opt); ok && action == ACTION_BAN {
+						rule.BanDuration = d.String()
+						continue
+					}
+					console_write("Unrecognized option: " + opt)
+					return
+				}
+				if err := server.Filter_add(rule); err != nil {
+					console_write("Failed to add the filter rule: " + err.Error())
+					return
+				}
+				c.Write()
+				console_write("Added filter rule: " + rule.Info_str())
+				return
+			case "remove", "del", "delete":
+				if server.Filter_remove_prompt(strings.Join(params[1:], " ")) {
+					c.Write()
+					console_write("Command successful.")
+				} else {
+					console_write("Command unsuccessful.")
+				}
+				return
+			case "test":
+				chanpath := ""
+				if len(params) >= 2 && strings.HasPrefix(params[1], "/") {
+					chanpath = params[1]
+					params = params[1:]
+				}
+				text := strings.Join(restoreParams(params[1:], " ", "\""), " ")
+				if text == "" {
+					val, err := console_read_prompt("Please enter the text to test the filter rules against.")
+					if err != nil {
+						return
+					}
+					text = val
+				}
+				server.Filter_test(text, chanpath)
+				return
+			}
+			console_write("Unrecognized option: " + cmd)
+			console_write(commands.HelpText("filter"))
+		})
+
+	commands.AddHelp("nickpolicy",
+		"View or change the nickname policy of the active server. Users who break the policy are warned in a private message, and kicked if they haven't changed their nickname once the grace period is over. Administrators are never acted upon.",
+		"nickpolicy\r\nWill display the current nickname policy.",
+		"nickpolicy on\r\nnickpolicy off\r\nWill enable or disable the nickname policy.",
+		"nickpolicy required yes\r\nWill require users to have a nickname.",
+		"nickpolicy allowed [a-zA-Z0-9 _-]+\r\nWill only allow nicknames made entirely of the characters matched by the regular expression.",
+		"nickpolicy allowed none\r\nWill allow nicknames with any characters.",
+		"nickpolicy length 20\r\nWill allow nicknames of at most 20 characters. 0 allows any length.",
+		"nickpolicy impersonation no\r\nWill forbid users from using the username or nickname of an administrator as their nickname.",
+		"nickpolicy duplicates no\r\nWill forbid users from using a nickname that someone who logged in before them is already using.",
+		"nickpolicy grace 2m\r\nWill give users 2 minutes to change their nickname before they are kicked.",
+		"nickpolicy check\r\nWill check the nicknames of everyone currently logged in.")
+	commands.Add("nickpolicy",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			policy := server.NickPolicy_read()
+			params := strings.SplitN(param, " ", 2)
+			opt := strings.ToLower(params[0])
+			value := ""
+			if len(params) == 2 {
+				value = strings.TrimSpace(params[1])
+			}
+			yesno := func() (bool, bool) {
+				switch strings.ToLower(value) {
+				case "y", "yes", "on":
+					return true, true
+				case "n", "no", "off":
+					return false, true
+				}
+				console_write("Unrecognized value: " + value + ". The value must be yes or no.")
+				return false, false
+			}
+			switch opt {
+			case "":
+				console_write(policy.Info_str())
+				return
+			case "on", "enable":
+				policy.Enabled = true
+			case "off", "disable":
+				policy.Enabled = false
+			case "required":
+				required, ok := yesno()
+				if !ok {
+					return
+				}
+				policy.Required = required
+			case "allowed":
+				if value == "" || strings.ToLower(value) == "none" {
+					policy.Allowed = ""
+					break
+				}
+				if _, err := regexp.Compile(value); err != nil {
+					console_write("Invalid regular expression: " + err.Error())
+					return
+				}
+				policy.Allowed = value
+			case "length":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					console_write(value + " isn't a valid length. Command unsuccessful.")
+					return
+				}
+				policy.MaxLength = n
+			case "impersonation":
+				allowed, ok := yesno()
+				if !ok {
+					return
+				}
+				policy.NoImpersonation = !allowed
+			case "duplicates":
+				allowed, ok := yesno()
+				if !ok {
+					return
+				}
+				policy.NoDuplicates = !allowed
+			case "grace":
+				if _, ok := time_duration_parse(value); !ok {
+					console_write(value + " isn't a valid duration. Command unsuccessful.")
+					return
+				}
+				policy.GracePeriod = value
+			case "check":
+				if !policy.Enabled {
+					console_write(policy.Info_str())
+					return
+				}
+				count := server.NickPolicy_check_all()
+				msg := strconv.Itoa(count) + " user"
+				if count != 1 {
+					msg += "s don't"
+				} else {
+					msg += " doesn't"
+				}
+				console_write(msg + " meet the nickname policy.")
+				return
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("nickpolicy"))
+				return
+			}
+			server.NickPolicy_set(policy)
+			c.Write()
+			console_write(policy.Info_str())
+		})
+
+	commands.AddHelp("clientpolicy",
+		"View or change the client policy of the active server. Users who log in with a client that breaks the policy are sent a message asking them to upgrade, and can optionally be moved to a quarantine channel or kicked. Administrators are never acted upon.\r\nVersions and client names may contain * and ? wildcards.",
+		"clientpolicy\r\nWill display the current client policy.",
+		"clientpolicy on\r\nclientpolicy off\r\nWill enable or disable the client policy.",
+		"clientpolicy minversion 5.8\r\nWill require users to log in with version 5.8 or newer.",
+		"clientpolicy minversion none\r\nWill allow any version.",
+		"clientpolicy block 5.6.*\r\nclientpolicy unblock 5.6.*\r\nWill block or unblock the matching versions.",
+		"clientpolicy allow TeamTalk*\r\nclientpolicy unallow TeamTalk*\r\nWill add or remove an allowed client name. If any are added, only clients with matching names may be used.",
+		"clientpolicy deny BadClient\r\nclientpolicy undeny BadClient\r\nWill add or remove a denied client name.",
+		"clientpolicy message Please upgrade to {minversion}, you're using {version}.\r\nWill change the message sent to users who break the policy. {reason}, {version}, {client} and {minversion} are replaced. clientpolicy message default restores the default message.",
+		"clientpolicy action move /Quarantine\r\nclientpolicy action kick\r\nclientpolicy action none\r\nWill move users who break the policy to the given channel, kick them, or only send them the message.",
+		"clientpolicy report\r\nWill summarize how many users on each connected server meet their client policy.")
+	commands.Add("clientpolicy",
+		func(param string) {
+			params := strings.SplitN(param, " ", 2)
+			opt := strings.ToLower(params[0])
+			value := ""
+			if len(params) == 2 {
+				value = strings.TrimSpace(params[1])
+			}
+			if opt == "report" {
+				servers := c.Servers_read()
+				if len(servers) == 0 {
+					console_write("No servers have been added.")
+					return
+				}
+				msg := ""
+				for _, server := range servers {
+					msg += server.ClientPolicy_report() + "\r\n"
+				}
+				console_write(msg)
+				return
+			}
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			policy := server.ClientPolicy_read()
+			add := func(list []string) ([]string, bool) {
+				if value == "" {
+					console_write("Please specify a value.")
+					return list, false
+				}
+				for _, item := range list {
+					if strings.ToLower(item) == strings.ToLower(value) {
+						console_write(value + " has already been added.")
+						return list, false
+					}
+				}
+				return append(list, value), true
+			}
+			remove := func(list []string) ([]string, bool) {
+				for i, item := range list {
+					if strings.ToLower(item) == strings.ToLower(value) {
+						return append(list[:i], list[i+1:]...), true
+					}
+				}
+				console_write(value + " hasn't been added.")
+				return list, false
+			}
+			ok := true
+			switch opt {
+			case "":
+				console_write(policy.Info_str())
+				return
+			case "on", "enable":
+				policy.Enabled = true
+			case "off", "disable":
+				policy.Enabled = false
+			case "minversion":
+				if value == "" || strings.ToLower(value) == "none" {
+					policy.MinVersion = ""
+					break
+				}
+				policy.MinVersion = value
+			case "block":
+				policy.BlockedVersion, ok = add(policy.BlockedVersion)
+			case "unblock":
+				policy.BlockedVersion, ok = remove(policy.BlockedVersion)
+			case "allow":
+				policy.AllowedClient, ok = add(policy.AllowedClient)
+			case "unallow":
+				policy.AllowedClient, ok = remove(policy.AllowedClient)
+			case "deny":
+				policy.DeniedClient, ok = add(policy.DeniedClient)
+			case "undeny":
+				policy.DeniedClient, ok = remove(policy.DeniedClient)
+			case "message":
+				if value == "" || strings.ToLower(value) == "default" {
+					policy.Message = ""
+					break
+				}
+				policy.Message = value
+			case "action":
+				params = strings.SplitN(value, " ", 2)
+				switch strings.ToLower(params[0]) {
+				case "", "none":
+					policy.Action = ""
+					policy.Quarantine = ""
+				case "kick":
+					policy.Action = "kick"
+					policy.Quarantine = ""
+				case "move":
+					if len(params) != 2 || strings.TrimSpace(params[1]) == "" {
+						console_write("Please specify the path of the quarantine channel.")
+						return
+					}
+					path := strings.TrimSpace(params[1])
+					if !strings.HasSuffix(path, "/") {
+						path += "/"
+					}
+					if server.connected() && server.Channel_find_path_exact(path) == nil {
+						console_write("Warning: " + path + " doesn't exist on the server.")
+					}
+					policy.Action = "move"
+					policy.Quarantine = path
+				default:
+					console_write("Unrecognized action: " + params[0] + ". The action must be none, kick or move.")
+					return
+				}
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("clientpolicy"))
+				return
+			}
+			if !ok {
+				console_write("Command unsuccessful.")
+				return
+			}
+			server.ClientPolicy_set(policy)
+			c.Write()
+			console_write(policy.Info_str())
+		})
+
+	commands.AddHelp("guard",
+		"Guards channels of the active server by locking their properties. When anyone else changes a locked property, the bot restores it and reports who was likely responsible. The properties which can be locked are topic, password, oppassword, maxusers, options and diskquota. Restoring properties requires permission to modify channels, or being an operator of the channel.",
+		"guard\r\nWill list the guarded channels.",
+		"guard lock /lobby\r\nWill lock every property of the lobby channel to its current value.",
+		"guard lock /lobby topic maxusers\r\nWill lock the topic and maximum users of the lobby channel to their current values.",
+		"guard lock /lobby \"topic=Welcome to the lobby\" password=\r\nWill lock the topic and password of the lobby channel to the given values, which removes the password, restoring them straight away if needed.",
+		"guard unlock /lobby topic\r\nWill unlock the topic of the lobby channel.",
+		"guard unlock /lobby\r\nWill stop guarding the lobby channel.",
+		"guard check\r\nWill restore the locked properties of every guarded channel.")
+	commands.Add("guard",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			switch cmd {
+			case "":
+				server.Guards_list()
+				return
+			case "check":
+				if !server.connected() {
+					console_write("Unable to check guarded channels. Not connected.")
+					return
+				}
+				count := server.Guard_check_all()
+				msg := strconv.Itoa(count) + " channel"
+				if count != 1 {
+					msg += "s were"
+				} else {
+					msg += " was"
+				}
+				console_write(msg + " restored.")
+				return
+			case "lock", "unlock":
+			default:
+				console_write("Unrecognized option: " + cmd)
+				console_write(commands.HelpText("guard"))
+				return
+			}
+			if len(params) < 2 {
+				console_write(commands.HelpText("guard"))
+				return
+			}
+			ch := server.Channel_find_path_exact(params[1])
+			path := channel_path_clean(params[1])
+			if ch == nil && server.connected() {
+				var aborted bool
+				ch, aborted = server_menu_channel(server, params[1])
+				if aborted {
+					return
+				}
+				if ch == nil {
+					console_write("Channel not found. Command unsuccessful.")
+					return
+				}
+			}
+			if ch != nil {
+				path = ch.Path_read()
+			}
+			lock, exists := server.Guard_find(path)
+			if !exists {
+				lock = tt_channel_lock{Path: path}
+			}
+			props := params[2:]
+			if len(props) == 0 {
+				props = guard_properties
+			}
+			if cmd == "unlock" {
+				if !exists {
+					console_write(path + " isn't guarded.")
+					return
+				}
+				for _, prop := range props {
+					if err := lock.Property_unlock(strings.ToLower(prop)); err != nil {
+						console_write(err.Error())
+						return
+					}
+				}
+				server.Guard_set(lock)
+				c.Write()
+				if lock.Empty() {
+					console_write(path + " is no longer guarded.")
+					return
+				}
+				console_write("Locked: " + lock.Info_str())
+				return
+			}
+			for _, prop := range props {
+				fields := strings.SplitN(prop, "=", 2)
+				var value *string
+				if len(fields) == 2 {
+					value = &fields[1]
+				}
+				if err := lock.Property_lock(strings.ToLower(fields[0]), ch, value); err != nil {
+					console_write(err.Error())
+					return
+				}
+			}
+			server.Guard_set(lock)
+			c.Write()
+			console_write("Locked: " + lock.Info_str())
+			if ch != nil && server.connected() {
+				server.Guard_check(ch)
+			}
+		})
+
+	commands.AddHelp("channel",
+		"Creates, updates, removes or displays channels on the active server. The properties of a channel are name, topic, password, oppassword, maxusers, diskquota, options, audiocodec and audiocfg. Options are a list of permanent, solo transmit, classroom, operator receive only, no voice activation and no recording. The audio codec and configuration are lists of numbers, in the same form they are displayed by channel info.\r\nProperties which contain spaces must be quoted in full, such as \"topic=Welcome to the lobby\".",
+		"channel info /lobby\r\nWill display the properties of the lobby channel.",
+		"channel add\r\nWill guide you through prompts to create a channel.",
+		"channel add /lobby/Quiet \"topic=No talking\" maxusers=10 \"options=permanent, no recording\"\r\nWill create a channel named Quiet inside the lobby channel. Properties which aren't given are empty, except the maximum users, audio codec and audio configuration, which are copied from the parent channel.",
+		"channel edit /lobby\r\nWill guide you through changing the properties of the lobby channel.",
+		"channel edit /lobby topic=Welcome password=\r\nWill change the topic of the lobby channel, and remove its password. Channels can be renamed, but not moved to another parent channel.",
+		"channel remove /lobby/Quiet\r\nWill remove the Quiet channel, after asking for confirmation.")
+	commands.Add("channel",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if !server.connected() {
+				console_write("Not connected.")
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			chval := ""
+			if len(params) >= 2 {
+				chval = params[1]
+			}
+			switch cmd {
+			case "info":
+				ch, aborted := server.Channel_select(chval)
+				if aborted {
+					return
+				}
+				console_write(ch.Info_str())
+			case "add":
+				if chval == "" {
+					console_write("Selecting the parent channel.")
+					parent, aborted := server.Channel_select("")
+					if aborted {
+						return
+					}
+					if !server.Channel_create_prompt(parent) {
+						console_write("Command unsuccessful.")
+						return
+					}
+					console_write("Command successful.")
+					return
+				}
+				path := strings.TrimSuffix(channel_path_clean(chval), "/")
+				index := strings.LastIndex(path, "/")
+				name := path[index+1:]
+				parent := server.Channel_find_path_exact(path[:index+1])
+				if parent == nil {
+					console_write("The parent channel " + path[:index+1] + " doesn't exist. Command unsuccessful.")
+					return
+				}
+				props, err := channel_properties_parse(params[2:])
+				if err != nil {
+					console_write(err.Error())
+					return
+				}
+				if !server.Channel_create(parent, name, props) {
+					console_write("Command unsuccessful.")
+					return
+				}
+				console_write("Command successful.")
+			case "edit":
+				ch, aborted := server.Channel_select(chval)
+				if aborted {
+					return
+				}
+				res := false
+				if len(params) <= 2 {
+					res = server.Channel_update_prompt(ch)
+				} else {
+					props, err := channel_properties_parse(params[2:])
+					if err != nil {
+						console_write(err.Error())
+						return
+					}
+					res = server.Channel_update(ch, props)
+				}
+				if !res {
+					console_write("Command unsuccessful.")
+					return
+				}
+				console_write("Command successful.")
+			case "remove":
+				ch, aborted := server.Channel_select(chval)
+				if aborted {
+					return
+				}
+				msg := "Would you like to remove " + ch.Path_read()
+				if users := len(ch.Users_read()); users != 0 {
+					msg += ", which " + strconv.Itoa(users) + " user"
+					if users != 1 {
+						msg += "s are"
+					} else {
+						msg += " is"
+					}
+					msg += " in"
+				}
+				answer, aborted := console_read_confirm(msg + "?\r\n")
+				if aborted {
+					return
+				}
+				if !answer {
+					console_write("Aborted.")
+					return
+				}
+				if !server.Channel_delete(ch) {
+					console_write("Command unsuccessful.")
+					return
+				}
+				console_write("Command successful.")
+			default:
+				console_write(commands.HelpText("channel"))
+			}
+		})
+
+	commands.AddHelp("channels",
+		"Keeps the channels of the active server matching a layout file. The file lists each channel by path, with any of the properties topic, password, oppassword, maxusers, diskquota, options, audiocodec and audiocfg. Properties left out are left as they are, parent channels which aren't listed are created with default properties, and channels which aren't listed are left alone unless the file sets removeUnlisted=\"true\", in which case they are removed along with their subchannels. If no file is given, channels.xml in the state directory of the server is used.\r\nPasswords are only visible to administrators, so other users will see password changes in every plan.",
+		"channels export\r\nWill write the current channels of the server to the layout file, as a starting point.",
+		"channels plan\r\nWill display the changes needed to make the server match the layout file, without changing anything.",
+		"channels sync\r\nWill display the changes needed to make the server match the layout file, and make them after asking for confirmation.",
+		"channels sync layouts/event.xml\r\nWill do the same with a different layout file.")
+	commands.Add("channels",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if !server.connected() {
+				console_write("Not connected.")
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := ""
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			fname := server.Layout_path()
+			if len(params) >= 2 {
+				fname = params[1]
+			}
+			switch cmd {
+			case "export":
+				if err := state_write(fname, server.Layout_export()); err != nil {
+					console_write("Failed to write " + fname + ": " + err.Error())
+					return
+				}
+				console_write("Layout written to " + fname + ".")
+			case "plan", "sync":
+				layout, err := layout_read(fname)
+				if err != nil {
+					console_write("Failed to read " + fname + ": " + err.Error())
+					return
+				}
+				actions, err := server.Layout_plan(layout)
+				if err != nil {
+					console_write(err.Error())
+					return
+				}
+				console_write(layout_plan_str(actions))
+				if cmd == "plan" || len(actions) == 0 {
+					return
+				}
+				answer, aborted := console_read_confirm("Would you like to make these changes?\r\n")
+				if aborted {
+					return
+				}
+				if !answer {
+					console_write("Aborted.")
+					return
+				}
+				count := server.Layout_apply(actions)
+				console_write(strconv.Itoa(count) + " of " + strconv.Itoa(len(actions)) + " changes made.")
+			default:
+				console_write(commands.HelpText("channels"))
+			}
+		})
+
+	commands.AddHelp("tree",
+		"Displays the channels of the active server as a tree, with the users in each channel. Each channel shows how many users are in it, whether it is password protected, its options and its topic. Each user shows their status and whether they are an operator of the channel.",
+		"tree\r\nWill display every channel on the server.",
+		"tree occupied\r\nWill leave out channels which have no users in them or in their subchannels.",
+		"tree 2\r\nWill display the root channel and its subchannels, counting the subchannels below them.",
+		"tree /lobby occupied 1\r\nWill display only the lobby channel and its users, leaving out empty subchannels.")
+	commands.Add("tree",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if !server.connected() {
+				console_write("Not connected.")
+				return
+			}
+			options := tt_tree_options{}
+			var ch *tt_channel
+			for _, val := range stringSeperateParam(param, " ", "\"") {
+				if depth, err := strconv.Atoi(val); err == nil && depth > 0 {
+					options.depth = depth
+					continue
+				}
+				if strings.ToLower(val) == "occupied" {
+					options.hideEmpty = true
+					continue
+				}
+				if strings.HasPrefix(val, "/") {
+					ch = server.Channel_find_path_exact(val)
+					if ch == nil {
+						console_write("The channel " + val + " doesn't exist.")
+						return
+					}
+					continue
+				}
+				console_write(commands.HelpText("tree"))
+				return
+			}
+			console_write(server.Channel_tree(ch, options))
+		})
+
+	commands.AddHelp("files",
+		"Lists, downloads, uploads and deletes the files of channels on the active server. Transfers continue in the background, and are logged when they finish. Uploading needs permission to upload files and enough disk quota in the channel, unless the bot is an administrator, and downloading needs permission to download files.",
+		"files\r\nWill list the files in every channel, with their sizes and owners.",
+		"files list /lobby\r\nWill list the files in the lobby channel.",
+		"files get notes.txt\r\nWill download notes.txt to the current directory. If more than one channel has a file with that name, you will be asked to select one.",
+		"files get notes.txt downloads\r\nWill download notes.txt into the downloads directory.",
+		"files put \"My Notes.txt\" /lobby\r\nWill upload My Notes.txt to the lobby channel. If no channel is given, the file is uploaded to the channel the bot is in.",
+		"files delete notes.txt\r\nWill delete notes.txt, after asking for confirmation.",
+		"files archive add /recordings\r\nWill copy every file posted to the recordings channel into the archive directory of the server, along with an index of each file's owner, size and the time it was posted. Files already in the channel are copied straight away.",
+		"files archive add /recordings pruneafter=30d pruneat=90\r\nWill do the same, and remove files from the server once they are 30 days old, or once the channel has used 90 percent of its disk quota, starting with the oldest. Only files which have been copied are removed, and the local copies are kept. Give a value of 0 to stop either.",
+		"files archive remove /recordings\r\nWill stop archiving the recordings channel, keeping the files already archived.",
+		"files archive status\r\nWill display each archived channel, how many files have been archived and removed from the server, and how many are waiting to be archived.")
+	commands.Add("files",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if !server.connected() {
+				console_write("Not connected.")
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := "list"
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			val := ""
+			if len(params) >= 2 {
+				val = params[1]
+			}
+			switch cmd {
+			case "list":
+				var ch *tt_channel
+				if val != "" {
+					aborted := false
+					ch, aborted = server.Channel_select(val)
+					if aborted {
+						return
+					}
+				}
+				console_write(server.Files_list(ch))
+			case "get":
+				if reason := server.File_download_check(); reason != "" {
+					console_write(reason)
+					return
+				}
+				if val == "" {
+					console_write(commands.HelpText("files"))
+					return
+				}
+				ch, f, aborted := server.File_select(val)
+				if aborted {
+					return
+				}
+				dest := ""
+				if len(params) >= 3 {
+					dest = params[2]
+				}
+				fname := ch.File_name(f)
+				console_write("Downloading " + fname + ", " + size_str(ch.File_size(f)) + ".")
+				go func() {
+					if _, err := server.File_download(ch, fname, dest); err != nil {
+						server.Log_write("Failed to download "+fname+": "+err.Error(), true)
+					}
+				}()
+			case "put":
+				if val == "" {
+					console_write(commands.HelpText("files"))
+					return
+				}
+				var ch *tt_channel
+				if len(params) >= 3 {
+					aborted := false
+					ch, aborted = server.Channel_select(params[2])
+					if aborted {
+						return
+					}
+				} else if usr := server.User_find_id(server.Uid_read()); usr != nil {
+					ch = usr.Channel_read()
+				}
+				if ch == nil {
+					console_write("You aren't in a channel. Please give the channel to upload to.")
+					return
+				}
+				info, err := os.Stat(val)
+				if err != nil {
+					console_write(err.Error())
+					return
+				}
+				if reason := server.File_upload_check(ch, filepath.Base(val), int(info.Size())); reason != "" {
+					console_write(reason)
+					return
+				}
+				console_write("Uploading " + filepath.Base(val) + " to " + ch.Path_read() + ", " + size_str(int(info.Size())) + ".")
+				go func() {
+					if err := server.File_upload(ch, val); err != nil {
+						server.Log_write("Failed to upload "+val+": "+err.Error(), true)
+					}
+				}()
+			case "delete":
+				if val == "" {
+					console_write(commands.HelpText("files"))
+					return
+				}
+				ch, f, aborted := server.File_select(val)
+				if aborted {
+					return
+				}
+				fname := ch.File_name(f)
+				answer, aborted := console_read_confirm("Would you like to delete " + fname + " from " + ch.Path_read() + "?\r\n")
+				if aborted {
+					return
+				}
+				if !answer {
+					console_write("Aborted.")
+					return
+				}
+				if !server.File_delete(ch, fname) {
+					console_write("Command unsuccessful.")
+					return
+				}
+				console_write("Command successful.")
+			case "archive":
+				sub := strings.ToLower(val)
+				chval := ""
+				if len(params) >= 3 {
+					chval = params[2]
+				}
+				switch sub {
+				case "", "status":
+					console_write(server.Archive_status())
+				case "add":
+					ch, aborted := server.Channel_select(chval)
+					if aborted {
+						return
+					}
+					ac, _ := server.Archive_find(ch.Path_read())
+					ac.Path = ch.Path_read()
+					opts := []string{}
+					if len(params) > 3 {
+						opts = params[3:]
+					}
+					if err := options_set(&ac, opts); err != nil {
+						console_write(err.Error())
+						return
+					}
+					server.Archive_set(ac)
+					c.Write()
+					console_write("Archiving " + ac.Info_str() + ".")
+					go server.Archive_check_all()
+				case "remove":
+					ch, aborted := server.Channel_select(chval)
+					if aborted {
+						return
+					}
+					if !server.Archive_remove(ch.Path_read()) {
+						console_write(ch.Path_read() + " isn't archived.")
+						return
+					}
+					c.Write()
+					console_write("No longer archiving " + ch.Path_read() + ". Files already archived are kept.")
+				default:
+					console_write(commands.HelpText("files"))
+				}
+			default:
+				console_write(commands.HelpText("files"))
+			}
+		})
+
+	commands.AddHelp("welcome",
+		"View or change the welcome messages of the active server, which are sent to users in a private message when they log in, or when they join particular channels. Users with a username are remembered, so they can be sent a different message the first time they are seen. Users without a username are always sent the regular message.\r\nMessages can contain {nickname}, {username}, {users} for the number of users logged in, {server} for the name of the server, and {channel} for the channel joined.",
+		"welcome\r\nWill display the current welcome messages.",
+		"welcome on\r\nwelcome off\r\nWill enable or disable welcome messages.",
+		"welcome message Welcome back, {nickname}. {users} users are online.\r\nWill set the message sent to users when they log in.",
+		"welcome first Welcome to {server}, {nickname}! Please read the rules in /lobby.\r\nWill set the message sent to users the first time they log in, in place of the regular message.",
+		"welcome message none\r\nWill stop sending the regular message when users log in.",
+		"welcome channel /help Ask your question here, {nickname}, and someone will be with you shortly.\r\nWill set the message sent to users when they join the help channel.",
+		"welcome channelfirst /help This is your first time here, so please be patient.\r\nWill set the message sent to users the first time they join the help channel.",
+		"welcome channel /help none\r\nWill stop sending the regular message when users join the help channel.")
+	commands.Add("welcome",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			welcome := server.Welcome_read()
+			params := strings.SplitN(param, " ", 2)
+			opt := strings.ToLower(params[0])
+			value := ""
+			if len(params) == 2 {
+				value = strings.TrimSpace(params[1])
+			}
+			if strings.ToLower(value) == "none" {
+				value = ""
+			}
+			switch opt {
+			case "":
+				console_write(welcome.Info_str())
+				return
+			case "on", "enable":
+				welcome.Enabled = true
+			case "off", "disable":
+				welcome.Enabled = false
+			case "message":
+				welcome.Message = value
+			case "first":
+				welcome.First = value
+			case "channel", "channelfirst":
+				fields := strings.SplitN(value, " ", 2)
+				if fields[0] == "" {
+					console_write(commands.HelpText("welcome"))
+					return
+				}
+				path := channel_path_clean(fields[0])
+				message := ""
+				if len(fields) == 2 && strings.ToLower(strings.TrimSpace(fields[1])) != "none" {
+					message = strings.TrimSpace(fields[1])
+				}
+				if server.connected() && server.Channel_find_path_exact(path) == nil {
+					console_write("Warning: the channel " + path + " doesn't exist.")
+				}
+				welcome.Channel_set(path, opt == "channelfirst", message)
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("welcome"))
+				return
+			}
+			server.Welcome_set(welcome)
+			c.Write()
+			console_write(welcome.Info_str())
+		})
+
+	commands.AddHelp("schedule",
+		"Sends messages on a schedule on the active server, to a channel, to a user by username, or as a broadcast. Messages are sent at a fixed interval, or at the times given by a cron expression of minute, hour, day of month, month and day of week, in local time. Schedules are only checked while the bot is logged in, and a message which was due while the bot was disconnected is skipped. Channel messages need the bot to be in the channel unless it is an administrator, and broadcasts need permission to send broadcast messages.",
+		"schedule\r\nschedule list\r\nWill list the schedules, and when each will next send its message.",
+		"schedule add\r\nWill guide you through prompts to add a schedule.",
+		"schedule add name=rules to=/lobby every=1h \"message=Please be kind to one another.\"\r\nWill send a message to the lobby channel every hour.",
+		"schedule add name=meeting to=/Meetings \"cron=45 18 * * 3\" \"message=The weekly meeting starts in 15 minutes.\"\r\nWill send a reminder to the Meetings channel at 18:45 every Wednesday.",
+		"schedule add name=maintenance to=broadcast \"cron=0 9 1 * *\" \"message=Maintenance happens today at noon.\"\r\nWill broadcast a message at 9:00 on the first day of every month.",
+		"schedule remove rules\r\nWill remove the rules schedule.",
+		"schedule pause rules\r\nschedule resume rules\r\nWill stop sending the rules schedule, or start sending it again.")
+	commands.Add("schedule",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			params := stringSeperateParam(param, " ", "\"")
+			cmd := "list"
+			if len(params) >= 1 {
+				cmd = strings.ToLower(params[0])
+			}
+			name := ""
+			if len(params) >= 2 {
+				name = params[1]
+			}
+			switch cmd {
+			case "list":
+				server.Schedules_list()
+				return
+			case "add":
+				var sched *tt_schedule
+				if len(params) < 2 {
+					sched = server.Schedule_add_prompt()
+					if sched == nil {
+						return
+					}
+				} else {
+					sched = &tt_schedule{}
+					if err := options_set(sched, params[1:]); err != nil {
+						console_write(err.Error())
+						return
+					}
+					if reason := sched.Check(); reason != "" {
+						console_write(reason)
+						return
+					}
+					if server.Schedule_find(sched.Name) != nil {
+						console_write("A schedule named " + sched.Name + " already exists.")
+						return
+					}
+				}
+				server.Schedule_add(sched)
+				c.Write()
+				console_write("Added schedule " + sched.Name + ", " + sched.To_str() + " " + sched.When_str() + ".")
+				return
+			case "remove", "pause", "resume":
+				if name == "" {
+					console_write(commands.HelpText("schedule"))
+					return
+				}
+				sched := server.Schedule_find(name)
+				if sched == nil {
+					console_write("No schedule named " + name + " exists.")
+					return
+				}
+				switch cmd {
+				case "remove":
+					server.Schedule_remove(sched)
+					console_write("Removed schedule " + sched.Name + ".")
+				case "pause":
+					server.Schedule_pause(sched, true)
+					console_write("Paused schedule " + sched.Name + ".")
+				case "resume":
+					server.Schedule_pause(sched, false)
+					console_write("Resumed schedule " + sched.Name + ".")
+				}
+				c.Write()
+			default:
+				console_write(commands.HelpText("schedule"))
+			}
+		})
+
+	commands.AddHelp("tell",
+		"Leaves a memo for a username on the active server, which is delivered in a private message the next time they log in, or straight away if they are logged in. Users can do the same by sending tell to the bot in a private message, or !tell in a channel.",
+		"tell tech The server will be down for maintenance tonight.\r\nWill leave a memo for the username tech.")
+	commands.Add("tell",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if strings.TrimSpace(param) == "" {
+				console_write(commands.HelpText("tell"))
+				return
+			}
+			console_write(server.Memo_tell(nil, param))
+		})
+
+	commands.AddHelp("memos",
+		"View or change the memos of the active server. Memos from users are limited to a number waiting to be delivered per sender, and expire if they aren't delivered in time. Memos left from the console aren't limited.",
+		"memos\r\nWill list the memos waiting to be delivered.",
+		"memos settings\r\nWill display whether memos are enabled, and their limits.",
+		"memos on\r\nmemos off\r\nWill enable or disable memos.",
+		"memos limit 3\r\nWill allow each user 3 memos waiting to be delivered.",
+		"memos expiry 2w\r\nWill remove memos which haven't been delivered within 2 weeks.",
+		"memos remove 2\r\nWill remove the second memo in the list.")
+	commands.Add("memos",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			conf := server.MemoConfig_read()
+			params := strings.SplitN(param, " ", 2)
+			opt := strings.ToLower(params[0])
+			value := ""
+			if len(params) == 2 {
+				value = strings.TrimSpace(params[1])
+			}
+			switch opt {
+			case "", "list":
+				server.Memos_list()
+				return
+			case "settings":
+				console_write(conf.Info_str())
+				return
+			case "on", "enable":
+				conf.Disabled = false
+			case "off", "disable":
+				conf.Disabled = true
+			case "limit":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					console_write(value + " isn't a valid limit. Command unsuccessful.")
+					return
+				}
+				conf.Limit = n
+			case "expiry":
+				if _, ok := time_duration_parse(value); !ok {
+					console_write(value + " isn't a valid duration. Command unsuccessful.")
+					return
+				}
+				conf.Expiry = value
+			case "remove":
+				pending := server.Memos_pending()
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 || n > len(pending) {
+					console_write("Please give the number of a memo in the list.")
+					server.Memos_list()
+					return
+				}
+				server.Memo_remove(pending[n-1])
+				console_write("Removed: " + pending[n-1].Info_str())
+				return
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("memos"))
+				return
+			}
+			server.MemoConfig_set(conf)
+			c.Write()
+			console_write(conf.Info_str())
+		})
+
+	commands.AddHelp("seen",
+		"Displays when a user was last seen on the active server, matching their username or nickname. Includes the channel they were last in, how long they were connected, and their total time connected. Users can do the same by sending seen to the bot in a private message, or !seen in a channel.",
+		"seen tech\r\nWill display when the user with the username or nickname tech was last seen.")
+	commands.Add("seen",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			if strings.TrimSpace(param) == "" {
+				console_write(commands.HelpText("seen"))
+				return
+			}
+			console_write(server.Seen_query(param))
+		})
+
+	commands.AddHelp("report",
+		"Reports on the sessions recorded on the active server: unique users, new and returning users, sessions, average session length, and the peak number of users logged in at once, including the bot, against the maximum users of the server. Also lists the busiest channels and hours. Reports cover the current day or week, and those before it, and can be exported to a file ending in .csv or .json.",
+		"report\r\nWill report on the last 7 days.",
+		"report weekly 8\r\nWill report on the last 8 weeks, starting on Mondays.",
+		"report export reports/july.csv daily 31\r\nWill export a report on the last 31 days to reports/july.csv.",
+		"report settings\r\nWill display whether sessions are recorded, and how long they are kept.",
+		"report on\r\nreport off\r\nWill enable or disable recording sessions.",
+		"report retention 30d\r\nWill keep recorded sessions for 30 days.")
+	commands.Add("report",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			conf := server.AnalyticsConfig_read()
+			params := stringSeperateParam(param, " ", "\"")
+			opt := ""
+			if len(params) > 0 {
+				opt = strings.ToLower(params[0])
+			}
+			switch opt {
+			case "", REPORT_DAILY, REPORT_WEEKLY:
+				period, count, err := report_params(params)
+				if err != nil {
+					console_write(err.Error())
+					return
+				}
+				console_write(server.Report(period, count).Str())
+				return
+			case "export":
+				if len(params) < 2 {
+					console_write(commands.HelpText("report"))
+					return
+				}
+				period, count, err := report_params(params[2:])
+				if err != nil {
+					console_write(err.Error())
+					return
+				}
+				if err := server.Report(period, count).Write(params[1]); err != nil {
+					console_write("Error exporting report to " + params[1] + ": " + err.Error())
+					return
+				}
+				console_write("Report exported to " + params[1] + ".")
+				return
+			case "settings":
+				console_write(conf.Info_str())
+				return
+			case "on", "enable":
+				conf.Disabled = false
+			case "off", "disable":
+				conf.Disabled = true
+			case "retention":
+				value := ""
+				if len(params) > 1 {
+					value = params[1]
+				}
+				if _, ok := time_duration_parse(value); !ok {
+					console_write(value + " isn't a valid duration. Command unsuccessful.")
+					return
+				}
+				conf.Retention = value
+			default:
+				if _, err := strconv.Atoi(opt); err == nil {
+					period, count, err := report_params(params)
+					if err != nil {
+						console_write(err.Error())
+						return
+					}
+					console_write(server.Report(period, count).Str())
+					return
+				}
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("report"))
+				return
+			}
+			server.AnalyticsConfig_set(conf)
+			c.Write()
+			console_write(conf.Info_str())
+		})
+
+	commands.AddHelp("alerts",
+		"View or change the alerts of the active server. Alerts are raised when the users logged in reach a percentage of the maximum users of the server, the users in a channel reach a percentage of its maximum users, the files in a channel reach a percentage of its disk quota, or the number of users drops sharply, which could mean a problem with the server. Alerts are critical events, and can also be sent to channels, broadcast, or sent to users by username. An alert is cleared once it falls below its threshold by the hysteresis percentage, so it isn't raised again as users come and go. A percentage of 0 turns an alert off.",
+		"alerts\r\nWill display the alerts currently raised, and the alert settings.",
+		"alerts on\r\nalerts off\r\nWill enable or disable alerts.",
+		"alerts server 90\r\nWill raise an alert when 90% of the maximum users of the server are logged in.",
+		"alerts channel 80\r\nWill raise an alert when a channel has 80% of its maximum users.",
+		"alerts quota 95\r\nWill raise an alert when the files in a channel use 95% of its disk quota.",
+		"alerts drop 50\r\nalerts window 2m\r\nalerts minimum 10\r\nWill raise an alert when the number of users drops by half within 2 minutes, from at least 10 users.",
+		"alerts hysteresis 5\r\nWill clear alerts once they are 5% below their threshold.",
+		"alerts notify add /admins/\r\nalerts notify add broadcast\r\nalerts notify add tech\r\nWill send alerts to the admins channel, as a broadcast, and to the username tech.",
+		"alerts notify remove /admins/\r\nWill stop sending alerts to the admins channel.")
+	commands.Add("alerts",
+		func(param string) {
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			conf := server.AlertConfig_read()
+			params := strings.SplitN(strings.TrimSpace(param), " ", 2)
+			opt := strings.ToLower(params[0])
+			value := ""
+			if len(params) == 2 {
+				value = strings.TrimSpace(params[1])
+			}
+			switch opt {
+			case "":
+				active := server.Alerts_active()
+				if len(active) == 0 {
+					console_write("No alerts are raised.")
+				} else {
+					console_write("Alerts raised:\r\n" + strings.Join(active, "\r\n"))
+				}
+				console_write(conf.Info_str())
+				return
+			case "on", "enable":
+				conf.Disabled = false
+			case "off", "disable":
+				conf.Disabled = true
+			case "notify":
+				fields := strings.SplitN(value, " ", 2)
+				if len(fields) != 2 {
+					console_write(commands.HelpText("alerts"))
+					return
+				}
+				to := strings.TrimSpace(fields[1])
+				if strings.HasPrefix(to, "/") {
+					to = channel_path_clean(to)
+				}
+				switch strings.ToLower(fields[0]) {
+				case "add":
+					for _, n := range conf.Notify {
+						if strings.ToLower(n) == strings.ToLower(to) {
+							console_write("Alerts are already sent to " + to + ".")
+							return
+						}
+					}
+					conf.Notify = append(conf.Notify, to)
+				case "remove":
+					found := false
+					for i, n := range conf.Notify {
+						if strings.ToLower(n) == strings.ToLower(to) {
+							conf.Notify = append(conf.Notify[:i], conf.Notify[i+1:]...)
+							found = true
+							break
+						}
+					}
+					if !found {
+						console_write("Alerts aren't sent to " + to + ".")
+						return
+					}
+				default:
+					console_write(commands.HelpText("alerts"))
+					return
+				}
+			default:
+				if err := conf.Option_set(opt, value); err != nil {
+					console_write(err.Error())
+					console_write(commands.HelpText("alerts"))
+					return
+				}
+			}
+			server.AlertConfig_set(conf)
+			c.Write()
+			console_write(conf.Info_str())
+		})
+
+	commands.AddHelp("health",
+		"Displays the health of the connection to the active server, a server of your choice, or all servers. Latency is measured continuously from the keepalive pings sent to each server. A connection is degraded when latency spikes well above its median, or a keepalive ping isn't answered, and recovers after several normal pings.",
+		"health\r\nWill display the health of the connection to the active server.",
+		"health test\r\nWill display the health of the connection to the server test.",
+		"health all\r\nWill display the health of the connections to all servers.")
+	commands.Add("health",
+		func(param string) {
+			param = strings.TrimSpace(param)
+			if strings.ToLower(param) == "all" {
+				servers := c.Servers_read()
+				if len(servers) == 0 {
+					console_write("No servers exist.")
+					return
+				}
+				for _, server := range servers {
+					console_write(server.Health_str())
+				}
+				return
+			}
+			if param != "" {
+				servers := c.Server_find_name(param)
+				if len(servers) == 0 {
+					console_write("The server " + param + " doesn't exist.")
+					return
+				}
+				console_write(servers[0].Health_str())
+				return
+			}
+			server := server_active_check("")
+			if server == nil {
+				return
+			}
+			console_write(server.Health_str())
+		})
+
+	commands.AddHelp("multi",
+		"Runs a command on several servers at once, and summarizes the result on each. Servers are given as a comma separated list of names, patterns in which * matches any characters and ? matches one character, or all. Servers which aren't connected are skipped. The commands are broadcast, msg, status and nick. Messages to a channel are given the channel path, and messages to a user are given their nickname or username, which must match one user on each server.\r\nServer lists and destinations which contain spaces must be quoted.",
+		"multi all broadcast The servers will restart for maintenance in 10 minutes.\r\nWill send a broadcast message to every connected server.",
+		"multi public*,test msg / Welcome, everyone.\r\nWill send a message to the root channel of the test server, and each server with a name starting with public.",
+		"multi \"public 1,public 2\" msg tech Please check the logs.\r\nWill send a message to the user tech on the servers named public 1 and public 2.",
+		"multi all status away Back soon\r\nWill set the status of the bot to away on every server, with the message Back soon.",
+		"multi all nick Bot\r\nWill change the nickname of the bot to Bot on every server.")
+	commands.Add("multi",
+		func(param string) {
+			targets, param := stringSplitParam(param, " ", "\"")
+			cmd, rest := stringSplitParam(param, " ", "\"")
+			if cmd == "" {
+				console_write(commands.HelpText("multi"))
+				return
+			}
+			servers, unmatched := servers_match(targets)
+			for _, name := range unmatched {
+				console_write("No server matches " + name + ".")
+			}
+			if len(servers) == 0 {
+				console_write("Command unsuccessful. No servers were selected.")
+				return
+			}
+			var action func(server *tt_server) error
+			switch strings.ToLower(cmd) {
+			case "broadcast":
+				if strings.TrimSpace(rest) == "" {
+					console_write("Empty message unsupported. Aborted.")
+					return
+				}
+				action = func(server *tt_server) error {
+					return server.Fanout_broadcast(rest)
+				}
+			case "msg", "message":
+				dest, message := stringSplitParam(rest, " ", "\"")
+				if dest == "" || strings.TrimSpace(message) == "" {
+					console_write("Please give a channel path or user, and a message. Aborted.")
+					return
+				}
+				action = func(server *tt_server) error {
+					return server.Fanout_message(dest, message)
+				}
+			case "status":
+				mode_str, message := stringSplitParam(rest, " ", "\"")
+				mode, ok := status_mode_parse(mode_str)
+				if !ok {
+					console_write("Unrecognized status: " + mode_str)
+					return
+				}
+				action = func(server *tt_server) error {
+					return server.Fanout_status(mode, message)
+				}
+			case "nick":
+				if strings.TrimSpace(rest) == "" {
+					console_write("Please give a nickname. Aborted.")
+					return
+				}
+				action = func(server *tt_server) error {
+					return server.Fanout_nick(rest)
+				}
+			default:
+				console_write("Unrecognized command: " + cmd)
+				console_write(commands.HelpText("multi"))
+				return
+			}
+			console_write(fanout_summary(servers_fanout(servers, action)))
+			if strings.ToLower(cmd) == "nick" {
+				c.Write()
+			}
+		})
+
+	commands.AddHelp("relay",
+		"Links channels on two servers, so channel messages delivered in either are relayed to the other, prefixed with the server and nickname of the sender. Join and leave notices can also be relayed. The bot must be in both channels, unless it is an administrator. The bot never relays its own messages, or messages it has just relayed, so relays can't loop. Servers are given by name, and must be quoted if they contain spaces.",
+		"relay\r\nWill list the relays.",
+		"relay add public / test /lobby/\r\nWill relay messages between the root channel of the server public and the lobby channel of the server test.",
+		"relay add public / test /lobby/ notices\r\nWill do the same, also relaying when users join and leave either channel.",
+		"relay notices 1 on\r\nrelay notices 1 off\r\nWill relay, or stop relaying, join and leave notices for the first relay.",
+		"relay remove 1\r\nWill remove the first relay.")
+	commands.Add("relay",
+		func(param string) {
+			params := stringSeperateParam(param, " ", "\"")
+			opt := ""
+			if len(params) > 0 {
+				opt = strings.ToLower(params[0])
+			}
+			relay_find := func(n string) *tt_relay {
+				relays := c.Relays_read()
+				i, err := strconv.Atoi(n)
+				if err != nil || i < 1 || i > len(relays) {
+					console_write("Please give the number of a relay in the list.")
+					relays_list()
+					return nil
+				}
+				return relays[i-1]
+			}
+			switch opt {
+			case "", "list":
+				relays_list()
+				return
+			case "add":
+				if len(params) < 5 || len(params) > 6 || (len(params) == 6 && strings.ToLower(params[5]) != "notices") {
+					console_write(commands.HelpText("relay"))
+					return
+				}
+				relay := &tt_relay{
+					Notices: len(params) == 6,
+					Ends:    []tt_relay_end{{Server: params[1], Channel: params[2]}, {Server: params[3], Channel: params[4]}},
+				}
+				if err := c.Relay_add(relay); err != nil {
+					console_write(err.Error() + " Command unsuccessful.")
+					return
+				}
+				console_write("Relaying " + relay.Str() + ".")
+			case "remove":
+				if len(params) != 2 {
+					console_write(commands.HelpText("relay"))
+					return
+				}
+				relay := relay_find(params[1])
+				if relay == nil {
+					return
+				}
+				c.Relay_remove(relay)
+				console_write("Stopped relaying " + relay.Str() + ".")
+			case "notices":
+				if len(params) != 3 || (strings.ToLower(params[2]) != "on" && strings.ToLower(params[2]) != "off") {
+					console_write(commands.HelpText("relay"))
+					return
+				}
+				relay := relay_find(params[1])
+				if relay == nil {
+					return
+				}
+				c.Relay_notices_set(relay, strings.ToLower(params[2]) == "on")
+				console_write("Relaying " + relay.Str() + ".")
+			default:
+				console_write("Unrecognized option: " + opt)
+				console_write(commands.HelpText("relay"))
+				return
+			}
+			c.Write()
+		})
+
 	commands.AddHelp("panic",
 		"Initiates a runtime panic. You probably shouldn't use this.")
 	commands.Add("panic",
@@ -33,11 +33,13 @@ type config struct {
 	UseGlobalNickName          bool         `xml:"defaults>useGlobalNickName"`
 	UseDefaults                bool         `xml:"defaults>useOnServerCreate"`
 	Servers                    []*tt_server `xml:"servers>server,omitempty"`
+	Relays                     []*tt_relay  `xml:"relays>relay,omitempty"`
 	cfile                      string
 	timestamp_console          string
 	logged_console             string
 	wg                         sync.WaitGroup
 	server                     *tt_server
+	relay_sent                 map[string]time.Time
 }
 
 var c *config
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Functions for running a command on several servers at once, selected by name, by a pattern matching display names, or all.
+
+const FANOUT_ALL = "all"
+
+type tt_fanout_result struct {
+	server *tt_server
+	err    error
+}
+
+// Returns the servers selected by a comma separated list of names, patterns using * and ?, or all.
+// Names which match no server are returned separately.
+func servers_match(targets string) ([]*tt_server, []string) {
+	servers := []*tt_server{}
+	unmatched := []string{}
+	added := make(map[*tt_server]bool)
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		found := false
+		for _, server := range c.Servers_read() {
+			name := server.DisplayName_read()
+			if strings.ToLower(target) == FANOUT_ALL || strings.ToLower(target) == strings.ToLower(name) || str_glob_match(target, name) {
+				found = true
+				if !added[server] {
+					added[server] = true
+					servers = append(servers, server)
+				}
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, target)
+		}
+	}
+	return servers, unmatched
+}
+
+// Runs action on every server at the same time, since each waits for its server to reply.
+func servers_fanout(servers []*tt_server, action func(server *tt_server) error) []tt_fanout_result {
+	results := make([]tt_fanout_result, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		results[i].server = server
+		if !server.connected() || !server.Logged_in_read() {
+			results[i].err = errors.New("Not connected.")
+			continue
+		}
+		wg.Add(1)
+		go func(i int, server *tt_server) {
+			defer wg.Done()
+			results[i].err = action(server)
+		}(i, server)
+	}
+	wg.Wait()
+	return results
+}
+
+func fanout_summary(results []tt_fanout_result) string {
+	succeeded := 0
+	lines := []string{}
+	for _, result := range results {
+		line := result.server.DisplayName_read() + ": "
+		if result.err == nil {
+			succeeded++
+			line += "Command successful."
+		} else {
+			line += "Command unsuccessful. " + result.err.Error()
+		}
+		lines = append(lines, line)
+	}
+	str := "Command successful on " + strconv.Itoa(succeeded) + " of " + strconv.Itoa(len(results)) + " server"
+	if len(results) != 1 {
+		str += "s"
+	}
+	return str + ".\r\n" + strings.Join(lines, "\r\n")
+}
+
+func status_mode_parse(str string) (int, bool) {
+	switch strings.ToLower(str) {
+	case "", TT_USERSTATUS_NONE_STR:
+		return TT_USERSTATUS_NONE, true
+	case TT_USERSTATUS_AWAY_STR:
+		return TT_USERSTATUS_AWAY, true
+	}
+	return 0, false
+}
+
+func (server *tt_server) Fanout_broadcast(message string) error {
+	if !server.User_rights_check(TT_USERRIGHT_TEXT_MESSAGE_BROADCAST) {
+		return errors.New("Broadcast messages aren't permitted.")
+	}
+	if !server.cmd_message_broadcast(message) {
+		return errors.New("The broadcast failed.")
+	}
+	return nil
+}
+
+// Sends a message to a channel by path, or to the one user with a matching nickname or username.
+func (server *tt_server) Fanout_message(dest, message string) error {
+	if strings.HasPrefix(dest, "/") {
+		if reason := server.Message_send(channel_path_clean(dest), message); reason != "" {
+			return errors.New(str_capitalize(reason) + ".")
+		}
+		return nil
+	}
+	users := []*tt_user{}
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		if strings.ToLower(usr.NickName_read()) == strings.ToLower(dest) || strings.ToLower(usr.UserName_read()) == strings.ToLower(dest) {
+			users = append(users, usr)
+		}
+	}
+	if len(users) == 0 {
+		return errors.New(dest + " isn't logged in.")
+	}
+	if len(users) > 1 {
+		return errors.New(strconv.Itoa(len(users)) + " users match " + dest + ".")
+	}
+	if !server.cmd_message_user(users[0].Uid_read(), message) {
+		return errors.New("The message failed.")
+	}
+	return nil
+}
+
+func (server *tt_server) Fanout_status(mode int, message string) error {
+	if !server.cmd_changestatus(mode, message) {
+		return errors.New("The status wasn't changed.")
+	}
+	return nil
+}
+
+func (server *tt_server) Fanout_nick(nick string) error {
+	if usr := server.User_find_id(server.Uid_read()); usr != nil && usr.NickName_read() == nick {
+		return nil
+	}
+	if !server.cmd_changenick(nick) {
+		return errors.New("The nickname wasn't changed.")
+	}
+	server.UseGlobalNickName_set(false)
+	server.NickName_set(nick)
+	return nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for listing, uploading, downloading and deleting the files of channels.
+// Transfers are registered on the connection of the bot, then carried out on a separate connection to the server,
+// which is given the transfer ID in place of logging in.
+
+const FILE_TRANSFER_TIMEOUT = 30 * time.Second
+
+// Formats a number of bytes in the largest unit that leaves at least 1 of it.
+func size_str(size int) string {
+	units := []string{"bytes", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.Itoa(size) + " bytes"
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[unit]
+}
+
+func (ch *tt_channel) Files_size() int {
+	total := 0
+	for _, f := range ch.Files_read() {
+		total += ch.File_size(f)
+	}
+	return total
+}
+
+func (ch *tt_channel) Files_list_str() string {
+	files := ch.Files_read()
+	if len(files) == 0 {
+		return ""
+	}
+	str := ch.Path_read() + ": " + strconv.Itoa(len(files)) + " file"
+	if len(files) != 1 {
+		str += "s"
+	}
+	str += ", " + size_str(ch.Files_size()) + " of " + size_str(ch.Quota_read()) + " used\r\n"
+	for _, f := range files {
+		str += ch.File_name(f) + ", " + size_str(ch.File_size(f))
+		if owner := ch.File_owner(f); owner != "" {
+			str += ", uploaded by " + owner
+		}
+		str += "\r\n"
+	}
+	return str
+}
+
+// Lists the files of ch, or of every channel if ch is nil.
+func (server *tt_server) Files_list(ch *tt_channel) string {
+	channels := []*tt_channel{ch}
+	if ch == nil {
+		channels = server.Channels_sort()
+	}
+	msg := ""
+	for _, channel := range channels {
+		msg += channel.Files_list_str()
+	}
+	if msg == "" {
+		return "No files found."
+	}
+	return msg
+}
+
+type tt_file_match struct {
+	ch   *tt_channel
+	file *tt_file
+}
+
+// Finds files named fname in ch, or in every channel if ch is nil.
+func (server *tt_server) Files_find(fname string, ch *tt_channel) []tt_file_match {
+	channels := []*tt_channel{ch}
+	if ch == nil {
+		channels = server.Channels_sort()
+	}
+	matches := []tt_file_match{}
+	for _, channel := range channels {
+		for _, f := range channel.Files_read() {
+			if strings.ToLower(channel.File_name(f)) == strings.ToLower(fname) {
+				matches = append(matches, tt_file_match{channel, f})
+			}
+		}
+	}
+	return matches
+}
+
+// Selects a file by name, with a menu if files with that name are in more than one channel.
+func (server *tt_server) File_select(fname string) (*tt_channel, *tt_file, bool) {
+	matches := server.Files_find(fname, nil)
+	if len(matches) == 0 {
+		console_write("The file " + fname + " doesn't exist.")
+		return nil, nil, true
+	}
+	if len(matches) == 1 {
+		return matches[0].ch, matches[0].file, false
+	}
+	menu := []string{}
+	for _, m := range matches {
+		menu = append(menu, m.ch.Path_read()+m.ch.File_name(m.file)+", "+size_str(m.ch.File_size(m.file)))
+	}
+	res, aborted := console_read_menu("Please select a file.\r\n", menu)
+	if aborted || res == -1 {
+		return nil, nil, true
+	}
+	return matches[res].ch, matches[res].file, false
+}
+
+// Returns why the bot can't upload a file of size bytes to ch, or an empty string.
+func (server *tt_server) File_upload_check(ch *tt_channel, fname string, size int) string {
+	if !server.User_rights_check(TT_USERRIGHT_UPLOAD_FILES) {
+		return "Insufficient permission to upload files."
+	}
+	if ch.File_find_name(fname) != nil {
+		return "A file named " + fname + " already exists in " + ch.Path_read() + "."
+	}
+	if server.User_type_read() == TT_USERTYPE_ADMIN {
+		return ""
+	}
+	used := ch.Files_size()
+	if used+size > ch.Quota_read() {
+		return "Not enough disk quota in " + ch.Path_read() + ". " + size_str(ch.Quota_read()-used) + " of " + size_str(ch.Quota_read()) + " is free, and the file is " + size_str(size) + "."
+	}
+	return ""
+}
+
+func (server *tt_server) File_download_check() string {
+	if !server.User_rights_check(TT_USERRIGHT_DOWNLOAD_FILES) {
+		return "Insufficient permission to download files."
+	}
+	return ""
+}
+
+type tt_file_conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Reads a line from the transfer connection, returning the command and its parameters.
+// An error message from the server is returned as an error.
+func (fc *tt_file_conn) Read_cmd() (string, map[string]string, error) {
+	fc.conn.SetReadDeadline(time.Now().Add(FILE_TRANSFER_TIMEOUT))
+	line, err := fc.reader.ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	line = strings.TrimSpace(line)
+	cmd := teamtalk_get_cmd(line)
+	params := teamtalk_get_params(line)
+	if cmd == "error" {
+		return cmd, params, errors.New(teamtalk_param_str(params, "message"))
+	}
+	return cmd, params, nil
+}
+
+func (fc *tt_file_conn) Write_cmd(cmd string) error {
+	fc.conn.SetWriteDeadline(time.Now().Add(FILE_TRANSFER_TIMEOUT))
+	_, err := fc.conn.Write([]byte(cmd + "\r\n"))
+	return err
+}
+
+// Opens the connection for a transfer, and starts it by sending command with the transfer ID.
+func (server *tt_server) File_transfer_connect(command string, accepted map[string]string) (*tt_file_conn, error) {
+	transferid, ok := teamtalk_param_int(accepted, "transferid")
+	if !ok {
+		return nil, errors.New("The server didn't give a transfer ID.")
+	}
+	server.Lock()
+	address := server.address
+	server.Unlock()
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	fc := &tt_file_conn{conn: conn, reader: bufio.NewReader(conn)}
+	cmd, _, err := fc.Read_cmd()
+	if err == nil && cmd != "teamtalk" {
+		err = errors.New("Unexpected reply from the server: " + cmd)
+	}
+	if err == nil {
+		err = fc.Write_cmd(teamtalk_format_cmd(command, "transferid", strconv.Itoa(transferid)))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return fc, nil
+}
+
+// Transfers data until it is all written, giving the transfer more time whenever data arrives.
+func file_copy(dst io.Writer, src io.Reader, conn net.Conn, size int64) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var written int64
+	for written < size {
+		conn.SetDeadline(time.Now().Add(FILE_TRANSFER_TIMEOUT))
+		limit := int64(len(buf))
+		if size-written < limit {
+			limit = size - written
+		}
+		n, err := src.Read(buf[:limit])
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+		}
+		if err != nil {
+			if err == io.EOF && written < size {
+				err = io.ErrUnexpectedEOF
+			}
+			if written == size {
+				err = nil
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Uploads the local file at path to ch.
+func (server *tt_server) File_upload(ch *tt_channel, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New(path + " is a directory.")
+	}
+	fname := filepath.Base(path)
+	size := int(info.Size())
+	if reason := server.File_upload_check(ch, fname, size); reason != "" {
+		return errors.New(reason)
+	}
+	accepted := server.cmd_send_file(ch.Id_read(), fname, size)
+	if accepted == nil {
+		return errors.New("The server didn't accept the transfer.")
+	}
+	fc, err := server.File_transfer_connect("sendfile", accepted)
+	if err != nil {
+		return err
+	}
+	defer fc.conn.Close()
+	if cmd, _, err := fc.Read_cmd(); err != nil {
+		return err
+	} else if cmd != "fileready" {
+		return errors.New("Unexpected reply from the server: " + cmd)
+	}
+	if _, err := file_copy(fc.conn, f, fc.conn, int64(size)); err != nil {
+		return err
+	}
+	if cmd, _, err := fc.Read_cmd(); err != nil {
+		return err
+	} else if cmd != "filecompleted" {
+		return errors.New("Unexpected reply from the server: " + cmd)
+	}
+	server.Log_write("Uploaded "+fname+" to "+ch.Path_read()+", "+size_str(size)+".", false)
+	return nil
+}
+
+// Downloads the file named fname in ch to dest, which may be a directory.
+// The file is written under a temporary name, and renamed once it is complete.
+func (server *tt_server) File_download(ch *tt_channel, fname, dest string) (string, error) {
+	if reason := server.File_download_check(); reason != "" {
+		return "", errors.New(reason)
+	}
+	if info, err := os.Stat(dest); dest == "" || (err == nil && info.IsDir()) {
+		dest = filepath.Join(dest, fname)
+	}
+	accepted := server.cmd_recv_file(ch.Id_read(), fname)
+	if accepted == nil {
+		return "", errors.New("The server didn't accept the transfer.")
+	}
+	fc, err := server.File_transfer_connect("recvfile", accepted)
+	if err != nil {
+		return "", err
+	}
+	defer fc.conn.Close()
+	cmd, params, err := fc.Read_cmd()
+	if err != nil {
+		return "", err
+	}
+	if cmd != "filedeliver" {
+		return "", errors.New("Unexpected reply from the server: " + cmd)
+	}
+	size, ok := teamtalk_param_int(params, "filesize")
+	if !ok {
+		size, _ = teamtalk_param_int(accepted, "filesize")
+	}
+	if err := dir_create(filepath.Dir(dest)); err != nil {
+		return "", err
+	}
+	tmpname := dest + ".part"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file_copy(f, fc.reader, fc.conn, int64(size)); err != nil {
+		f.Close()
+		os.Remove(tmpname)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpname)
+		return "", err
+	}
+	if err := os.Rename(tmpname, dest); err != nil {
+		os.Remove(tmpname)
+		return "", err
+	}
+	fc.Write_cmd("filecompleted")
+	server.Log_write("Downloaded "+fname+" from "+ch.Path_read()+" to "+dest+", "+size_str(size)+".", false)
+	return dest, nil
+}
+
+func (server *tt_server) File_delete(ch *tt_channel, fname string) bool {
+	f := ch.File_find_name(fname)
+	if f == nil {
+		console_write("The file " + fname + " doesn't exist in " + ch.Path_read() + ".")
+		return false
+	}
+	usr := server.User_find_id(server.Uid_read())
+	owner := usr != nil && usr.UserName_read() != "" && usr.UserName_read() == ch.File_owner(f)
+	if !owner && server.User_type_read() != TT_USERTYPE_ADMIN && !ch.Operator_check(server.Uid_read()) {
+		console_write("Insufficient permission to delete " + fname + ". Only its owner, channel operators and administrators can delete it.")
+		return false
+	}
+	return server.cmd_delete_file(ch.Id_read(), fname)
+}
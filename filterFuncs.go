@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Functions for filtering words and patterns out of messages, nicknames, and status messages.
+
+type tt_filter_rule struct {
+	Pattern     string `xml:",chardata"`
+	Regex       bool   `xml:"regex,attr,omitempty"`
+	Channel     string `xml:"channel,attr,omitempty"`
+	Action      string `xml:"action,attr"`
+	BanDuration string `xml:"banDuration,attr,omitempty"`
+	mu          sync.Mutex
+	re          *regexp.Regexp
+}
+
+func filter_rule_compile(pattern string, regex bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errors.New("Empty patterns aren't supported.")
+	}
+	if regex {
+		return regexp.Compile(pattern)
+	}
+	// Words match on their own, ignoring case, rather than inside other words.
+	expr := regexp.QuoteMeta(pattern)
+	if regexp.MustCompile(`^\w`).MatchString(pattern) {
+		expr = `\b` + expr
+	}
+	if regexp.MustCompile(`\w$`).MatchString(pattern) {
+		expr += `\b`
+	}
+	return regexp.Compile("(?i)" + expr)
+}
+
+func (rule *tt_filter_rule) Regexp() (*regexp.Regexp, error) {
+	defer rule.mu.Unlock()
+	rule.mu.Lock()
+	if rule.re != nil {
+		return rule.re, nil
+	}
+	re, err := filter_rule_compile(rule.Pattern, rule.Regex)
+	if err != nil {
+		return nil, err
+	}
+	rule.re = re
+	return re, nil
+}
+
+func (rule *tt_filter_rule) Action_read() int {
+	action, ok := action_parse(rule.Action)
+	if !ok {
+		return ACTION_LOG
+	}
+	return action
+}
+
+func (rule *tt_filter_rule) BanDuration_read() time.Duration {
+	d, _ := time_duration_parse(rule.BanDuration)
+	return d
+}
+
+// Rules for a channel also apply to its subchannels.
+func (rule *tt_filter_rule) Applies(chanpath string) bool {
+	if rule.Channel == "" {
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(chanpath), strings.ToLower(rule.Channel))
+}
+
+func (rule *tt_filter_rule) Match(text string) string {
+	re, err := rule.Regexp()
+	if err != nil {
+		return ""
+	}
+	return re.FindString(text)
+}
+
+func (rule *tt_filter_rule) Info_str() string {
+	str := "word "
+	if rule.Regex {
+		str = "regular expression "
+	}
+	str += rule.Pattern + ", action " + action_str(rule.Action_read())
+	if rule.Action_read() == ACTION_BAN {
+		if d := rule.BanDuration_read(); d > 0 {
+			str += " for " + time_duration_str(d)
+		} else {
+			str += " permanently"
+		}
+	}
+	if rule.Channel != "" {
+		str += ", in " + rule.Channel
+	} else {
+		str += ", server wide"
+	}
+	return str
+}
+
+func (server *tt_server) Filters_read() []*tt_filter_rule {
+	defer server.Unlock()
+	server.Lock()
+	filters := make([]*tt_filter_rule, len(server.Filters))
+	copy(filters, server.Filters)
+	return filters
+}
+
+func (server *tt_server) Filter_add(rule *tt_filter_rule) error {
+	if _, err := rule.Regexp(); err != nil {
+		return err
+	}
+	if rule.Channel != "" && !strings.HasSuffix(rule.Channel, "/") {
+		rule.Channel += "/"
+	}
+	defer server.Unlock()
+	server.Lock()
+	server.Filters = append(server.Filters, rule)
+	return nil
+}
+
+func (server *tt_server) Filter_remove(rule *tt_filter_rule) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, r := range server.Filters {
+		if r == rule {
+			server.Filters = append(server.Filters[:i], server.Filters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the rules which match text in the channel with the given path,
+// along with the text each rule matched.
+func (server *tt_server) Filter_matches(text, chanpath string) ([]*tt_filter_rule, []string) {
+	rules := []*tt_filter_rule{}
+	matches := []string{}
+	if text == "" {
+		return rules, matches
+	}
+	for _, rule := range server.Filters_read() {
+		if !rule.Applies(chanpath) {
+			continue
+		}
+		if match := rule.Match(text); match != "" {
+			rules = append(rules, rule)
+			matches = append(matches, match)
+		}
+	}
+	return rules, matches
+}
+
+// Acts against usr if text matches any filter rules, using the strongest action among them.
+func (server *tt_server) Filter_check(usr *tt_user, what, text, chanpath string) bool {
+	if server.Moderation_exempt(usr) {
+		return false
+	}
+	rules, matches := server.Filter_matches(text, chanpath)
+	if len(rules) == 0 {
+		return false
+	}
+	strongest := rules[0]
+	for _, rule := range rules[1:] {
+		if rule.Action_read() > strongest.Action_read() {
+			strongest = rule
+		}
+	}
+	reason := "Filtered content in " + what + ", matching " + strings.Join(matches, ", ")
+	server.Moderate(usr, strongest.Action_read(), reason, text, strongest.BanDuration_read())
+	return true
+}
+
+func (server *tt_server) Filter_message(msg_type int, usr *tt_user, ch *tt_channel, content string) {
+	if usr == nil {
+		return
+	}
+	what := ""
+	chanpath := ""
+	switch msg_type {
+	case TT_MSGTYPE_CHANNEL:
+		what = "a channel message"
+		if ch != nil {
+			chanpath = ch.Path_read()
+		}
+	case TT_MSGTYPE_USER:
+		what = "a private message"
+	case TT_MSGTYPE_BROADCAST:
+		what = "a broadcast message"
+	default:
+		return
+	}
+	if chanpath == "" {
+		if uch := usr.Channel_read(); uch != nil {
+			chanpath = uch.Path_read()
+		}
+	}
+	server.Filter_check(usr, what, content, chanpath)
+}
+
+func (server *tt_server) Filter_user(usr *tt_user, nickname, statusmsg bool) {
+	chanpath := ""
+	if ch := usr.Channel_read(); ch != nil {
+		chanpath = ch.Path_read()
+	}
+	if nickname && server.Filter_check(usr, "their nickname", usr.NickName_read(), chanpath) {
+		return
+	}
+	if statusmsg {
+		server.Filter_check(usr, "their status message", usr.StatusMsg_read(), chanpath)
+	}
+}
+
+func (server *tt_server) Filters_list() {
+	filters := server.Filters_read()
+	if len(filters) == 0 {
+		console_write("No filter rules have been added.")
+		return
+	}
+	msg := strconv.Itoa(len(filters)) + " filter rule"
+	if len(filters) != 1 {
+		msg += "s"
+	}
+	msg += ":\r\n"
+	for i, rule := range filters {
+		msg += strconv.Itoa(i+1) + ": " + rule.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
+
+func (server *tt_server) Filter_test(text, chanpath string) {
+	rules, matches := server.Filter_matches(text, chanpath)
+	if len(rules) == 0 {
+		console_write("No filter rules match.")
+		return
+	}
+	msg := ""
+	for i, rule := range rules {
+		msg += "Matched " + matches[i] + " with " + rule.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
+
+func filter_action_menu() (int, bool) {
+	actions := []int{ACTION_LOG, ACTION_WARN, ACTION_KICK_CHANNEL, ACTION_KICK_SERVER, ACTION_BAN}
+	menu := []string{}
+	for _, action := range actions {
+		menu = append(menu, action_str(action))
+	}
+	res, aborted := console_read_menu("Please select the action to take when the rule matches.\r\n", menu)
+	if aborted || res == -1 {
+		return ACTION_NONE, true
+	}
+	return actions[res], false
+}
+
+func (server *tt_server) Filter_add_prompt() bool {
+	res, aborted := console_read_menu("Please select the type of rule.\r\n", []string{"Word", "Regular expression"})
+	if aborted || res == -1 {
+		return false
+	}
+	rule := &tt_filter_rule{Regex: res == 1}
+	for {
+		val, err := console_read_prompt("Please enter the word or regular expression to filter.")
+		if err != nil {
+			return false
+		}
+		if _, err := filter_rule_compile(val, rule.Regex); err != nil {
+			console_write("Invalid pattern: " + err.Error())
+			continue
+		}
+		rule.Pattern = val
+		break
+	}
+	action, aborted := filter_action_menu()
+	if aborted {
+		return false
+	}
+	rule.Action = action_str(action)
+	if action == ACTION_BAN {
+		duration, aborted := ban_duration_read()
+		if aborted {
+			return false
+		}
+		if duration > 0 {
+			rule.BanDuration = duration.String()
+		}
+	}
+	val, err := console_read_prompt("Please enter the path of the channel the rule applies to, including its subchannels, or nothing for the entire server.")
+	if err != nil {
+		return false
+	}
+	rule.Channel = val
+	if err := server.Filter_add(rule); err != nil {
+		console_write("Failed to add the filter rule: " + err.Error())
+		return false
+	}
+	console_write("Added filter rule: " + rule.Info_str())
+	return true
+}
+
+func (server *tt_server) Filter_remove_prompt(val string) bool {
+	filters := server.Filters_read()
+	if len(filters) == 0 {
+		console_write("No filter rules have been added.")
+		return false
+	}
+	index := -1
+	if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= len(filters) {
+		index = n - 1
+	} else if val != "" {
+		for i, rule := range filters {
+			if rule.Pattern == val {
+				index = i
+				break
+			}
+		}
+	}
+	if index == -1 {
+		menu := []string{}
+		for _, rule := range filters {
+			menu = append(menu, rule.Info_str())
+		}
+		res, aborted := console_read_menu("Please select a filter rule to remove.\r\n", menu)
+		if aborted || res == -1 {
+			return false
+		}
+		index = res
+	}
+	rule := filters[index]
+	if !server.Filter_remove(rule) {
+		return false
+	}
+	console_write("Removed filter rule: " + rule.Info_str())
+	return true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strconv"
 	"strings"
 )
 
@@ -140,6 +141,19 @@ const (
 	TT_MSGTYPE_CUSTOM_STR    = "custom private"
 )
 
+// Ban types.
+const TT_BANTYPE_NONE = 0x00
+
+const (
+	TT_BANTYPE_NONE_STR     = "none"
+	TT_BANTYPE_CHANNEL      = 0x01
+	TT_BANTYPE_CHANNEL_STR  = "channel"
+	TT_BANTYPE_IPADDR       = 0x02
+	TT_BANTYPE_IPADDR_STR   = "IP address"
+	TT_BANTYPE_USERNAME     = 0x04
+	TT_BANTYPE_USERNAME_STR = "username"
+)
+
 // TeamTalk user status flags
 
 const (
@@ -354,6 +368,23 @@ func teamtalk_flags_status_mode_str(flags int) string {
 	return teamtalk_flags_fmt_str(str)
 }
 
+func teamtalk_flags_bantype_str(flags int) string {
+	str := ""
+	if teamtalk_flags_read(flags, TT_BANTYPE_NONE) {
+		return TT_BANTYPE_NONE_STR
+	}
+	if teamtalk_flags_read(flags, TT_BANTYPE_IPADDR) {
+		str += TT_BANTYPE_IPADDR_STR + ", "
+	}
+	if teamtalk_flags_read(flags, TT_BANTYPE_USERNAME) {
+		str += TT_BANTYPE_USERNAME_STR + ", "
+	}
+	if teamtalk_flags_read(flags, TT_BANTYPE_CHANNEL) {
+		str += TT_BANTYPE_CHANNEL_STR + ", "
+	}
+	return teamtalk_flags_fmt_str(str)
+}
+
 func teamtalk_flags_message_type_str(flag int) string {
 	str := ""
 	switch flag {
@@ -573,3 +604,135 @@ func teamtalk_flags_usertype_menu() (int, bool) {
 	}
 	return menu_flags[res], false
 }
+
+func teamtalk_flags_usertype_parse(str string) (int, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	switch str {
+	case TT_USERTYPE_NONE_STR:
+		return TT_USERTYPE_NONE, true
+	case TT_USERTYPE_DEFAULT_STR:
+		return TT_USERTYPE_DEFAULT, true
+	case TT_USERTYPE_ADMIN_STR:
+		return TT_USERTYPE_ADMIN, true
+	}
+	utype, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, false
+	}
+	return utype, true
+}
+
+func teamtalk_flags_userrights_parse(str string) (int, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "" || str == TT_USERRIGHT_NONE_STR {
+		return TT_USERRIGHT_NONE, true
+	}
+	if num, err := strconv.Atoi(str); err == nil {
+		return num, true
+	}
+	rights := map[string]int{
+		TT_USERRIGHT_MULTI_LOGIN_STR:               TT_USERRIGHT_MULTI_LOGIN,
+		TT_USERRIGHT_VIEW_ALL_USERS_STR:            TT_USERRIGHT_VIEW_ALL_USERS,
+		TT_USERRIGHT_CREATE_TEMPORARY_CHANNEL_STR:  TT_USERRIGHT_CREATE_TEMPORARY_CHANNEL,
+		TT_USERRIGHT_MODIFY_CHANNELS_STR:           TT_USERRIGHT_MODIFY_CHANNELS,
+		TT_USERRIGHT_TEXT_MESSAGE_BROADCAST_STR:    TT_USERRIGHT_TEXT_MESSAGE_BROADCAST,
+		TT_USERRIGHT_KICK_USERS_STR:                TT_USERRIGHT_KICK_USERS,
+		TT_USERRIGHT_BAN_USERS_STR:                 TT_USERRIGHT_BAN_USERS,
+		TT_USERRIGHT_MOVE_USERS_STR:                TT_USERRIGHT_MOVE_USERS,
+		TT_USERRIGHT_OPERATOR_ENABLE_STR:           TT_USERRIGHT_OPERATOR_ENABLE,
+		TT_USERRIGHT_UPLOAD_FILES_STR:              TT_USERRIGHT_UPLOAD_FILES,
+		TT_USERRIGHT_DOWNLOAD_FILES_STR:            TT_USERRIGHT_DOWNLOAD_FILES,
+		TT_USERRIGHT_UPDATE_SERVER_PROPERTIES_STR:  TT_USERRIGHT_UPDATE_SERVER_PROPERTIES,
+		TT_USERRIGHT_TRANSMIT_VOICE_STR:            TT_USERRIGHT_TRANSMIT_VOICE,
+		TT_USERRIGHT_TRANSMIT_VIDEO_CAPTURE_STR:    TT_USERRIGHT_TRANSMIT_VIDEO_CAPTURE,
+		TT_USERRIGHT_TRANSMIT_DESKTOP_STR:          TT_USERRIGHT_TRANSMIT_DESKTOP,
+		TT_USERRIGHT_TRANSMIT_DESKTOP_INPUT_STR:    TT_USERRIGHT_TRANSMIT_DESKTOP_INPUT,
+		TT_USERRIGHT_TRANSMIT_MEDIA_FILE_AUDIO_STR: TT_USERRIGHT_TRANSMIT_MEDIA_FILE_AUDIO,
+		TT_USERRIGHT_TRANSMIT_MEDIA_FILE_VIDEO_STR: TT_USERRIGHT_TRANSMIT_MEDIA_FILE_VIDEO,
+	}
+	flags := TT_USERRIGHT_NONE
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == TT_USERRIGHT_NONE_STR {
+			continue
+		}
+		flag, exists := rights[name]
+		if !exists {
+			return 0, false
+		}
+		flags = teamtalk_flags_set(flags, flag)
+	}
+	return flags, true
+}
+
+func teamtalk_flags_subscriptions_parse(str string) (int, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "" || str == TT_SUBSCRIBE_NONE_STR {
+		return TT_SUBSCRIBE_NONE, true
+	}
+	if num, err := strconv.Atoi(str); err == nil {
+		return num, true
+	}
+	subscriptions := map[string]int{
+		TT_SUBSCRIBE_USER_MSG_STR:                TT_SUBSCRIBE_USER_MSG,
+		TT_SUBSCRIBE_CHANNEL_MSG_STR:             TT_SUBSCRIBE_CHANNEL_MSG,
+		TT_SUBSCRIBE_BROADCAST_MSG_STR:           TT_SUBSCRIBE_BROADCAST_MSG,
+		TT_SUBSCRIBE_CUSTOM_MSG_STR:              TT_SUBSCRIBE_CUSTOM_MSG,
+		TT_SUBSCRIBE_VOICE_STR:                   TT_SUBSCRIBE_VOICE,
+		TT_SUBSCRIBE_VIDEO_CAPTURE_STR:           TT_SUBSCRIBE_VIDEO_CAPTURE,
+		TT_SUBSCRIBE_DESKTOP_STR:                 TT_SUBSCRIBE_DESKTOP,
+		TT_SUBSCRIBE_DESKTOP_INPUT_STR:           TT_SUBSCRIBE_DESKTOP_INPUT,
+		TT_SUBSCRIBE_MEDIA_FILE_STR:              TT_SUBSCRIBE_MEDIA_FILE,
+		TT_SUBSCRIBE_INTERCEPT_USER_MSG_STR:      TT_SUBSCRIBE_INTERCEPT_USER_MSG,
+		TT_SUBSCRIBE_INTERCEPT_CHANNEL_MSG_STR:   TT_SUBSCRIBE_INTERCEPT_CHANNEL_MSG,
+		TT_SUBSCRIBE_INTERCEPT_CUSTOM_MSG_STR:    TT_SUBSCRIBE_INTERCEPT_CUSTOM_MSG,
+		TT_SUBSCRIBE_INTERCEPT_VOICE_STR:         TT_SUBSCRIBE_INTERCEPT_VOICE,
+		TT_SUBSCRIBE_INTERCEPT_VIDEO_CAPTURE_STR: TT_SUBSCRIBE_INTERCEPT_VIDEO_CAPTURE,
+		TT_SUBSCRIBE_INTERCEPT_DESKTOP_STR:       TT_SUBSCRIBE_INTERCEPT_DESKTOP,
+		TT_SUBSCRIBE_INTERCEPT_MEDIA_FILE_STR:    TT_SUBSCRIBE_INTERCEPT_MEDIA_FILE,
+	}
+	flags := TT_SUBSCRIBE_NONE
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == TT_SUBSCRIBE_NONE_STR {
+			continue
+		}
+		flag, exists := subscriptions[name]
+		if !exists {
+			return 0, false
+		}
+		flags = teamtalk_flags_set(flags, flag)
+	}
+	return flags, true
+}
+
+func teamtalk_flags_channel_options_parse(str string) (int, bool) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "" || str == TT_CHANNEL_DEFAULT_STR {
+		return TT_CHANNEL_DEFAULT, true
+	}
+	if num, err := strconv.Atoi(str); err == nil {
+		return num, true
+	}
+	options := map[string]int{
+		TT_CHANNEL_PERMANENT_STR:           TT_CHANNEL_PERMANENT,
+		TT_CHANNEL_SOLO_TRANSMIT_STR:       TT_CHANNEL_SOLO_TRANSMIT,
+		TT_CHANNEL_CLASSROOM_STR:           TT_CHANNEL_CLASSROOM,
+		TT_CHANNEL_OPERATOR_RECV_ONLY_STR:  TT_CHANNEL_OPERATOR_RECV_ONLY,
+		TT_CHANNEL_NO_VOICE_ACTIVATION_STR: TT_CHANNEL_NO_VOICE_ACTIVATION,
+		TT_CHANNEL_NO_RECORDING_STR:        TT_CHANNEL_NO_RECORDING,
+	}
+	flags := TT_CHANNEL_DEFAULT
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == TT_CHANNEL_DEFAULT_STR {
+			continue
+		}
+		flag, exists := options[name]
+		if !exists {
+			return 0, false
+		}
+		flags = teamtalk_flags_set(flags, flag)
+	}
+	return flags, true
+}
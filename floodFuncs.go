@@ -0,0 +1,214 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for protecting a server from users flooding it with messages.
+
+type tt_flood_config struct {
+	Enabled           bool   `xml:"enabled,attr"`
+	Messages          int    `xml:"messages,omitempty"`
+	Interval          string `xml:"interval,omitempty"`
+	Repeats           int    `xml:"repeats,omitempty"`
+	MaxLength         int    `xml:"maxLength,omitempty"`
+	Broadcasts        int    `xml:"broadcasts,omitempty"`
+	BroadcastInterval string `xml:"broadcastInterval,omitempty"`
+	Actions           string `xml:"actions,omitempty"`
+	ResetAfter        string `xml:"resetAfter,omitempty"`
+	BanDuration       string `xml:"banDuration,omitempty"`
+}
+
+type tt_flood_user struct {
+	messages   []time.Time
+	broadcasts []time.Time
+	last       string
+	repeats    int
+}
+
+type tt_flood_level struct {
+	level int
+	last  time.Time
+}
+
+func flood_config_default() tt_flood_config {
+	return tt_flood_config{
+		Messages:          5,
+		Interval:          "10s",
+		Repeats:           3,
+		Broadcasts:        2,
+		BroadcastInterval: "1m",
+		Actions:           strings.Join([]string{ACTION_WARN_STR, ACTION_KICK_CHANNEL_STR, ACTION_KICK_SERVER_STR, ACTION_BAN_STR}, ", "),
+		ResetAfter:        "30m",
+		BanDuration:       "1h",
+	}
+}
+
+func duration_or(str string, def time.Duration) time.Duration {
+	if d, ok := time_duration_parse(str); ok {
+		return d
+	}
+	return def
+}
+
+func (conf tt_flood_config) Interval_read() time.Duration {
+	return duration_or(conf.Interval, 10*time.Second)
+}
+
+func (conf tt_flood_config) BroadcastInterval_read() time.Duration {
+	return duration_or(conf.BroadcastInterval, time.Minute)
+}
+
+func (conf tt_flood_config) ResetAfter_read() time.Duration {
+	return duration_or(conf.ResetAfter, 30*time.Minute)
+}
+
+func (conf tt_flood_config) BanDuration_read() time.Duration {
+	return duration_or(conf.BanDuration, time.Hour)
+}
+
+func (conf tt_flood_config) Actions_read() []int {
+	actions, ok := actions_parse(conf.Actions)
+	if !ok || len(actions) == 0 {
+		return []int{ACTION_WARN}
+	}
+	return actions
+}
+
+func (conf tt_flood_config) Info_str() string {
+	if !conf.Enabled {
+		return "Flood protection is disabled."
+	}
+	str := "Flood protection is enabled.\r\n"
+	if conf.Messages > 0 {
+		str += "Maximum messages: " + strconv.Itoa(conf.Messages) + " within " + time_duration_str(conf.Interval_read()) + "\r\n"
+	} else {
+		str += "Maximum messages: unlimited\r\n"
+	}
+	if conf.Repeats > 0 {
+		str += "Maximum identical messages in a row: " + strconv.Itoa(conf.Repeats) + "\r\n"
+	} else {
+		str += "Maximum identical messages in a row: unlimited\r\n"
+	}
+	if conf.MaxLength > 0 {
+		str += "Maximum message length: " + strconv.Itoa(conf.MaxLength) + " characters\r\n"
+	} else {
+		str += "Maximum message length: unlimited\r\n"
+	}
+	if conf.Broadcasts > 0 {
+		str += "Maximum broadcast messages: " + strconv.Itoa(conf.Broadcasts) + " within " + time_duration_str(conf.BroadcastInterval_read()) + "\r\n"
+	} else {
+		str += "Maximum broadcast messages: unlimited\r\n"
+	}
+	str += "Actions, from first to repeated violations: " + actions_str(conf.Actions_read()) + "\r\n"
+	str += "Violations are forgotten after: " + time_duration_str(conf.ResetAfter_read()) + "\r\n"
+	str += "Ban duration: " + time_duration_str(conf.BanDuration_read())
+	return str
+}
+
+func (server *tt_server) Flood_read() tt_flood_config {
+	defer server.Unlock()
+	server.Lock()
+	if server.Flood == nil {
+		return flood_config_default()
+	}
+	return *server.Flood
+}
+
+func (server *tt_server) Flood_set(conf tt_flood_config) {
+	defer server.Unlock()
+	server.Lock()
+	server.Flood = &conf
+}
+
+func (server *tt_server) Flood_forget(uid int) {
+	defer server.Unlock()
+	server.Lock()
+	delete(server.flood_users, uid)
+}
+
+func flood_prune(times []time.Time, since time.Time) []time.Time {
+	pruned := []time.Time{}
+	for _, t := range times {
+		if t.After(since) {
+			pruned = append(pruned, t)
+		}
+	}
+	return pruned
+}
+
+// Records a message from usr, and returns the reason if the message breaks a flood rule.
+func (server *tt_server) Flood_check(msg_type int, usr *tt_user, content string) (string, bool) {
+	if msg_type == TT_MSGTYPE_CUSTOM || server.Moderation_exempt(usr) {
+		return "", false
+	}
+	conf := server.Flood_read()
+	if !conf.Enabled {
+		return "", false
+	}
+	now := time.Now()
+	uid := usr.Uid_read()
+	defer server.Unlock()
+	server.Lock()
+	if server.flood_users == nil {
+		server.flood_users = make(map[int]*tt_flood_user)
+	}
+	fu, exists := server.flood_users[uid]
+	if !exists {
+		fu = &tt_flood_user{}
+		server.flood_users[uid] = fu
+	}
+	fu.messages = append(flood_prune(fu.messages, now.Add(-conf.Interval_read())), now)
+	if msg_type == TT_MSGTYPE_BROADCAST {
+		fu.broadcasts = append(flood_prune(fu.broadcasts, now.Add(-conf.BroadcastInterval_read())), now)
+	}
+	if content == fu.last {
+		fu.repeats++
+	} else {
+		fu.last = content
+		fu.repeats = 1
+	}
+	reason := ""
+	switch {
+	case conf.MaxLength > 0 && len([]rune(content)) > conf.MaxLength:
+		reason = "Message too long, over " + strconv.Itoa(conf.MaxLength) + " characters"
+	case conf.Broadcasts > 0 && len(fu.broadcasts) > conf.Broadcasts:
+		reason = "Too many broadcast messages, more than " + strconv.Itoa(conf.Broadcasts) + " within " + time_duration_str(conf.BroadcastInterval_read())
+		fu.broadcasts = nil
+	case conf.Repeats > 0 && fu.repeats > conf.Repeats:
+		reason = "Repeated message, sent more than " + strconv.Itoa(conf.Repeats) + " times in a row"
+		fu.repeats = 0
+	case conf.Messages > 0 && len(fu.messages) > conf.Messages:
+		reason = "Too many messages, more than " + strconv.Itoa(conf.Messages) + " within " + time_duration_str(conf.Interval_read())
+		fu.messages = nil
+	}
+	return reason, reason != ""
+}
+
+// Escalates the action taken against usr each time they break a flood rule,
+// until they have behaved for long enough to be forgiven.
+func (server *tt_server) Flood_violation(usr *tt_user, reason, content string) {
+	conf := server.Flood_read()
+	actions := conf.Actions_read()
+	key := kick_key(usr)
+	now := time.Now()
+	server.Lock()
+	if server.flood_levels == nil {
+		server.flood_levels = make(map[string]*tt_flood_level)
+	}
+	fl, exists := server.flood_levels[key]
+	if !exists || now.Sub(fl.last) > conf.ResetAfter_read() {
+		fl = &tt_flood_level{}
+		server.flood_levels[key] = fl
+	}
+	level := fl.level
+	if level >= len(actions) {
+		level = len(actions) - 1
+	}
+	fl.level++
+	fl.last = now
+	server.Unlock()
+	go server.Moderate(usr, actions[level], reason, content, conf.BanDuration_read())
+}
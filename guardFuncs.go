@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Functions for guarding channels, by locking their properties and restoring them when anyone else changes them.
+
+var guard_properties = []string{"topic", "password", "oppassword", "maxusers", "options", "diskquota"}
+
+type tt_channel_lock struct {
+	Path       string  `xml:"path,attr"`
+	Topic      *string `xml:"topic"`
+	Password   *string `xml:"password"`
+	OpPassword *string `xml:"oppassword"`
+	MaxUsers   *int    `xml:"maxusers"`
+	Options    *int    `xml:"options"`
+	DiskQuota  *int    `xml:"diskquota"`
+}
+
+func (lock *tt_channel_lock) Empty() bool {
+	return lock.Topic == nil && lock.Password == nil && lock.OpPassword == nil && lock.MaxUsers == nil && lock.Options == nil && lock.DiskQuota == nil
+}
+
+// Locks a property of the channel to value, or to its current value in ch if value is nil.
+func (lock *tt_channel_lock) Property_lock(name string, ch *tt_channel, value *string) error {
+	str := ""
+	if value != nil {
+		str = *value
+	}
+	num := 0
+	switch name {
+	case "maxusers", "options", "diskquota":
+		if value != nil {
+			n, err := strconv.Atoi(str)
+			if err != nil || n < 0 {
+				return errors.New(str + " isn't a valid value for " + name + ".")
+			}
+			num = n
+		}
+	}
+	if value == nil && ch == nil {
+		return errors.New("Unable to lock the current value of " + name + ". The channel doesn't exist.")
+	}
+	switch name {
+	case "topic":
+		if value == nil {
+			str = ch.Topic_read()
+		}
+		lock.Topic = &str
+	case "password":
+		if value == nil {
+			str = ch.Password_read()
+		}
+		lock.Password = &str
+	case "oppassword":
+		if value == nil {
+			str = ch.Oppassword_read()
+		}
+		lock.OpPassword = &str
+	case "maxusers":
+		if value == nil {
+			num = ch.Maxusers_read()
+		}
+		lock.MaxUsers = &num
+	case "options":
+		if value == nil {
+			num = ch.Options_read()
+		}
+		lock.Options = &num
+	case "diskquota":
+		if value == nil {
+			num = ch.Quota_read()
+		}
+		lock.DiskQuota = &num
+	default:
+		return errors.New("Unrecognized property: " + name + ". The properties are " + strings.Join(guard_properties, ", ") + ".")
+	}
+	return nil
+}
+
+func (lock *tt_channel_lock) Property_unlock(name string) error {
+	switch name {
+	case "topic":
+		lock.Topic = nil
+	case "password":
+		lock.Password = nil
+	case "oppassword":
+		lock.OpPassword = nil
+	case "maxusers":
+		lock.MaxUsers = nil
+	case "options":
+		lock.Options = nil
+	case "diskquota":
+		lock.DiskQuota = nil
+	default:
+		return errors.New("Unrecognized property: " + name + ". The properties are " + strings.Join(guard_properties, ", ") + ".")
+	}
+	return nil
+}
+
+// Returns the updatechannel parameters needed to restore the locked properties of ch,
+// and a description of each property which was changed.
+func (lock *tt_channel_lock) Violations(ch *tt_channel) ([]string, []string) {
+	params := []string{}
+	changes := []string{}
+	if lock.Topic != nil && ch.Topic_read() != *lock.Topic {
+		params = append(params, "topic", *lock.Topic)
+		changes = append(changes, "topic changed to "+ch.Topic_read())
+	}
+	if lock.Password != nil && ch.Password_read() != *lock.Password {
+		params = append(params, "password", *lock.Password)
+		changes = append(changes, "password changed to "+ch.Password_read())
+	}
+	if lock.OpPassword != nil && ch.Oppassword_read() != *lock.OpPassword {
+		params = append(params, "oppassword", *lock.OpPassword)
+		changes = append(changes, "operator password changed to "+ch.Oppassword_read())
+	}
+	if lock.MaxUsers != nil && ch.Maxusers_read() != *lock.MaxUsers {
+		params = append(params, "maxusers", strconv.Itoa(*lock.MaxUsers))
+		changes = append(changes, "maximum users changed to "+strconv.Itoa(ch.Maxusers_read()))
+	}
+	if lock.Options != nil && ch.Options_read() != *lock.Options {
+		params = append(params, "type", strconv.Itoa(*lock.Options))
+		changes = append(changes, "options changed to "+ch.Options_read_str())
+	}
+	if lock.DiskQuota != nil && ch.Quota_read() != *lock.DiskQuota {
+		params = append(params, "diskquota", strconv.Itoa(*lock.DiskQuota))
+		changes = append(changes, "disk quota changed to "+ch.Quota_read_str())
+	}
+	return params, changes
+}
+
+func (lock *tt_channel_lock) Info_str() string {
+	locked := []string{}
+	if lock.Topic != nil {
+		locked = append(locked, "topic "+*lock.Topic)
+	}
+	if lock.Password != nil {
+		locked = append(locked, "password "+*lock.Password)
+	}
+	if lock.OpPassword != nil {
+		locked = append(locked, "operator password "+*lock.OpPassword)
+	}
+	if lock.MaxUsers != nil {
+		locked = append(locked, "maximum users "+strconv.Itoa(*lock.MaxUsers))
+	}
+	if lock.Options != nil {
+		options := teamtalk_flags_channel_options_str(*lock.Options)
+		if options == "" {
+			options = "none"
+		}
+		locked = append(locked, "options "+options)
+	}
+	if lock.DiskQuota != nil {
+		locked = append(locked, "disk quota "+strconv.Itoa(*lock.DiskQuota)+" bytes")
+	}
+	return lock.Path + ": " + strings.Join(locked, ", ")
+}
+
+func (server *tt_server) Guards_read() []tt_channel_lock {
+	defer server.Unlock()
+	server.Lock()
+	locks := []tt_channel_lock{}
+	for _, lock := range server.ChannelLocks {
+		locks = append(locks, *lock)
+	}
+	return locks
+}
+
+func (server *tt_server) Guard_find(path string) (tt_channel_lock, bool) {
+	path = strings.ToLower(path)
+	for _, lock := range server.Guards_read() {
+		if strings.ToLower(lock.Path) == path {
+			return lock, true
+		}
+	}
+	return tt_channel_lock{}, false
+}
+
+// Adds or replaces the lock for a channel, removing it if nothing is locked.
+func (server *tt_server) Guard_set(lock tt_channel_lock) {
+	defer server.Unlock()
+	server.Lock()
+	path := strings.ToLower(lock.Path)
+	for i, l := range server.ChannelLocks {
+		if strings.ToLower(l.Path) != path {
+			continue
+		}
+		if lock.Empty() {
+			server.ChannelLocks = append(server.ChannelLocks[:i], server.ChannelLocks[i+1:]...)
+		} else {
+			server.ChannelLocks[i] = &lock
+		}
+		return
+	}
+	if !lock.Empty() {
+		server.ChannelLocks = append(server.ChannelLocks, &lock)
+	}
+}
+
+// Returns the users who could have changed ch, those in the channel first,
+// as the server doesn't say who changed a channel.
+func (server *tt_server) Guard_suspects(ch *tt_channel) []string {
+	accounts := server.Accounts_read()
+	inside := []string{}
+	outside := []string{}
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		reasons := []string{}
+		if usr.UserType_read() == TT_USERTYPE_ADMIN {
+			reasons = append(reasons, "administrator")
+		} else if account, exists := accounts[usr.UserName_read()]; exists {
+			if rights, ok := teamtalk_flags_userrights_parse(account["rights"]); ok && teamtalk_flags_read(rights, TT_USERRIGHT_MODIFY_CHANNELS) {
+				reasons = append(reasons, "may modify channels")
+			}
+		}
+		if ch.Operator_check(usr.Uid_read()) {
+			reasons = append(reasons, "channel operator")
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		if usr.Channel_read() == ch {
+			inside = append(inside, usr.NickName_log()+" ("+strings.Join(append(reasons, "in the channel"), ", ")+")")
+			continue
+		}
+		outside = append(outside, usr.NickName_log()+" ("+strings.Join(reasons, ", ")+")")
+	}
+	return append(inside, outside...)
+}
+
+// Restores the locked properties of ch if anyone has changed them.
+func (server *tt_server) Guard_check(ch *tt_channel) bool {
+	path := ch.Path_read()
+	lock, exists := server.Guard_find(path)
+	if !exists {
+		return false
+	}
+	params, changes := lock.Violations(ch)
+	if len(params) == 0 {
+		return false
+	}
+	msg := "Locked properties of " + path + " have been changed: " + strings.Join(changes, ", ") + ".\r\n"
+	if suspects := server.Guard_suspects(ch); len(suspects) != 0 {
+		msg += "Likely responsible: " + strings.Join(suspects, ", ") + ".\r\n"
+	} else {
+		msg += "Unable to tell who is responsible.\r\n"
+	}
+	if !server.User_rights_check(TT_USERRIGHT_MODIFY_CHANNELS) && !ch.Operator_check(server.Uid_read()) {
+		server.Log_write(msg+"Unable to restore them. Insufficient permission.", true)
+		return false
+	}
+	if !server.cmd_update_channel(ch.Id_read(), params...) {
+		server.Log_write(msg+"Failed to restore them.", true)
+		return false
+	}
+	server.Log_write(msg+"They have been restored.", true)
+	return true
+}
+
+func (server *tt_server) Guard_check_all() int {
+	count := 0
+	for _, lock := range server.Guards_read() {
+		ch := server.Channel_find_path_exact(lock.Path)
+		if ch == nil {
+			server.Log_write("Unable to guard "+lock.Path+". The channel doesn't exist.", true)
+			continue
+		}
+		if server.Guard_check(ch) {
+			count++
+		}
+	}
+	return count
+}
+
+func (server *tt_server) Guards_list() {
+	locks := server.Guards_read()
+	if len(locks) == 0 {
+		console_write("No channels are guarded.")
+		return
+	}
+	msg := strconv.Itoa(len(locks)) + " guarded channel"
+	if len(locks) != 1 {
+		msg += "s"
+	}
+	msg += ":\r\n"
+	for _, lock := range locks {
+		msg += lock.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Functions for monitoring the health of the connection to a server, using the latency of keepalive pings.
+
+const (
+	HEALTH_SAMPLES      = 120
+	HEALTH_SPIKE_FACTOR = 3
+	HEALTH_SPIKE_MIN    = 250 * time.Millisecond
+	HEALTH_SPIKE_AFTER  = 10
+	HEALTH_PONG_TIMEOUT = 5 * time.Second
+	HEALTH_RECOVER      = 3
+)
+
+type tt_health struct {
+	connected   time.Time
+	connects    int
+	samples     []time.Duration
+	latency     time.Duration
+	ping_start  time.Time
+	pinging     bool
+	ping_missed bool
+	missed      int
+	degraded    time.Time
+	reason      string
+	good        int
+	lost_reason string
+	lost        time.Time
+}
+
+// Returns the pth percentile of the latency samples.
+func latency_percentile(samples []time.Duration, p int) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func latency_str(latency time.Duration) string {
+	return strconv.FormatInt(int64(latency/time.Millisecond), 10) + " ms"
+}
+
+func (server *tt_server) Health_read() tt_health {
+	defer server.Unlock()
+	server.Lock()
+	health := server.health
+	health.samples = make([]time.Duration, len(server.health.samples))
+	copy(health.samples, server.health.samples)
+	return health
+}
+
+// Records a new connection.
+func (server *tt_server) Health_connected() {
+	defer server.Unlock()
+	server.Lock()
+	server.health.connected = time.Now()
+	server.health.connects++
+	server.health.pinging = false
+	server.health.ping_missed = false
+	server.health.degraded = time.Time{}
+	server.health.good = 0
+}
+
+// Records why the connection ended, if it hasn't already been recorded.
+func (server *tt_server) Health_disconnect(reason string) {
+	defer server.Unlock()
+	server.Lock()
+	if server.health.connected.IsZero() {
+		return
+	}
+	server.health.connected = time.Time{}
+	server.health.lost = time.Now()
+	server.health.lost_reason = reason
+	server.health.pinging = false
+}
+
+// Sends a keepalive ping, so its latency can be measured when the pong arrives.
+func (server *tt_server) Health_ping() bool {
+	server.Lock()
+	server.health.ping_start = time.Now()
+	server.health.pinging = true
+	server.health.ping_missed = false
+	server.Unlock()
+	res := server.cmd_ping()
+	server.Lock()
+	server.health.pinging = false
+	server.Unlock()
+	return res
+}
+
+func (server *tt_server) health_degraded(reason string) {
+	server.Lock()
+	server.health.good = 0
+	degraded := server.health.degraded.IsZero()
+	if degraded {
+		server.health.degraded = time.Now()
+		server.health.reason = reason
+	}
+	server.Unlock()
+	if degraded {
+		server.Log_write("Connection degraded: "+reason, true)
+	}
+}
+
+// Records the latency of a pong, measured from when the ping was sent.
+func (server *tt_server) Health_pong() {
+	now := time.Now()
+	server.Lock()
+	sent := server.cmd_written
+	if !server.health.pinging || sent.Before(server.health.ping_start) {
+		server.Unlock()
+		return
+	}
+	latency := now.Sub(sent)
+	median := latency_percentile(server.health.samples, 50)
+	spike := len(server.health.samples) >= HEALTH_SPIKE_AFTER && latency > HEALTH_SPIKE_MIN && latency > median*HEALTH_SPIKE_FACTOR
+	server.health.latency = latency
+	server.health.samples = append(server.health.samples, latency)
+	if len(server.health.samples) > HEALTH_SAMPLES {
+		server.health.samples = server.health.samples[len(server.health.samples)-HEALTH_SAMPLES:]
+	}
+	recovered := time.Duration(0)
+	if !spike && !server.health.degraded.IsZero() {
+		server.health.good++
+		if server.health.good >= HEALTH_RECOVER {
+			recovered = now.Sub(server.health.degraded)
+			server.health.degraded = time.Time{}
+			server.health.reason = ""
+			server.health.good = 0
+		}
+	}
+	server.Unlock()
+	if spike {
+		server.health_degraded("latency of " + latency_str(latency) + ", compared to a median of " + latency_str(median) + ".")
+	} else if recovered != 0 {
+		server.Log_write("Connection recovered after "+time_duration_str(recovered.Round(time.Second))+".", false)
+	}
+}
+
+// Checks for a keepalive ping which hasn't had a reply, called regularly while connected.
+func (server *tt_server) Health_check() {
+	server.Lock()
+	sent := server.cmd_written
+	waiting := time.Since(sent)
+	missed := server.health.pinging && !server.health.ping_missed && !sent.Before(server.health.ping_start) && waiting > HEALTH_PONG_TIMEOUT
+	if missed {
+		server.health.ping_missed = true
+		server.health.missed++
+	}
+	server.Unlock()
+	if missed {
+		server.health_degraded("no reply to a keepalive ping after " + time_duration_str(waiting.Round(time.Second)) + ".")
+	}
+}
+
+func (server *tt_server) Health_str() string {
+	health := server.Health_read()
+	str := "Health of " + server.DisplayName_read() + ":\r\n"
+	if !health.connected.IsZero() {
+		str += "Connected for " + time_duration_str(time.Since(health.connected).Round(time.Second)) + ".\r\n"
+	} else {
+		str += "Not connected.\r\n"
+	}
+	if len(health.samples) == 0 {
+		str += "No latency has been measured yet.\r\n"
+	} else {
+		str += "Latency: " + latency_str(health.latency) + ", median " + latency_str(latency_percentile(health.samples, 50)) + ", 95th percentile " + latency_str(latency_percentile(health.samples, 95)) + ", highest " + latency_str(latency_percentile(health.samples, 100)) + ", over the last " + strconv.Itoa(len(health.samples)) + " keepalive pings.\r\n"
+	}
+	if !health.degraded.IsZero() {
+		str += "Degraded for " + time_duration_str(time.Since(health.degraded).Round(time.Second)) + ": " + health.reason + "\r\n"
+	} else if !health.connected.IsZero() {
+		str += "The connection is healthy.\r\n"
+	}
+	str += "Missed pongs: " + strconv.Itoa(health.missed) + "\r\n"
+	reconnects := health.connects - 1
+	if reconnects < 0 {
+		reconnects = 0
+	}
+	str += "Reconnects: " + strconv.Itoa(reconnects)
+	if !health.lost.IsZero() {
+		str += "\r\nLast disconnected " + time_duration_str(time.Since(health.lost).Round(time.Second)) + " ago: " + health.lost_reason
+	}
+	return str
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Functions for kicking users and tracking how often they have been kicked.
+
+const (
+	KICKBAN_WINDOW_DEFAULT   = time.Hour
+	KICKBAN_DURATION_DEFAULT = time.Hour
+)
+
+func (server *tt_server) KickBan_read() (int, time.Duration, time.Duration) {
+	defer server.Unlock()
+	server.Lock()
+	window, ok := time_duration_parse(server.KickBanWindow)
+	if !ok {
+		window = KICKBAN_WINDOW_DEFAULT
+	}
+	duration, ok := time_duration_parse(server.KickBanDuration)
+	if !ok {
+		duration = KICKBAN_DURATION_DEFAULT
+	}
+	return server.KickBanThreshold, window, duration
+}
+
+func (server *tt_server) KickBan_set(threshold int, window, duration time.Duration) {
+	defer server.Unlock()
+	server.Lock()
+	server.KickBanThreshold = threshold
+	if threshold <= 0 {
+		server.KickBanThreshold = 0
+		server.KickBanWindow = ""
+		server.KickBanDuration = ""
+		return
+	}
+	server.KickBanWindow = window.String()
+	server.KickBanDuration = duration.String()
+}
+
+func (server *tt_server) KickBan_read_str() string {
+	threshold, window, duration := server.KickBan_read()
+	if threshold <= 0 {
+		return "Kicked users aren't banned automatically."
+	}
+	str := "Users kicked " + strconv.Itoa(threshold) + " time"
+	if threshold != 1 {
+		str += "s"
+	}
+	return str + " within " + time_duration_str(window) + " are banned for " + time_duration_str(duration) + "."
+}
+
+// Users are tracked by username, or by IP address if they haven't logged in with an account.
+func kick_key(usr *tt_user) string {
+	if username := usr.UserName_read(); username != "" {
+		return username
+	}
+	return usr.Ip_read()
+}
+
+func (server *tt_server) Kicks_count(usr *tt_user) int {
+	_, window, _ := server.KickBan_read()
+	key := kick_key(usr)
+	since := time.Now().Add(-window)
+	defer server.Unlock()
+	server.Lock()
+	kicks := []time.Time{}
+	for _, t := range server.kicks[key] {
+		if t.After(since) {
+			kicks = append(kicks, t)
+		}
+	}
+	if len(kicks) == 0 {
+		delete(server.kicks, key)
+		return 0
+	}
+	server.kicks[key] = kicks
+	return len(kicks)
+}
+
+func (server *tt_server) Kicks_add(usr *tt_user) int {
+	count := server.Kicks_count(usr)
+	key := kick_key(usr)
+	defer server.Unlock()
+	server.Lock()
+	if server.kicks == nil {
+		server.kicks = make(map[string][]time.Time)
+	}
+	server.kicks[key] = append(server.kicks[key], time.Now())
+	return count + 1
+}
+
+func (server *tt_server) Kicks_reset(usr *tt_user) {
+	key := kick_key(usr)
+	defer server.Unlock()
+	server.Lock()
+	delete(server.kicks, key)
+}
+
+func (server *tt_server) Kicks_read() map[string]int {
+	_, window, _ := server.KickBan_read()
+	since := time.Now().Add(-window)
+	defer server.Unlock()
+	server.Lock()
+	counts := make(map[string]int)
+	for key, kicks := range server.kicks {
+		for _, t := range kicks {
+			if t.After(since) {
+				counts[key]++
+			}
+		}
+	}
+	return counts
+}
+
+func (server *tt_server) Kicks_list() {
+	counts := server.Kicks_read()
+	if len(counts) == 0 {
+		console_write("No users have been kicked recently.")
+		return
+	}
+	keys := []string{}
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	_, window, _ := server.KickBan_read()
+	msg := "Kicks within the last " + time_duration_str(window) + ":\r\n"
+	for _, key := range keys {
+		msg += key + ": " + strconv.Itoa(counts[key]) + "\r\n"
+	}
+	console_write(msg + server.KickBan_read_str())
+}
+
+func (server *tt_server) Kick_check(ch *tt_channel) bool {
+	if server.User_rights_check(TT_USERRIGHT_KICK_USERS) {
+		return true
+	}
+	return ch != nil && ch.Operator_check(server.Uid_read())
+}
+
+// Kicks usr from ch, or from the server if ch is nil.
+// Once a user has been kicked often enough, they are also banned for a while.
+func (server *tt_server) Kick_user(usr *tt_user, ch *tt_channel, reason string) bool {
+	if !server.Kick_check(ch) {
+		server.Log_write("Unable to kick "+usr.NickName_log()+". Insufficient permission.", true)
+		return false
+	}
+	cid := 0
+	where := "the server"
+	if ch != nil {
+		cid = ch.Id_read()
+		where = ch.Path_read()
+	}
+	lnickname := usr.NickName_log()
+	username := usr.UserName_read()
+	threshold, window, duration := server.KickBan_read()
+	count := server.Kicks_count(usr) + 1
+	banned := false
+	if threshold > 0 && count >= threshold && server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		banreason := "kicked " + strconv.Itoa(count) + " times within " + time_duration_str(window)
+		banned = server.Ban_user(usr, ban_type_default(usr), ch, duration, banreason)
+	}
+	if !server.cmd_kick(usr.Uid_read(), cid) {
+		return false
+	}
+	if banned {
+		server.Kicks_reset(usr)
+	} else {
+		server.Kicks_add(usr)
+	}
+	msg := lnickname + " has been kicked from " + where
+	if reason != "" {
+		msg += ". Reason: " + reason
+	}
+	msg += ".\r\nKicks within the last " + time_duration_str(window) + ": " + strconv.Itoa(count) + "."
+	server.Log_username_set(username)
+	server.Log_write(msg, false)
+	return true
+}
+
+func (server *tt_server) Kick_user_prompt(usrval, scope, reason string) bool {
+	usr, aborted := server_menu_user(server, usrval)
+	if aborted {
+		return false
+	}
+	if usr == nil {
+		console_write("No user selected. Aborted.")
+		return false
+	}
+	if usr.Uid_read() == server.Uid_read() {
+		console_write("Kicking the bot isn't supported.")
+		return false
+	}
+	var ch *tt_channel
+	switch scope {
+	case "server":
+	case "channel":
+		ch = usr.Channel_read()
+		if ch == nil {
+			console_write(usr.NickName_log() + " isn't in a channel.")
+			return false
+		}
+	default:
+		ch, aborted = server_menu_user_scope(usr, "kick")
+		if aborted {
+			return false
+		}
+	}
+	if !server.Kick_check(ch) {
+		if ch == nil {
+			console_write("You don't have permission to kick users from the server.")
+		} else {
+			console_write("You don't have permission to kick users from " + ch.Path_read() + ".")
+		}
+		return false
+	}
+	return server.Kick_user(usr, ch, reason)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Functions for describing the channels of a server in a file, and changing the server to match it.
+// Properties left out of the file are left as they are on the server.
+
+const (
+	LAYOUT_CREATE = iota
+	LAYOUT_UPDATE
+	LAYOUT_REMOVE
+)
+
+type tt_channel_layout struct {
+	XMLName        xml.Name             `xml:"channels"`
+	RemoveUnlisted bool                 `xml:"removeUnlisted,attr,omitempty"`
+	Channels       []*tt_layout_channel `xml:"channel"`
+}
+
+type tt_layout_channel struct {
+	Path       string  `xml:"path,attr"`
+	Topic      *string `xml:"topic"`
+	Password   *string `xml:"password"`
+	OpPassword *string `xml:"oppassword"`
+	MaxUsers   *string `xml:"maxusers"`
+	DiskQuota  *string `xml:"diskquota"`
+	Options    *string `xml:"options"`
+	AudioCodec *string `xml:"audiocodec"`
+	AudioCfg   *string `xml:"audiocfg"`
+}
+
+type tt_layout_action struct {
+	action  int
+	path    string
+	params  []string
+	changes []string
+}
+
+func (server *tt_server) Layout_path() string {
+	return server.State_path() + "channels.xml"
+}
+
+// Returns the properties given for the channel as protocol parameters.
+// Topics and passwords are used exactly as given, since spaces may be part of them.
+func (lc *tt_layout_channel) Params() ([]string, error) {
+	values := map[string]*string{
+		"topic":      lc.Topic,
+		"password":   lc.Password,
+		"oppassword": lc.OpPassword,
+		"maxusers":   lc.MaxUsers,
+		"diskquota":  lc.DiskQuota,
+		"options":    lc.Options,
+		"audiocodec": lc.AudioCodec,
+		"audiocfg":   lc.AudioCfg,
+	}
+	params := []string{}
+	for _, name := range channel_properties[1:] {
+		if values[name] == nil {
+			continue
+		}
+		value := *values[name]
+		if name != "topic" && name != "password" && name != "oppassword" {
+			value = strings.TrimSpace(value)
+		}
+		key, value, err := channel_property_param(name, value)
+		if err != nil {
+			return nil, errors.New(lc.Path + ": " + err.Error())
+		}
+		params = append(params, key, value)
+	}
+	return params, nil
+}
+
+// Returns the current value of a channel property as a protocol parameter value.
+func (ch *tt_channel) Param_read(key string) string {
+	switch key {
+	case "topic":
+		return ch.Topic_read()
+	case "password":
+		return ch.Password_read()
+	case "oppassword":
+		return ch.Oppassword_read()
+	case "maxusers":
+		return strconv.Itoa(ch.Maxusers_read())
+	case "diskquota":
+		return strconv.Itoa(ch.Quota_read())
+	case "type":
+		return strconv.Itoa(ch.Options_read())
+	case "audiocodec":
+		return teamtalk_format_list(ch.Audiocodec_read())
+	case "audiocfg":
+		return teamtalk_format_list(ch.Audiocfg_read())
+	}
+	return ""
+}
+
+func layout_param_str(key, value string) string {
+	switch key {
+	case "type":
+		flags, _ := strconv.Atoi(value)
+		return "options " + teamtalk_flags_channel_options_str(flags)
+	case "diskquota":
+		return "disk quota " + value + " bytes"
+	case "maxusers":
+		return "maximum users " + value
+	case "oppassword":
+		return "operator password " + value
+	case "audiocodec":
+		return "audio codec " + value
+	case "audiocfg":
+		return "audio configuration " + value
+	}
+	return key + " " + value
+}
+
+func (a *tt_layout_action) Info_str() string {
+	str := ""
+	switch a.action {
+	case LAYOUT_CREATE:
+		str = "Create " + a.path
+	case LAYOUT_UPDATE:
+		str = "Update " + a.path
+	case LAYOUT_REMOVE:
+		str = "Remove " + a.path
+	}
+	if len(a.changes) != 0 {
+		str += ": " + strings.Join(a.changes, ", ")
+	}
+	return str
+}
+
+func layout_read(fname string) (*tt_channel_layout, error) {
+	layout := &tt_channel_layout{}
+	if err := state_read(fname, layout); err != nil {
+		return nil, err
+	}
+	if len(layout.Channels) == 0 {
+		return nil, errors.New("No channels are described in " + fname + ".")
+	}
+	return layout, nil
+}
+
+// Describes the current channels of the server, so they can be recreated later.
+func (server *tt_server) Layout_export() *tt_channel_layout {
+	layout := &tt_channel_layout{}
+	for _, ch := range server.Channels_sort() {
+		lc := &tt_layout_channel{Path: ch.Path_read()}
+		topic := ch.Topic_read()
+		password := ch.Password_read()
+		oppassword := ch.Oppassword_read()
+		maxusers := strconv.Itoa(ch.Maxusers_read())
+		diskquota := strconv.Itoa(ch.Quota_read())
+		options := ch.Options_read_str()
+		audiocodec := strings.Trim(teamtalk_format_list(ch.Audiocodec_read()), "[]")
+		audiocfg := strings.Trim(teamtalk_format_list(ch.Audiocfg_read()), "[]")
+		lc.Topic = &topic
+		lc.Password = &password
+		lc.OpPassword = &oppassword
+		lc.MaxUsers = &maxusers
+		lc.DiskQuota = &diskquota
+		lc.Options = &options
+		lc.AudioCodec = &audiocodec
+		lc.AudioCfg = &audiocfg
+		layout.Channels = append(layout.Channels, lc)
+	}
+	sort.Slice(layout.Channels, func(i, j int) bool {
+		return layout.Channels[i].Path < layout.Channels[j].Path
+	})
+	return layout
+}
+
+// Compares the layout with the channels of the server, and returns the actions needed to make them match.
+// Parent channels are created before their subchannels.
+// Channels which aren't listed are only removed if the layout asks for it, and take their subchannels with them.
+func (server *tt_server) Layout_plan(layout *tt_channel_layout) ([]*tt_layout_action, error) {
+	wanted := make(map[string][]string)
+	paths := []string{}
+	for _, lc := range layout.Channels {
+		path := channel_path_clean(lc.Path)
+		lc.Path = path
+		params, err := lc.Params()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := wanted[strings.ToLower(path)]; exists {
+			return nil, errors.New(path + " is described more than once.")
+		}
+		wanted[strings.ToLower(path)] = params
+		paths = append(paths, path)
+		// Parent channels which aren't described are created with default properties.
+		for parent := path_parent(path); parent != ""; parent = path_parent(parent) {
+			if _, exists := wanted[strings.ToLower(parent)]; !exists {
+				wanted[strings.ToLower(parent)] = []string{}
+				paths = append(paths, parent)
+			}
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") < strings.Count(paths[j], "/") || (strings.Count(paths[i], "/") == strings.Count(paths[j], "/") && paths[i] < paths[j])
+	})
+	actions := []*tt_layout_action{}
+	for _, path := range paths {
+		params := wanted[strings.ToLower(path)]
+		ch := server.Channel_find_path_exact(path)
+		if ch == nil {
+			a := &tt_layout_action{action: LAYOUT_CREATE, path: path, params: params}
+			for i := 0; i < len(params)-1; i += 2 {
+				a.changes = append(a.changes, layout_param_str(params[i], params[i+1]))
+			}
+			actions = append(actions, a)
+			continue
+		}
+		a := &tt_layout_action{action: LAYOUT_UPDATE, path: ch.Path_read()}
+		for i := 0; i < len(params)-1; i += 2 {
+			if ch.Param_read(params[i]) == params[i+1] {
+				continue
+			}
+			a.params = append(a.params, params[i], params[i+1])
+			a.changes = append(a.changes, layout_param_str(params[i], params[i+1]))
+		}
+		if len(a.params) != 0 {
+			actions = append(actions, a)
+		}
+	}
+	if !layout.RemoveUnlisted {
+		return actions, nil
+	}
+	removed := []string{}
+	for _, ch := range server.Channels_sort() {
+		path := ch.Path_read()
+		if ch.Idparent_read() == 0 {
+			continue
+		}
+		if _, exists := wanted[strings.ToLower(path)]; exists {
+			continue
+		}
+		removed = append(removed, path)
+	}
+	sort.Strings(removed)
+	last := ""
+	for _, path := range removed {
+		if last != "" && strings.HasPrefix(strings.ToLower(path), strings.ToLower(last)) {
+			continue
+		}
+		last = path
+		a := &tt_layout_action{action: LAYOUT_REMOVE, path: path}
+		if ch := server.Channel_find_path_exact(path); ch != nil {
+			if users := len(ch.Users_read()); users != 0 {
+				a.changes = append(a.changes, strconv.Itoa(users)+" user(s) in it")
+			}
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// Returns the path of the parent of a channel, or an empty string for the root channel.
+func path_parent(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return ""
+	}
+	return path[:strings.LastIndex(path, "/")+1]
+}
+
+func path_name(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// Carries out the actions, returning how many were successful.
+func (server *tt_server) Layout_apply(actions []*tt_layout_action) int {
+	count := 0
+	for _, a := range actions {
+		ok := false
+		switch a.action {
+		case LAYOUT_CREATE:
+			parent := server.Channel_find_path_exact(path_parent(a.path))
+			if parent == nil {
+				console_write("Unable to create " + a.path + ". The parent channel doesn't exist.")
+				continue
+			}
+			ok = server.Channel_create(parent, path_name(a.path), a.params)
+		case LAYOUT_UPDATE:
+			ch := server.Channel_find_path_exact(a.path)
+			if ch == nil {
+				console_write("Unable to update " + a.path + ". The channel doesn't exist.")
+				continue
+			}
+			ok = server.Channel_update(ch, a.params)
+		case LAYOUT_REMOVE:
+			ch := server.Channel_find_path_exact(a.path)
+			if ch == nil {
+				continue
+			}
+			ok = server.Channel_delete(ch)
+		}
+		if ok {
+			count++
+			server.Log_write("Channel layout: "+a.Info_str(), false)
+		}
+	}
+	return count
+}
+
+func layout_plan_str(actions []*tt_layout_action) string {
+	if len(actions) == 0 {
+		return "The channels already match the layout."
+	}
+	msg := strconv.Itoa(len(actions)) + " change"
+	if len(actions) != 1 {
+		msg += "s"
+	}
+	msg += " needed:\r\n"
+	for _, a := range actions {
+		msg += a.Info_str() + "\r\n"
+	}
+	return msg
+}
@@ -3,7 +3,10 @@ package main
 // Compares two maps of type map[string]map[string]string
 // and returns added, changed, removed
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 func mapCompare(first, second map[string]map[string]string) (map[string]map[string]string, map[string]map[string]string, map[string]map[string]string) {
 	defer func() {
@@ -41,3 +44,12 @@ outerloop:
 	}
 	return added, changed, removed
 }
+
+func mapKeys_sorted(m map[string]map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
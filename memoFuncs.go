@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for memos, which are messages left for a username and delivered the next time they log in.
+
+const (
+	MEMO_LIMIT_DEFAULT  = 5
+	MEMO_EXPIRY_DEFAULT = 30 * 24 * time.Hour
+	MEMO_CONSOLE        = "console"
+)
+
+type tt_memo_config struct {
+	Disabled bool   `xml:"disabled,attr,omitempty"`
+	Limit    int    `xml:"limit,omitempty"`
+	Expiry   string `xml:"expiry,omitempty"`
+}
+
+type tt_memo struct {
+	From      string    `xml:"from,attr"`
+	FromNick  string    `xml:"fromNickname,attr,omitempty"`
+	To        string    `xml:"to,attr"`
+	Message   string    `xml:"message"`
+	Created   time.Time `xml:"created"`
+	Expires   time.Time `xml:"expires"`
+	Delivered bool      `xml:"delivered,attr,omitempty"`
+}
+
+type tt_memos struct {
+	XMLName xml.Name   `xml:"memos"`
+	Memos   []*tt_memo `xml:"memo"`
+}
+
+func (conf tt_memo_config) Limit_read() int {
+	if conf.Limit <= 0 {
+		return MEMO_LIMIT_DEFAULT
+	}
+	return conf.Limit
+}
+
+func (conf tt_memo_config) Expiry_read() time.Duration {
+	return duration_or(conf.Expiry, MEMO_EXPIRY_DEFAULT)
+}
+
+func (conf tt_memo_config) Info_str() string {
+	if conf.Disabled {
+		return "Memos are disabled."
+	}
+	str := "Memos are enabled.\r\n"
+	str += "Pending memos allowed per sender: " + strconv.Itoa(conf.Limit_read()) + "\r\n"
+	str += "Memos expire after " + time_duration_str(conf.Expiry_read())
+	return str
+}
+
+func (memo *tt_memo) From_str() string {
+	if memo.FromNick != "" && memo.FromNick != memo.From {
+		return memo.FromNick + " (" + memo.From + ")"
+	}
+	return memo.From
+}
+
+func (memo *tt_memo) Info_str() string {
+	return "From " + memo.From_str() + " to " + memo.To + ", " + time_duration_str(time.Since(memo.Created).Round(time.Minute)) + " ago: " + memo.Message
+}
+
+func (server *tt_server) MemoConfig_read() tt_memo_config {
+	defer server.Unlock()
+	server.Lock()
+	if server.MemoConfig == nil {
+		return tt_memo_config{}
+	}
+	return *server.MemoConfig
+}
+
+func (server *tt_server) MemoConfig_set(conf tt_memo_config) {
+	defer server.Unlock()
+	server.Lock()
+	server.MemoConfig = &conf
+}
+
+func (server *tt_server) Memos_path() string {
+	path := server.State_path()
+	if path == "" {
+		return ""
+	}
+	return path + "memos.xml"
+}
+
+// Loads the memo file the first time memos are needed.
+// The memo lock is held until the memos are loaded, so nothing sees or changes the list before then.
+func (server *tt_server) Memos_load() {
+	defer server.memos_lock.Unlock()
+	server.memos_lock.Lock()
+	server.Lock()
+	loaded := server.memos_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	fname := server.Memos_path()
+	memos := &tt_memos{}
+	err := state_read(fname, memos)
+	server.Lock()
+	server.memos_loaded = true
+	server.memos_failed = err != nil
+	if err == nil {
+		server.memos = append(memos.Memos, server.memos...)
+	}
+	server.Unlock()
+	if err != nil {
+		server.Log_write("Error reading memos from "+fname+": "+err.Error()+". The file won't be changed until the bot is restarted.", true)
+	}
+}
+
+// Writes the memos to the memo file, unless it couldn't be read, so it isn't overwritten.
+func (server *tt_server) Memos_write() bool {
+	server.Memos_load()
+	defer server.memos_lock.Unlock()
+	server.memos_lock.Lock()
+	server.Lock()
+	failed := server.memos_failed
+	memos := &tt_memos{}
+	for _, m := range server.memos {
+		memo := *m
+		memos.Memos = append(memos.Memos, &memo)
+	}
+	server.Unlock()
+	fname := server.Memos_path()
+	if fname == "" || failed {
+		return false
+	}
+	if err := state_write(fname, memos); err != nil {
+		server.Log_write("Error writing memos to "+fname+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// Returns the memos which haven't been delivered or expired, removing those which have.
+func (server *tt_server) Memos_pending() []*tt_memo {
+	server.Memos_load()
+	now := time.Now()
+	server.Lock()
+	pending := []*tt_memo{}
+	for _, memo := range server.memos {
+		if !memo.Delivered && now.Before(memo.Expires) {
+			pending = append(pending, memo)
+		}
+	}
+	changed := len(pending) != len(server.memos)
+	server.memos = pending
+	server.Unlock()
+	if changed {
+		server.Memos_write()
+	}
+	result := make([]*tt_memo, len(pending))
+	copy(result, pending)
+	return result
+}
+
+// Leaves a memo for username from the sender, checking the limit of pending memos for the sender.
+func (server *tt_server) Memo_add(from, fromnick, to, message string) (*tt_memo, error) {
+	conf := server.MemoConfig_read()
+	if conf.Disabled {
+		return nil, errors.New("Memos are disabled.")
+	}
+	to = strings.TrimSpace(to)
+	message = strings.TrimSpace(message)
+	if to == "" || message == "" {
+		return nil, errors.New("A memo needs a username and a message.")
+	}
+	if server.User_type_read() == TT_USERTYPE_ADMIN {
+		if _, exists := server.Accounts_read()[to]; !exists {
+			return nil, errors.New("No user account named " + to + " exists.")
+		}
+	}
+	count := 0
+	for _, memo := range server.Memos_pending() {
+		if memo.From == from {
+			count++
+		}
+	}
+	if from != MEMO_CONSOLE && count >= conf.Limit_read() {
+		return nil, errors.New("You already have " + strconv.Itoa(count) + " memos waiting to be delivered, which is the most allowed.")
+	}
+	now := time.Now()
+	memo := &tt_memo{
+		From:     from,
+		FromNick: fromnick,
+		To:       to,
+		Message:  message,
+		Created:  now,
+		Expires:  now.Add(conf.Expiry_read()),
+	}
+	server.Lock()
+	server.memos = append(server.memos, memo)
+	server.Unlock()
+	server.Memos_write()
+	return memo, nil
+}
+
+func (server *tt_server) Memo_remove(memo *tt_memo) bool {
+	server.Memos_load()
+	server.Lock()
+	found := false
+	for i, m := range server.memos {
+		if m == memo {
+			server.memos = append(server.memos[:i], server.memos[i+1:]...)
+			found = true
+			break
+		}
+	}
+	server.Unlock()
+	if found {
+		server.Memos_write()
+	}
+	return found
+}
+
+// Delivers the memos waiting for usr, and marks them delivered.
+func (server *tt_server) Memos_deliver(usr *tt_user) int {
+	username := usr.UserName_read()
+	if username == "" || server.MemoConfig_read().Disabled {
+		return 0
+	}
+	count := 0
+	for _, memo := range server.Memos_pending() {
+		if strings.ToLower(memo.To) != strings.ToLower(username) {
+			continue
+		}
+		msg := "Memo from " + memo.From_str() + ", left " + time_duration_str(time.Since(memo.Created).Round(time.Minute)) + " ago: " + memo.Message
+		if !server.cmd_message_user(usr.Uid_read(), msg) {
+			break
+		}
+		server.Lock()
+		memo.Delivered = true
+		server.Unlock()
+		count++
+		server.Log_username_set(username)
+		server.Log_write("Delivered a memo from "+memo.From_str()+" to "+usr.NickName_log()+".", false)
+	}
+	if count != 0 {
+		server.Memos_pending()
+	}
+	return count
+}
+
+// Leaves a memo from usr, delivering it straight away if the recipient is logged in. Returns the reply for usr.
+func (server *tt_server) Memo_tell(usr *tt_user, param string) string {
+	fields := strings.SplitN(strings.TrimSpace(param), " ", 2)
+	if len(fields) != 2 {
+		return "Usage: tell <username> <message>"
+	}
+	from := MEMO_CONSOLE
+	fromnick := ""
+	if usr != nil {
+		from = usr.UserName_read()
+		fromnick = usr.NickName_read()
+		if from == "" {
+			return "You need to be logged in with a user account to leave memos."
+		}
+	}
+	memo, err := server.Memo_add(from, fromnick, fields[0], fields[1])
+	if err != nil {
+		return err.Error()
+	}
+	reply := "Your memo for " + memo.To + " will be delivered the next time they log in."
+	for _, u := range server.Users_sort(server.Uid_read()) {
+		if strings.ToLower(u.UserName_read()) == strings.ToLower(memo.To) {
+			server.Memos_deliver(u)
+			reply = memo.To + " is logged in, so your memo has been delivered."
+			break
+		}
+	}
+	return reply
+}
+
+func (server *tt_server) Memos_list() {
+	pending := server.Memos_pending()
+	if len(pending) == 0 {
+		console_write("No memos are waiting to be delivered.")
+		return
+	}
+	msg := strconv.Itoa(len(pending)) + " memo"
+	if len(pending) != 1 {
+		msg += "s"
+	}
+	msg += " waiting to be delivered:\r\n"
+	for i, memo := range pending {
+		msg += strconv.Itoa(i+1) + ": " + memo.Info_str() + "\r\n"
+	}
+	console_write(msg)
+}
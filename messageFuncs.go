@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+)
+
+// Functions for sending a message to wherever a feature has been told to, such as a schedule or an alert.
+
+// Sends a message as a broadcast rather than to a channel or user.
+const MESSAGE_BROADCAST = "broadcast"
+
+// Sends msg to a channel path, as a broadcast, or to every user logged in with a username.
+// Returns the reason the message couldn't be sent, or an empty string.
+func (server *tt_server) Message_send(to, msg string) string {
+	switch {
+	case strings.ToLower(to) == MESSAGE_BROADCAST:
+		if !server.User_rights_check(TT_USERRIGHT_TEXT_MESSAGE_BROADCAST) {
+			return "insufficient permission to broadcast"
+		}
+		if !server.cmd_message_broadcast(msg) {
+			return "the broadcast failed"
+		}
+	case strings.HasPrefix(to, "/"):
+		ch := server.Channel_find_path_exact(to)
+		if ch == nil {
+			return to + " doesn't exist"
+		}
+		if !ch.User_exists(server.Uid_read()) && server.User_type_read() != TT_USERTYPE_ADMIN {
+			return "the bot isn't in " + to
+		}
+		if !server.cmd_message_channel(ch.Id_read(), msg) {
+			return "the message failed"
+		}
+	default:
+		sent := false
+		for _, usr := range server.Users_sort(server.Uid_read()) {
+			if strings.ToLower(usr.UserName_read()) == strings.ToLower(to) {
+				sent = server.cmd_message_user(usr.Uid_read(), msg) || sent
+			}
+		}
+		if !sent {
+			return to + " isn't logged in"
+		}
+	}
+	return ""
+}
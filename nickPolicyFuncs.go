@@ -0,0 +1,188 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for enforcing the nickname policy of a server.
+
+const NICKPOLICY_GRACE_DEFAULT = time.Minute
+
+type tt_nick_policy struct {
+	Enabled         bool   `xml:"enabled,attr"`
+	Required        bool   `xml:"required,omitempty"`
+	Allowed         string `xml:"allowed,omitempty"`
+	MaxLength       int    `xml:"maxLength,omitempty"`
+	NoImpersonation bool   `xml:"noImpersonation,omitempty"`
+	NoDuplicates    bool   `xml:"noDuplicates,omitempty"`
+	GracePeriod     string `xml:"gracePeriod,omitempty"`
+}
+
+func (policy tt_nick_policy) GracePeriod_read() time.Duration {
+	return duration_or(policy.GracePeriod, NICKPOLICY_GRACE_DEFAULT)
+}
+
+func (policy tt_nick_policy) Info_str() string {
+	if !policy.Enabled {
+		return "The nickname policy is disabled."
+	}
+	str := "The nickname policy is enabled.\r\n"
+	str += "Nickname required: " + str_yes_no(policy.Required) + "\r\n"
+	if policy.Allowed != "" {
+		str += "Allowed nicknames: " + policy.Allowed + "\r\n"
+	} else {
+		str += "Allowed nicknames: any\r\n"
+	}
+	if policy.MaxLength > 0 {
+		str += "Maximum length: " + strconv.Itoa(policy.MaxLength) + " characters\r\n"
+	} else {
+		str += "Maximum length: unlimited\r\n"
+	}
+	str += "Impersonating administrators forbidden: " + str_yes_no(policy.NoImpersonation) + "\r\n"
+	str += "Duplicate nicknames forbidden: " + str_yes_no(policy.NoDuplicates) + "\r\n"
+	str += "Grace period before kicking: " + time_duration_str(policy.GracePeriod_read())
+	return str
+}
+
+func (server *tt_server) NickPolicy_read() tt_nick_policy {
+	defer server.Unlock()
+	server.Lock()
+	if server.NickPolicy == nil {
+		return tt_nick_policy{}
+	}
+	return *server.NickPolicy
+}
+
+func (server *tt_server) NickPolicy_set(policy tt_nick_policy) {
+	defer server.Unlock()
+	server.Lock()
+	server.NickPolicy = &policy
+}
+
+// Reports whether name belongs to an administrator,
+// either a known administrator account or an administrator who is logged in.
+func (server *tt_server) Admin_name_check(name string) bool {
+	name = strings.ToLower(name)
+	for username, account := range server.Accounts_read() {
+		if account["usertype"] == TT_USERTYPE_ADMIN_STR && strings.ToLower(username) == name {
+			return true
+		}
+	}
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		if usr.UserType_read() != TT_USERTYPE_ADMIN {
+			continue
+		}
+		if strings.ToLower(usr.UserName_read()) == name || strings.ToLower(usr.NickName_read()) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the reason the nickname of usr breaks the policy, or an empty string.
+func (server *tt_server) NickPolicy_violation(policy tt_nick_policy, usr *tt_user) string {
+	nickname := usr.NickName_read()
+	if nickname == "" {
+		if policy.Required {
+			return "a nickname is required"
+		}
+		return ""
+	}
+	if policy.MaxLength > 0 && len([]rune(nickname)) > policy.MaxLength {
+		return "nicknames may be at most " + strconv.Itoa(policy.MaxLength) + " characters long"
+	}
+	if policy.Allowed != "" {
+		re, err := regexp.Compile("^(?:" + policy.Allowed + ")$")
+		if err == nil && !re.MatchString(nickname) {
+			return "nicknames must match " + policy.Allowed
+		}
+	}
+	if policy.NoImpersonation && usr.UserType_read() != TT_USERTYPE_ADMIN && server.Admin_name_check(nickname) {
+		return "nicknames may not impersonate administrators"
+	}
+	if policy.NoDuplicates {
+		uid := usr.Uid_read()
+		for _, other := range server.Users_sort(uid) {
+			// The user who logged in first keeps the nickname.
+			if other.Uid_read() < uid && strings.ToLower(other.NickName_read()) == strings.ToLower(nickname) {
+				return "another user is already using this nickname"
+			}
+		}
+	}
+	return ""
+}
+
+func (server *tt_server) NickPolicy_forget(uid int) {
+	defer server.Unlock()
+	server.Lock()
+	if timer, exists := server.nick_timers[uid]; exists {
+		timer.Stop()
+		delete(server.nick_timers, uid)
+	}
+}
+
+// Warns usr if their nickname breaks the policy, and kicks them if they haven't changed it once the grace period is over.
+func (server *tt_server) NickPolicy_check(usr *tt_user) {
+	policy := server.NickPolicy_read()
+	if !policy.Enabled || server.Moderation_exempt(usr) {
+		return
+	}
+	uid := usr.Uid_read()
+	reason := server.NickPolicy_violation(policy, usr)
+	grace := policy.GracePeriod_read()
+	// The timer is checked and started under one lock, so updates arriving together can't both warn.
+	server.Lock()
+	timer, pending := server.nick_timers[uid]
+	if reason == "" && pending {
+		timer.Stop()
+		delete(server.nick_timers, uid)
+	}
+	if reason != "" && !pending {
+		if server.nick_timers == nil {
+			server.nick_timers = make(map[int]*time.Timer)
+		}
+		server.nick_timers[uid] = time.AfterFunc(grace, func() {
+			server.NickPolicy_forget(uid)
+			if server.User_find_id(uid) != usr {
+				return
+			}
+			reason := server.NickPolicy_violation(server.NickPolicy_read(), usr)
+			if reason == "" {
+				return
+			}
+			server.Moderate(usr, ACTION_KICK_SERVER, "Nickname policy, "+reason, usr.NickName_read(), 0)
+		})
+	}
+	server.Unlock()
+	if reason == "" {
+		if pending {
+			server.Log_username_set(usr.UserName_read())
+			server.Log_write(usr.NickName_log()+" now meets the nickname policy.", false)
+		}
+		return
+	}
+	if pending {
+		return
+	}
+	server.Log_username_set(usr.UserName_read())
+	server.Log_write(usr.NickName_log()+" doesn't meet the nickname policy: "+reason+". They have been warned, and will be kicked in "+time_duration_str(grace)+" unless they change their nickname.", false)
+	server.cmd_message_user(uid, "Your nickname isn't allowed on this server: "+reason+". Please change your nickname within "+time_duration_str(grace)+", or you will be kicked.")
+}
+
+func (server *tt_server) NickPolicy_check_all() int {
+	policy := server.NickPolicy_read()
+	count := 0
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		if server.Moderation_exempt(usr) {
+			continue
+		}
+		if server.NickPolicy_violation(policy, usr) != "" {
+			count++
+		}
+		server.NickPolicy_check(usr)
+	}
+	return count
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for relaying channel messages between channels on different servers.
+// The bot never relays its own messages, and skips any message matching one it recently relayed, so relays can't loop.
+
+const RELAY_ECHO = 30 * time.Second
+
+type tt_relay_end struct {
+	Server  string `xml:"server,attr"`
+	Channel string `xml:"path,attr"`
+}
+
+type tt_relay struct {
+	Notices bool           `xml:"notices,attr,omitempty"`
+	Ends    []tt_relay_end `xml:"channel"`
+}
+
+func (end tt_relay_end) Str() string {
+	return end.Channel + " on " + end.Server
+}
+
+func (end tt_relay_end) Equal(other tt_relay_end) bool {
+	return strings.ToLower(end.Str()) == strings.ToLower(other.Str())
+}
+
+func (end tt_relay_end) Match(server *tt_server, path string) bool {
+	return strings.ToLower(end.Server) == strings.ToLower(server.DisplayName_read()) && strings.ToLower(end.Channel) == strings.ToLower(path)
+}
+
+func (relay *tt_relay) Str() string {
+	if len(relay.Ends) != 2 {
+		return "Invalid relay."
+	}
+	str := relay.Ends[0].Str() + " and " + relay.Ends[1].Str()
+	if relay.Notices {
+		str += ", with join and leave notices"
+	}
+	return str
+}
+
+// Returns the other end of the relay when server and path are one end of it.
+func (relay *tt_relay) Other(server *tt_server, path string) (tt_relay_end, bool) {
+	if len(relay.Ends) != 2 {
+		return tt_relay_end{}, false
+	}
+	for i, end := range relay.Ends {
+		if end.Match(server, path) {
+			return relay.Ends[1-i], true
+		}
+	}
+	return tt_relay_end{}, false
+}
+
+func (conf *config) Relays_read() []*tt_relay {
+	defer conf.Unlock()
+	conf.Lock()
+	relays := make([]*tt_relay, len(conf.Relays))
+	copy(relays, conf.Relays)
+	return relays
+}
+
+func (conf *config) Relay_add(relay *tt_relay) error {
+	if len(relay.Ends) != 2 {
+		return errors.New("A relay links exactly two channels.")
+	}
+	for i := range relay.Ends {
+		servers := conf.Server_find_name(relay.Ends[i].Server)
+		if len(servers) == 0 {
+			return errors.New("The server " + relay.Ends[i].Server + " doesn't exist.")
+		}
+		relay.Ends[i].Server = servers[0].DisplayName_read()
+		relay.Ends[i].Channel = channel_path_clean(relay.Ends[i].Channel)
+	}
+	if relay.Ends[0].Equal(relay.Ends[1]) {
+		return errors.New("A channel can't be relayed to itself.")
+	}
+	for _, r := range conf.Relays_read() {
+		if len(r.Ends) != 2 {
+			continue
+		}
+		if (r.Ends[0].Equal(relay.Ends[0]) && r.Ends[1].Equal(relay.Ends[1])) || (r.Ends[0].Equal(relay.Ends[1]) && r.Ends[1].Equal(relay.Ends[0])) {
+			return errors.New("These channels are already relayed.")
+		}
+	}
+	conf.Lock()
+	conf.Relays = append(conf.Relays, relay)
+	conf.Unlock()
+	return nil
+}
+
+func (conf *config) Relay_remove(relay *tt_relay) bool {
+	defer conf.Unlock()
+	conf.Lock()
+	for i, r := range conf.Relays {
+		if r == relay {
+			conf.Relays = append(conf.Relays[:i], conf.Relays[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (conf *config) Relay_notices_set(relay *tt_relay, notices bool) {
+	defer conf.Unlock()
+	conf.Lock()
+	relay.Notices = notices
+}
+
+// Returns true if msg was relayed recently, which means it has come back, such as through another bot.
+func (conf *config) Relay_echo(msg string) bool {
+	now := time.Now()
+	defer conf.Unlock()
+	conf.Lock()
+	for m, t := range conf.relay_sent {
+		if now.Sub(t) > RELAY_ECHO {
+			delete(conf.relay_sent, m)
+		}
+	}
+	_, exists := conf.relay_sent[msg]
+	return exists
+}
+
+func (conf *config) Relay_sent_add(msg string) {
+	defer conf.Unlock()
+	conf.Lock()
+	if conf.relay_sent == nil {
+		conf.relay_sent = make(map[string]time.Time)
+	}
+	conf.relay_sent[msg] = time.Now()
+}
+
+// Sends msg to the other end of every relay which includes the channel at path on server.
+func (server *tt_server) Relay_send(path, msg string, notice bool) {
+	for _, relay := range server.Config().Relays_read() {
+		other, linked := relay.Other(server, path)
+		if !linked || (notice && !relay.Notices) {
+			continue
+		}
+		servers := server.Config().Server_find_name(other.Server)
+		if len(servers) == 0 {
+			continue
+		}
+		dest := servers[0]
+		if !dest.connected() || !dest.Logged_in_read() {
+			server.Log_debug("Unable to relay a message to " + other.Str() + ": not connected.")
+			continue
+		}
+		server.Config().Relay_sent_add(msg)
+		if reason := dest.Message_send(other.Channel, msg); reason != "" {
+			server.Log_debug("Unable to relay a message to " + other.Str() + ": " + reason + ".")
+		}
+	}
+}
+
+// A message waiting to be relayed.
+type tt_relay_queued struct {
+	path   string
+	msg    string
+	notice bool
+}
+
+// Queues msg to be relayed, so messages are relayed in the order they arrived without holding up the server.
+func (server *tt_server) Relay_queue(path, msg string, notice bool) {
+	defer server.Unlock()
+	server.Lock()
+	server.relay_queue = append(server.relay_queue, tt_relay_queued{path, msg, notice})
+	if !server.relay_draining {
+		server.relay_draining = true
+		go server.Relay_drain()
+	}
+}
+
+// Relays the queued messages one at a time, until the queue is empty.
+func (server *tt_server) Relay_drain() {
+	for {
+		server.Lock()
+		if len(server.relay_queue) == 0 {
+			server.relay_draining = false
+			server.Unlock()
+			return
+		}
+		queued := server.relay_queue[0]
+		server.relay_queue = server.relay_queue[1:]
+		server.Unlock()
+		server.Relay_send(queued.path, queued.msg, queued.notice)
+	}
+}
+
+// Relays a channel message sent by usr.
+func (server *tt_server) Relay_message(usr *tt_user, ch *tt_channel, content string) {
+	if usr == nil || ch == nil || usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	if len(server.Config().Relays_read()) == 0 || server.Config().Relay_echo(content) {
+		return
+	}
+	msg := "[" + server.DisplayName_read() + "] " + usr.NickName_read() + ": " + content
+	server.Relay_queue(ch.Path_read(), msg, false)
+}
+
+// Relays a notice that usr has joined or left a channel.
+func (server *tt_server) Relay_notice(usr *tt_user, ch *tt_channel, joined bool) {
+	if usr == nil || ch == nil || usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	path := ch.Path_read()
+	msg := "[" + server.DisplayName_read() + "] " + usr.NickName_read()
+	if joined {
+		msg += " has joined " + path
+	} else {
+		msg += " has left " + path
+	}
+	server.Relay_queue(path, msg, true)
+}
+
+func relays_list() {
+	relays := c.Relays_read()
+	if len(relays) == 0 {
+		console_write("No channels are relayed.")
+		return
+	}
+	msg := ""
+	for i, relay := range relays {
+		msg += strconv.Itoa(i+1) + ": " + relay.Str() + "\r\n"
+	}
+	console_write(msg)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Functions shared by the ordered rule lists and the features configured with name=value options.
+
+// Anything with options which can be set by name, such as an automove rule or a schedule.
+type tt_option_setter interface {
+	Option_set(name, value string) error
+}
+
+// Splits an option given as name=value. Returns false if there is no =.
+func option_split(option string) (string, string, bool) {
+	fields := strings.SplitN(option, "=", 2)
+	if len(fields) != 2 {
+		return option, "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// Sets each option, given as name=value, stopping at the first which isn't valid.
+func options_set(setter tt_option_setter, options []string) error {
+	for _, option := range options {
+		name, value, ok := option_split(option)
+		if !ok {
+			return errors.New("Unrecognized option: " + option + ". Options must be given as name=value.")
+		}
+		if err := setter.Option_set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Moves the item at index from to index to in a slice of any type, shifting the items between them.
+func slice_move(slice interface{}, from, to int) bool {
+	count := reflect.ValueOf(slice).Len()
+	if from < 0 || from >= count || to < 0 || to >= count {
+		return false
+	}
+	swap := reflect.Swapper(slice)
+	for ; from < to; from++ {
+		swap(from, from+1)
+	}
+	for ; from > to; from-- {
+		swap(from, from-1)
+	}
+	return true
+}
+
+// Selects a rule by its number, or with a menu of the rule descriptions if val isn't a rule number.
+// Returns the index of the rule.
+func rule_menu(rules []string, val, prompt, empty string) (int, bool) {
+	if len(rules) == 0 {
+		console_write(empty)
+		return -1, true
+	}
+	if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= len(rules) {
+		return n - 1, false
+	}
+	res, aborted := console_read_menu(prompt+"\r\n", rules)
+	if aborted || res == -1 {
+		return -1, true
+	}
+	return res, false
+}
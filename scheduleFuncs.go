@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for sending messages on a schedule, either at a fixed interval or at times given by a cron expression.
+// Schedules are checked while the bot is logged in. A run missed while disconnected is skipped, rather than sent late.
+
+const SCHEDULE_LATE = time.Minute
+
+type tt_schedule struct {
+	Name    string `xml:"name,attr"`
+	To      string `xml:"to,attr"`
+	Every   string `xml:"every,omitempty"`
+	Cron    string `xml:"cron,omitempty"`
+	Message string `xml:"message"`
+	Paused  bool   `xml:"paused,attr,omitempty"`
+}
+
+// A parsed cron expression, with the allowed values of each field.
+type tt_cron struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyHour  bool
+	anyDay   bool
+	anyWeek  bool
+}
+
+// Parses a field of a cron expression, such as *, 5, 1-5, */15, 0-30/10 or 1,15.
+func cron_field_parse(field string, min, max int) (map[int]bool, bool) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if fields := strings.SplitN(part, "/", 2); len(fields) == 2 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 1 {
+				return nil, false
+			}
+			step = n
+			part = fields[0]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, false
+			}
+			start, end = n, n
+			if len(bounds) == 2 {
+				n, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, false
+				}
+				end = n
+			} else if step != 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, false
+		}
+		for i := start; i <= end; i += step {
+			values[i] = true
+		}
+	}
+	return values, true
+}
+
+// Parses a cron expression of minute, hour, day of month, month and day of week, with Sunday as 0 or 7.
+func cron_parse(expr string) (*tt_cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New(expr + " isn't a valid cron expression. It must have 5 fields: minute, hour, day of month, month and day of week.")
+	}
+	limits := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	names := []string{"minute", "hour", "day of month", "month", "day of week"}
+	parsed := []map[int]bool{}
+	for i, field := range fields {
+		values, ok := cron_field_parse(field, limits[i][0], limits[i][1])
+		if !ok {
+			return nil, errors.New(field + " isn't a valid " + names[i] + " in " + expr + ".")
+		}
+		parsed = append(parsed, values)
+	}
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+	return &tt_cron{
+		minutes:  parsed[0],
+		hours:    parsed[1],
+		days:     parsed[2],
+		months:   parsed[3],
+		weekdays: parsed[4],
+		anyHour:  strings.HasPrefix(fields[1], "*"),
+		anyDay:   strings.HasPrefix(fields[2], "*"),
+		anyWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// Reports whether the date matches the day of month and day of week.
+// As in cron, if both are restricted, matching either is enough. A field starting with * isn't restricted.
+func (cron *tt_cron) Day_match(t time.Time) bool {
+	day := cron.days[t.Day()]
+	week := cron.weekdays[int(t.Weekday())]
+	if cron.anyDay || cron.anyWeek {
+		return day && week
+	}
+	return day || week
+}
+
+// Returns the first time after t which matches the expression, or the zero time if there isn't one within 5 years.
+// Times skipped as the clocks go forward don't run. Times repeated as the clocks go back only run once, unless the hour is *.
+func (cron *tt_cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !cron.months[int(t.Month())] {
+			t = time_forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !cron.Day_match(t) {
+			t = time_forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !cron.hours[t.Hour()] {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if !cron.minutes[t.Minute()] || (!cron.anyHour && time_repeated(t)) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Returns next, or the minute after t if next isn't after t.
+// time.Date can return a time before the one asked for when it doesn't exist, because the clocks went forward.
+func time_forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+// Reports whether the clock showed the same time earlier, because it has gone back within the last day.
+func time_repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	_, before := t.Add(-24 * time.Hour).Zone()
+	if before <= offset {
+		return false
+	}
+	earlier := t.Add(-time.Duration(before-offset) * time.Second)
+	return earlier.Format("2006-01-02 15:04") == t.Format("2006-01-02 15:04")
+}
+
+// Returns when the schedule should next run after t.
+func (sched *tt_schedule) Next(t time.Time) time.Time {
+	if sched.Cron != "" {
+		cron, err := cron_parse(sched.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return cron.Next(t)
+	}
+	if every, ok := time_duration_parse(sched.Every); ok {
+		return t.Add(every)
+	}
+	return time.Time{}
+}
+
+func (sched *tt_schedule) To_str() string {
+	if sched.To == MESSAGE_BROADCAST {
+		return "broadcast"
+	}
+	if strings.HasPrefix(sched.To, "/") {
+		return "to " + sched.To
+	}
+	return "to user " + sched.To
+}
+
+func (sched *tt_schedule) When_str() string {
+	if sched.Cron != "" {
+		return "at " + sched.Cron
+	}
+	if every, ok := time_duration_parse(sched.Every); ok {
+		return "every " + time_duration_str(every)
+	}
+	return "never"
+}
+
+// Sets an option of the schedule from a name and value, such as every and 1h.
+func (sched *tt_schedule) Option_set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "name":
+		if value == "" || strings.Contains(value, " ") {
+			return errors.New("Schedule names can't be empty or contain spaces.")
+		}
+		sched.Name = value
+	case "to":
+		switch {
+		case value == "":
+			return errors.New("A schedule must be sent to a channel, a user, or broadcast.")
+		case strings.ToLower(value) == MESSAGE_BROADCAST:
+			sched.To = MESSAGE_BROADCAST
+		case strings.HasPrefix(value, "/"):
+			sched.To = channel_path_clean(value)
+		default:
+			sched.To = value
+		}
+	case "every":
+		if _, ok := time_duration_parse(value); !ok {
+			return errors.New(value + " isn't a valid interval.")
+		}
+		sched.Every = value
+		sched.Cron = ""
+	case "cron":
+		if _, err := cron_parse(value); err != nil {
+			return err
+		}
+		sched.Cron = strings.Join(strings.Fields(value), " ")
+		sched.Every = ""
+	case "message":
+		if value == "" {
+			return errors.New("The message can't be empty.")
+		}
+		sched.Message = value
+	default:
+		return errors.New("Unrecognized option: " + name)
+	}
+	return nil
+}
+
+// Returns why the schedule is incomplete, or an empty string.
+func (sched *tt_schedule) Check() string {
+	if sched.Name == "" {
+		return "The schedule has no name."
+	}
+	if sched.To == "" {
+		return "The schedule isn't sent to anyone."
+	}
+	if sched.Every == "" && sched.Cron == "" {
+		return "The schedule has no interval or cron expression."
+	}
+	if sched.Message == "" {
+		return "The schedule has no message."
+	}
+	return ""
+}
+
+func (server *tt_server) Schedules_read() []*tt_schedule {
+	defer server.Unlock()
+	server.Lock()
+	schedules := make([]*tt_schedule, len(server.Schedules))
+	copy(schedules, server.Schedules)
+	return schedules
+}
+
+func (server *tt_server) Schedule_find(name string) *tt_schedule {
+	for _, sched := range server.Schedules_read() {
+		if strings.ToLower(sched.Name) == strings.ToLower(name) {
+			return sched
+		}
+	}
+	return nil
+}
+
+func (server *tt_server) Schedule_add(sched *tt_schedule) {
+	defer server.Unlock()
+	server.Lock()
+	server.Schedules = append(server.Schedules, sched)
+	if server.schedule_next == nil {
+		server.schedule_next = make(map[*tt_schedule]time.Time)
+	}
+	server.schedule_next[sched] = sched.Next(time.Now())
+}
+
+func (server *tt_server) Schedule_remove(sched *tt_schedule) bool {
+	defer server.Unlock()
+	server.Lock()
+	for i, s := range server.Schedules {
+		if s == sched {
+			server.Schedules = append(server.Schedules[:i], server.Schedules[i+1:]...)
+			delete(server.schedule_next, sched)
+			return true
+		}
+	}
+	return false
+}
+
+func (server *tt_server) Schedule_pause(sched *tt_schedule, paused bool) {
+	defer server.Unlock()
+	server.Lock()
+	sched.Paused = paused
+	if server.schedule_next == nil {
+		server.schedule_next = make(map[*tt_schedule]time.Time)
+	}
+	server.schedule_next[sched] = sched.Next(time.Now())
+}
+
+func (server *tt_server) Schedule_paused(sched *tt_schedule) bool {
+	defer server.Unlock()
+	server.Lock()
+	return sched.Paused
+}
+
+// Returns when the schedule will next run, working it out if it hasn't been yet.
+func (server *tt_server) Schedule_next(sched *tt_schedule) time.Time {
+	defer server.Unlock()
+	server.Lock()
+	if server.schedule_next == nil {
+		server.schedule_next = make(map[*tt_schedule]time.Time)
+	}
+	next, exists := server.schedule_next[sched]
+	if !exists {
+		next = sched.Next(time.Now())
+		server.schedule_next[sched] = next
+	}
+	return next
+}
+
+func (server *tt_server) Schedule_next_set(sched *tt_schedule, next time.Time) {
+	defer server.Unlock()
+	server.Lock()
+	server.schedule_next[sched] = next
+}
+
+// Sends the message of a schedule, returning why it was skipped if it couldn't be sent.
+func (server *tt_server) Schedule_send(sched *tt_schedule) string {
+	return server.Message_send(sched.To, sched.Message)
+}
+
+// Runs every schedule which is due.
+func (server *tt_server) Schedule_run() {
+	now := time.Now()
+	for _, sched := range server.Schedules_read() {
+		if server.Schedule_paused(sched) {
+			continue
+		}
+		next := server.Schedule_next(sched)
+		if next.IsZero() || now.Before(next) {
+			continue
+		}
+		server.Schedule_next_set(sched, sched.Next(now))
+		if now.Sub(next) > SCHEDULE_LATE {
+			server.Log_write("Skipped the "+sched.Name+" schedule, which was due "+time_duration_str(now.Sub(next).Round(time.Second))+" ago while the bot was disconnected.", false)
+			continue
+		}
+		if reason := server.Schedule_send(sched); reason != "" {
+			server.Log_write("Skipped the "+sched.Name+" schedule: "+reason+".", true)
+		}
+	}
+}
+
+func (server *tt_server) Schedules_list() {
+	schedules := server.Schedules_read()
+	if len(schedules) == 0 {
+		console_write("No schedules have been added.")
+		return
+	}
+	msg := strconv.Itoa(len(schedules)) + " schedule"
+	if len(schedules) != 1 {
+		msg += "s"
+	}
+	msg += ":\r\n"
+	for _, sched := range schedules {
+		msg += sched.Name + ": " + sched.To_str() + " " + sched.When_str()
+		if server.Schedule_paused(sched) {
+			msg += ", paused"
+		} else if next := server.Schedule_next(sched); !next.IsZero() {
+			msg += ", next " + next.Format("Mon Jan 2 15:04")
+		}
+		msg += "\r\n" + sched.Message + "\r\n"
+	}
+	console_write(msg)
+}
+
+func (server *tt_server) Schedule_add_prompt() *tt_schedule {
+	sched := &tt_schedule{}
+	prompt := func(name, msg string) bool {
+		for {
+			val, err := console_read_prompt(msg)
+			if err != nil {
+				return false
+			}
+			if err := sched.Option_set(name, val); err != nil {
+				console_write(err.Error())
+				continue
+			}
+			return true
+		}
+	}
+	if !prompt("name", "Please enter a name for the schedule, with no spaces.") {
+		return nil
+	}
+	if server.Schedule_find(sched.Name) != nil {
+		console_write("A schedule named " + sched.Name + " already exists. Aborted.")
+		return nil
+	}
+	if !prompt("to", "Please enter the path of the channel to send the message to, a username to send it to, or broadcast.") {
+		return nil
+	}
+	res, aborted := console_read_menu("Please select when the message is sent.\r\n", []string{"At a fixed interval", "At times given by a cron expression"})
+	if aborted || res == -1 {
+		return nil
+	}
+	if res == 0 {
+		if !prompt("every", "Please enter the interval, such as 30m, 1h or 1d.") {
+			return nil
+		}
+	} else if !prompt("cron", "Please enter the cron expression, with the minute, hour, day of month, month and day of week, such as 0 9 * * 1 for 9:00 every Monday.") {
+		return nil
+	}
+	if !prompt("message", "Please enter the message.") {
+		return nil
+	}
+	return sched
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronParse(t *testing.T) {
+	tests := []struct {
+		expr  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"0 9 * * 1", true},
+		{"*/15 8-18 * * 1-5", true},
+		{"0-30/10 0 1,15 * *", true},
+		{"0 0 * * 7", true},
+		{"0 0 31 12 *", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+		{"1,,2 * * * *", false},
+	}
+	for _, test := range tests {
+		_, err := cron_parse(test.expr)
+		if (err == nil) != test.valid {
+			t.Errorf("cron_parse(%q): got error %v, want valid %v", test.expr, err, test.valid)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC)},
+		{"strictly after", "0 9 * * *", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{"later the same day", "30 18 * * *", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 18, 30, 0, 0, time.UTC)},
+		{"step", "*/15 * * * *", time.Date(2024, 1, 1, 10, 16, 0, 0, time.UTC), time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{"end of year", "0 0 1 1 *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 12 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"day of week", "45 18 * * 3", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 18, 45, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// 2024-01-01 is a Monday. With both fields restricted, either may match.
+		{"day of month or day of week, week first", "0 0 15 * 5", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"day of month or day of week, month first", "0 0 2 * 5", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"day of month with any day of week", "0 0 15 * *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"day of week with any day of month", "0 0 * * 5", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"stepped day of month and day of week", "0 0 */10 * 5", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"impossible date", "0 0 31 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := cron_parse(test.expr)
+		if err != nil {
+			t.Fatalf("%s: cron_parse(%q): %v", test.name, test.expr, err)
+		}
+		if got := cron.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%s: Next(%v) for %q = %v, want %v", test.name, test.from, test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data isn't available:", err)
+	}
+	est := time.FixedZone("EST", -5*60*60)
+	edt := time.FixedZone("EDT", -4*60*60)
+	// The clocks go forward from 2:00 EST to 3:00 EDT on 2024-03-10, and back from 2:00 EDT to 1:00 EST on 2024-11-03.
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"after the gap", "0 3 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"in the gap", "30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, est), time.Date(2024, 3, 11, 2, 30, 0, 0, edt)},
+		{"hourly across the gap", "0 * * * *", time.Date(2024, 3, 10, 1, 30, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"minutes across the gap", "*/20 * * * *", time.Date(2024, 3, 10, 1, 50, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"next day across the gap", "0 9 * * *", time.Date(2024, 3, 9, 10, 0, 0, 0, est), time.Date(2024, 3, 10, 9, 0, 0, 0, edt)},
+		{"first of a repeated time", "30 1 * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, edt), time.Date(2024, 11, 3, 1, 30, 0, 0, edt)},
+		{"repeated time runs once", "30 1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		{"hourly through the repeat", "0 * * * *", time.Date(2024, 11, 3, 1, 0, 0, 0, edt), time.Date(2024, 11, 3, 1, 0, 0, 0, est)},
+		{"after the repeat", "0 2 * * *", time.Date(2024, 11, 3, 1, 0, 0, 0, edt), time.Date(2024, 11, 3, 2, 0, 0, 0, est)},
+	}
+	for _, test := range tests {
+		cron, err := cron_parse(test.expr)
+		if err != nil {
+			t.Fatalf("%s: cron_parse(%q): %v", test.name, test.expr, err)
+		}
+		if got := cron.Next(test.from.In(loc)); !got.Equal(test.want) {
+			t.Errorf("%s: Next(%v) for %q = %v, want %v", test.name, test.from.In(loc), test.expr, got.In(loc), test.want.In(loc))
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sched tt_schedule
+		want  time.Time
+	}{
+		{tt_schedule{Every: "1h"}, time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{tt_schedule{Cron: "0 12 * * *"}, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{tt_schedule{Cron: "invalid"}, time.Time{}},
+		{tt_schedule{}, time.Time{}},
+	}
+	for _, test := range tests {
+		if got := test.sched.Next(from); !got.Equal(test.want) {
+			t.Errorf("Next(%v) for %+v = %v, want %v", from, test.sched, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Functions for remembering when users were last seen, by username, or by nickname for users without a username.
+
+type tt_seen struct {
+	UserName   string    `xml:"username,attr,omitempty"`
+	NickName   string    `xml:"nickname,attr,omitempty"`
+	LastLogin  time.Time `xml:"lastLogin"`
+	LastLogout time.Time `xml:"lastLogout"`
+	Connected  int64     `xml:"connectedSeconds"`
+	Channel    string    `xml:"channel,omitempty"`
+}
+
+type tt_seen_list struct {
+	XMLName xml.Name   `xml:"seen"`
+	Users   []*tt_seen `xml:"user"`
+}
+
+func seen_key(username, nickname string) string {
+	if username != "" {
+		return strings.ToLower(username)
+	}
+	return "nickname:" + strings.ToLower(nickname)
+}
+
+func (seen *tt_seen) Name_str() string {
+	if seen.UserName == "" {
+		return seen.NickName
+	}
+	if seen.NickName == "" || seen.NickName == seen.UserName {
+		return seen.UserName
+	}
+	return seen.NickName + " (" + seen.UserName + ")"
+}
+
+// Describes when the user was last seen, such as was last seen 3 hours ago in /Lobby/ for 2 hours.
+func (seen *tt_seen) Info_str() string {
+	str := seen.Name_str() + " was last seen "
+	if seen.LastLogout.IsZero() {
+		str += "at an unknown time"
+	} else {
+		str += time_duration_str(time.Since(seen.LastLogout).Round(time.Minute)) + " ago"
+	}
+	if seen.Channel != "" {
+		str += " in " + seen.Channel
+	}
+	if !seen.LastLogin.IsZero() && seen.LastLogout.After(seen.LastLogin) {
+		str += " for " + time_duration_str(seen.LastLogout.Sub(seen.LastLogin).Round(time.Minute))
+	}
+	str += "."
+	if seen.Connected > 0 {
+		str += " Total time connected: " + time_duration_str(time.Duration(seen.Connected)*time.Second) + "."
+	}
+	return str
+}
+
+func (server *tt_server) Seen_path() string {
+	path := server.State_path()
+	if path == "" {
+		return ""
+	}
+	return path + "seen.xml"
+}
+
+// Loads the last seen times the first time they are needed.
+// The seen lock is held until they are loaded, so nothing sees or changes them before then.
+func (server *tt_server) Seen_load() {
+	server.Lock()
+	loaded := server.seen_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	defer server.seen_lock.Unlock()
+	server.seen_lock.Lock()
+	server.Lock()
+	loaded = server.seen_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	fname := server.Seen_path()
+	list := &tt_seen_list{}
+	err := state_read(fname, list)
+	server.Lock()
+	server.seen_loaded = true
+	server.seen_failed = err != nil
+	if server.seen == nil {
+		server.seen = make(map[string]*tt_seen)
+	}
+	if err == nil {
+		for _, s := range list.Users {
+			if _, exists := server.seen[seen_key(s.UserName, s.NickName)]; !exists {
+				server.seen[seen_key(s.UserName, s.NickName)] = s
+			}
+		}
+	}
+	server.Unlock()
+	if err != nil {
+		server.Log_write("Error reading last seen times from "+fname+": "+err.Error()+". The file won't be changed until the bot is restarted.", true)
+	}
+}
+
+// Writes the last seen times if they have changed since they were last written.
+// Called regularly and on disconnecting, so the file isn't written for every login and logout.
+func (server *tt_server) Seen_write() bool {
+	server.Seen_load()
+	defer server.seen_lock.Unlock()
+	server.seen_lock.Lock()
+	server.Lock()
+	if !server.seen_dirty || server.seen_failed {
+		server.Unlock()
+		return false
+	}
+	server.seen_dirty = false
+	list := &tt_seen_list{}
+	for _, s := range server.seen {
+		entry := *s
+		list.Users = append(list.Users, &entry)
+	}
+	server.Unlock()
+	fname := server.Seen_path()
+	if fname == "" {
+		return false
+	}
+	if err := state_write(fname, list); err != nil {
+		server.Lock()
+		server.seen_dirty = true
+		server.Unlock()
+		server.Log_write("Error writing last seen times to "+fname+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// Returns the entry for usr, creating it if needed. Must be called with the server locked.
+func (server *tt_server) seen_entry(usr *tt_user) *tt_seen {
+	username := usr.UserName_read()
+	nickname := usr.NickName_read()
+	key := seen_key(username, nickname)
+	s, exists := server.seen[key]
+	if !exists {
+		s = &tt_seen{UserName: username}
+		server.seen[key] = s
+	}
+	s.NickName = nickname
+	server.seen_dirty = true
+	return s
+}
+
+// Records that usr has logged in. Users already logged in when the bot logs in have no known login time.
+func (server *tt_server) Seen_login(usr *tt_user) {
+	if usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	server.Seen_load()
+	server.Lock()
+	s := server.seen_entry(usr)
+	if usr.Conntime_isSet() {
+		s.LastLogin = usr.Conntime_read()
+	} else {
+		s.LastLogin = time.Time{}
+	}
+	server.Unlock()
+}
+
+func (server *tt_server) Seen_channel(usr *tt_user, ch *tt_channel) {
+	if usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	server.Seen_load()
+	path := ch.Path_read()
+	defer server.Unlock()
+	server.Lock()
+	server.seen_entry(usr).Channel = path
+}
+
+// Records that usr has logged out, or that the bot has stopped seeing them.
+func (server *tt_server) Seen_logout(usr *tt_user) {
+	if usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	server.Seen_load()
+	now := time.Now()
+	server.Lock()
+	s := server.seen_entry(usr)
+	s.LastLogout = now
+	if usr.Conntime_isSet() {
+		s.Connected += int64(now.Sub(usr.Conntime_read()) / time.Second)
+	}
+	if ch := usr.Channel_read(); ch != nil {
+		s.Channel = ch.Path_read()
+	}
+	server.Unlock()
+}
+
+// Records every user as last seen now, used when the bot disconnects.
+func (server *tt_server) Seen_logout_all() {
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		server.Seen_logout(usr)
+	}
+	server.Seen_write()
+}
+
+// Answers a seen query for name, which is matched against usernames and nicknames.
+func (server *tt_server) Seen_query(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Usage: seen <username or nickname>"
+	}
+	lname := strings.ToLower(name)
+	for _, usr := range server.Users_sort(server.Uid_read()) {
+		if strings.ToLower(usr.UserName_read()) != lname && strings.ToLower(usr.NickName_read()) != lname {
+			continue
+		}
+		str := usr.NickName_log() + " is logged in now"
+		if ch := usr.Channel_read(); ch != nil {
+			str += " in " + ch.Path_read()
+		}
+		if usr.Conntime_isSet() {
+			str += ", and has been for " + usr.Conntime_read_str()
+		}
+		return str + "."
+	}
+	server.Seen_load()
+	server.Lock()
+	matches := []tt_seen{}
+	if s, exists := server.seen[lname]; exists {
+		matches = append(matches, *s)
+	} else {
+		for _, s := range server.seen {
+			if strings.ToLower(s.NickName) == lname {
+				matches = append(matches, *s)
+			}
+		}
+	}
+	server.Unlock()
+	if len(matches) == 0 {
+		return "I haven't seen " + name + "."
+	}
+	// The most recently seen user with the nickname is the most likely one.
+	latest := matches[0]
+	for _, s := range matches[1:] {
+		if s.LastLogout.After(latest.LastLogout) {
+			latest = s
+		}
+	}
+	str := latest.Info_str()
+	if len(matches) > 1 {
+		str += " " + strconv.Itoa(len(matches)-1) + " other users have also used this nickname."
+	}
+	return str
+}
@@ -58,11 +58,9 @@ type tt_server struct {
 	AutoConnectOnDisconnect    bool   `xml:"autoConnectOnDisconnect"`
 	AutoConnectOnKick          bool   `xml:"autoConnectOnKick"`
 	kicked                     bool
-	AutoSubscriptions          int `xml:"automatic>subscriptions,omitempty"`
-	AutoMoveFrom               int `xml:"automatic>moveFrom,omitempty"`
-	autoMoveFrom               int
-	AutoMoveTo                 int `xml:"automatic>moveTo,omitempty"`
-	autoMoveTo                 int
+	AutoSubscriptions          int  `xml:"automatic>subscriptions,omitempty"`
+	AutoMoveFrom               int  `xml:"automatic>moveFrom,omitempty"`
+	AutoMoveTo                 int  `xml:"automatic>moveTo,omitempty"`
 	DisplayExtendedConnInfo    bool `xml:"displayExtendedConnInfo"`
 	DisplayStatusUpdates       bool `xml:"displayStatusUpdates"`
 	DisplaySubscriptionUpdates bool `xml:"displaySubscriptionUpdates"`
@@ -85,6 +83,11 @@ type tt_server struct {
 	accounts_cached            map[string]map[string]string
 	bans                       map[string]map[string]string
 	bans_cached                map[string]map[string]string
+	file_accepted              map[string]string
+	tempbans                   []*tt_tempban
+	tempbans_loaded            bool
+	tempbans_failed            bool
+	tempbans_lock              sync.Mutex
 	log_username               string
 	log_buffer                 string
 	log_history                []string
@@ -92,7 +95,59 @@ type tt_server struct {
 	log_timestamp              string
 	log_timestamp_console      string
 	log_timestamp_account      map[string]string
-	Debug                      bool `xml:"debug,omitempty"`
+	Debug                      bool   `xml:"debug,omitempty"`
+	KickBanThreshold           int    `xml:"moderation>kickBan>threshold,omitempty"`
+	KickBanWindow              string `xml:"moderation>kickBan>window,omitempty"`
+	KickBanDuration            string `xml:"moderation>kickBan>duration,omitempty"`
+	kicks                      map[string][]time.Time
+	Flood                      *tt_flood_config `xml:"moderation>flood,omitempty"`
+	flood_users                map[int]*tt_flood_user
+	flood_levels               map[string]*tt_flood_level
+	Filters                    []*tt_filter_rule `xml:"moderation>filters>rule,omitempty"`
+	NickPolicy                 *tt_nick_policy   `xml:"moderation>nicknames,omitempty"`
+	nick_timers                map[int]*time.Timer
+	ClientPolicy               *tt_client_policy   `xml:"moderation>clients,omitempty"`
+	ChannelLocks               []*tt_channel_lock  `xml:"moderation>guard>channel,omitempty"`
+	AutoMoveRules              []*tt_automove_rule `xml:"automove>rule,omitempty"`
+	automove_wait              map[int]bool
+	AutoSubRules               []*tt_autosub_rule `xml:"autosubscriptions>rule,omitempty"`
+	autosub_lock               sync.Mutex
+	Archives                   []*tt_archive_channel `xml:"archive>channel,omitempty"`
+	archive_lock               sync.Mutex
+	archive_downloads          map[string]bool
+	archive_pruning            bool
+	prune_failed               map[string]bool
+	Welcome                    *tt_welcome `xml:"welcome,omitempty"`
+	welcome_seen               map[string]map[string]bool
+	welcome_loaded             bool
+	welcome_failed             bool
+	welcome_lock               sync.Mutex
+	Schedules                  []*tt_schedule `xml:"schedules>schedule,omitempty"`
+	schedule_next              map[*tt_schedule]time.Time
+	MemoConfig                 *tt_memo_config `xml:"memos,omitempty"`
+	memos                      []*tt_memo
+	memos_loaded               bool
+	memos_failed               bool
+	memos_lock                 sync.Mutex
+	seen                       map[string]*tt_seen
+	seen_loaded                bool
+	seen_failed                bool
+	seen_dirty                 bool
+	seen_lock                  sync.Mutex
+	Analytics                  *tt_analytics_config `xml:"analytics,omitempty"`
+	stats                      *tt_stats
+	stats_open                 map[int]*tt_session
+	stats_loaded               bool
+	stats_failed               bool
+	stats_dirty                bool
+	stats_lock                 sync.Mutex
+	Alerts                     *tt_alert_config `xml:"alerts,omitempty"`
+	alert_active               map[string]string
+	alert_counts               []tt_alert_count
+	health                     tt_health
+	cmd_written                time.Time
+	relay_queue                []tt_relay_queued
+	relay_draining             bool
 }
 
 func NewServer(conf *config) *tt_server {
@@ -175,6 +230,8 @@ func (server *tt_server) connect_silent() error {
 }
 
 func (server *tt_server) init_vars() {
+	server.Seen_logout_all()
+	server.Sessions_close_all()
 	server.Log_reset()
 	server.Logged_in_set(false)
 	defer server.Unlock()
@@ -184,6 +241,8 @@ func (server *tt_server) init_vars() {
 	server.log_timestamp_account = make(map[string]string, 0)
 	server.log_timestamp = ""
 	server.uid = 0
+	server.prune_failed = nil
+	server.alert_counts = nil
 }
 
 func (server *tt_server) connect() error {
@@ -192,6 +251,7 @@ func (server *tt_server) connect() error {
 		server.Log_debug("Error connecting to " + server.Host_read() + ":" + server.Tcpport_read() + ": " + err.Error() + ".\r\nConnection failure.")
 		return err
 	}
+	server.Health_connected()
 	server.Log_write("Connected.", true)
 	server.Log_debug("Debug mode enabled.")
 	return nil
@@ -246,6 +306,7 @@ loop:
 			if server.Shutdown_read() {
 				break loop
 			}
+			server.Health_disconnect("Connection lost: " + err.Error())
 			server.disconnect()
 			if server.Kicked_read() {
 				if server.AutoConnectOnKick_read() {
@@ -320,7 +381,7 @@ loop:
 			if !server.User_rights_check(TT_USERRIGHT_VIEW_ALL_USERS) {
 				msg += "Warning: you cannot view any users unless you have joined a channel, and you will see only those users in the channel you have joined. Insufficient information about user login and logouts will be sent to the bot, which may cause problems and errors.\r\n"
 			}
-			if server.AutoMove_enabled() {
+			if len(server.AutoMoveRules_read()) != 0 {
 				msg += "Automatic moving of users enabled.\r\n"
 			}
 			server.Log_write(msg, true)
@@ -376,6 +437,8 @@ loop:
 			ch.Maxusers_set(cmaxusers)
 			coptions, _ := teamtalk_param_int(params, "type")
 			ch.Options_set(coptions)
+			ch.Audiocodec_set(teamtalk_param_list(params, "audiocodec"))
+			ch.Audiocfg_set(teamtalk_param_list(params, "audiocfg"))
 			if server.Cmdid_read() != TT_CMD_LOGIN {
 				server.Log("Channel added.")
 				server.Log("Name: " + cname)
@@ -433,8 +496,13 @@ loop:
 				if server.Cmdid_read() != TT_CMD_LOGIN {
 					server.Log_username_set(fowner)
 					server.Log("File added to " + ch.Path_read() + ".\r\nFilename: " + fname + "\r\nFile owner: " + fowner)
+					go server.Archive_added(ch, fname)
 				}
 			}
+		case "fileaccepted":
+			server.Lock()
+			server.file_accepted = params
+			server.Unlock()
 		case "removefile":
 			cid, _ := teamtalk_param_int(params, "chanid")
 			ch := server.Channel_find_id(cid)
@@ -463,6 +531,7 @@ loop:
 			usr.Subscriptions_remote_set(subscriptions_remote)
 			subscriptions_local, _ := teamtalk_param_int(params, "sublocal")
 			usr.Subscriptions_local_set(subscriptions_local)
+			usr.AutoSubBase_set(subscriptions_local)
 			statusmode, _ := teamtalk_param_int(params, "statusmode")
 			usr.StatusMode_set(statusmode)
 			statusmsg := teamtalk_param_str(params, "statusmsg")
@@ -537,9 +606,16 @@ loop:
 			}
 			server.Log_console(console_msg, false)
 			go server.autosubscribe(usr)
+			go server.Filter_user(usr, true, true)
+			go server.NickPolicy_check(usr)
 			if server.Cmdid_read() != TT_CMD_LOGIN {
-				go server.automove(usr)
+				go server.ClientPolicy_check(usr)
+				go server.automove(usr, nil)
+				go server.Welcome_login(usr)
 			}
+			go server.Memos_deliver(usr)
+			go server.Seen_login(usr)
+			server.Session_login(usr)
 		case "updateuser":
 			uid, _ := teamtalk_param_int(params, "userid")
 			usr := server.User_find_id(uid)
@@ -555,7 +631,14 @@ loop:
 			server.Log_username_set(username)
 			lnickname := usr.NickName_log()
 			nickname := teamtalk_param_str(params, "nickname")
-			if nickname != usr.NickName_read() {
+			nick_changed := nickname != usr.NickName_read()
+			statusmsg_changed := teamtalk_param_str(params, "statusmsg") != usr.StatusMsg_read()
+			usertype, _ := teamtalk_param_int(params, "usertype")
+			usertype_changed := usertype != 0 && usertype != usr.UserType_read()
+			if usertype_changed {
+				usr.UserType_set(usertype)
+			}
+			if nick_changed {
 				nick_msg += lnickname + " changed nickname"
 				usr.NickName_set(nickname)
 				lnickname = usr.NickName_log()
@@ -643,6 +726,17 @@ loop:
 			if console_msg != "" {
 				server.Log_console(console_msg, false)
 			}
+			if nick_changed || statusmsg_changed {
+				go server.Filter_user(usr, nick_changed, statusmsg_changed)
+			}
+			if nick_changed {
+				go server.NickPolicy_check(usr)
+			}
+			if usertype_changed && uid == server.Uid_read() {
+				go server.AutoSub_apply()
+			} else if usertype_changed {
+				go server.autosubscribe(usr)
+			}
 		case "adduser":
 			uid, _ := teamtalk_param_int(params, "userid")
 			usr := server.User_find_id(uid)
@@ -656,6 +750,7 @@ loop:
 				usr.Subscriptions_remote_set(subscriptions_remote)
 				subscriptions_local, _ := teamtalk_param_int(params, "sublocal")
 				usr.Subscriptions_local_set(subscriptions_local)
+				usr.AutoSubBase_set(subscriptions_local)
 				statusmode, _ := teamtalk_param_int(params, "statusmode")
 				usr.StatusMode_set(statusmode)
 				statusmsg := teamtalk_param_str(params, "statusmsg")
@@ -688,8 +783,15 @@ loop:
 					msghead += "is in"
 				}
 				server.Log(msghead + " " + chanpath)
+				if server.Cmdid_read() != TT_CMD_LOGIN {
+					go server.Welcome_channel(usr, ch)
+					server.Relay_notice(usr, ch, true)
+				}
+				go server.Seen_channel(usr, ch)
+				server.Session_join(usr, ch)
 			}
-			go server.automove(usr)
+			go server.autosubscribe(usr)
+			go server.automove(usr, ch)
 		case "joined":
 			server.Kicked_set(false)
 			cid, _ := teamtalk_param_int(params, "chanid")
@@ -751,7 +853,10 @@ loop:
 			res := ch.User_remove(usr)
 			if res {
 				server.Log(lnickname + " has left " + chanpath)
+				server.Session_leave(usr)
+				server.Relay_notice(usr, ch, false)
 			}
+			go server.autosubscribe(usr)
 		case "messagedeliver":
 			// Completely rewrite this to properly log all messages.
 			msg_type, _ := teamtalk_param_int(params, "type")
@@ -763,6 +868,16 @@ loop:
 			cid, _ := teamtalk_param_int(params, "chanid")
 			ch := server.Channel_find_id(cid)
 			server.Message_info(msg_type, usr_src, usr_dest, ch, msg_content)
+			if reason, flooding := server.Flood_check(msg_type, usr_src, msg_content); flooding {
+				server.Flood_violation(usr_src, reason, msg_content)
+			}
+			go server.Filter_message(msg_type, usr_src, ch, msg_content)
+			if msg_type != TT_MSGTYPE_USER || uid_dest == server.Uid_read() {
+				go server.Chat_command(msg_type, usr_src, msg_content)
+			}
+			if msg_type == TT_MSGTYPE_CHANNEL {
+				server.Relay_message(usr_src, ch, msg_content)
+			}
 		case "updatechannel":
 			cid, _ := teamtalk_param_int(params, "chanid")
 			ch := server.Channel_find_id(cid)
@@ -831,8 +946,21 @@ loop:
 				ch.Quota_set(cquota)
 				msg += "New disk quota: " + ch.Quota_read_str() + "\r\n"
 			}
+			caudiocodec_old := teamtalk_format_list(ch.Audiocodec_read())
+			caudiocodec := teamtalk_param_list(params, "audiocodec")
+			if teamtalk_param_find(params, "audiocodec") && caudiocodec_old != teamtalk_format_list(caudiocodec) {
+				ch.Audiocodec_set(caudiocodec)
+				msg += "New audio codec: " + teamtalk_format_list(caudiocodec) + "\r\n"
+			}
+			caudiocfg_old := teamtalk_format_list(ch.Audiocfg_read())
+			caudiocfg := teamtalk_param_list(params, "audiocfg")
+			if teamtalk_param_find(params, "audiocfg") && caudiocfg_old != teamtalk_format_list(caudiocfg) {
+				ch.Audiocfg_set(caudiocfg)
+				msg += "New audio configuration: " + teamtalk_format_list(caudiocfg) + "\r\n"
+			}
 			if msg != "" {
 				server.Log("Channel " + cpath_old + " updated.\r\n" + msg)
+				go server.Guard_check(ch)
 			}
 		case "error":
 			msg := teamtalk_param_str(params, "message")
@@ -859,9 +987,16 @@ loop:
 			if id == TT_CMD_LOGIN && server.Cmderror_read() == nil {
 				// Login command has finished successfully.
 				server.Login_info()
+				// Legacy automove settings refer to channel IDs, which are only known once logged in.
+				if server.AutoMove_migrate() {
+					c.Write()
+				}
+				go server.Guard_check_all()
+				go server.Archive_check_all()
 			}
 			break
 		case "pong":
+			server.Health_pong()
 			continue loop
 		case "kicked":
 			uid, _ := teamtalk_param_int(params, "kickerid")
@@ -886,6 +1021,11 @@ loop:
 			if len(params) == 0 {
 				// This client has been kicked, or otherwise logged out.
 				server.Log_write("Logged out.", true)
+				if server.Kicked_read() {
+					server.Health_disconnect("Kicked from the server.")
+				} else {
+					server.Health_disconnect("Logged out by the server.")
+				}
 				server.init_vars()
 				server.disconnect()
 				if server.Kicked_read() && server.AutoConnectOnKick_read() {
@@ -909,12 +1049,18 @@ loop:
 				disconmsg += ". Connection time unknown"
 			}
 			server.Log(disconmsg + ".")
+			server.Seen_logout(usr)
+			server.Session_logout(usr)
 			server.User_remove(uid)
+			server.Flood_forget(uid)
+			server.NickPolicy_forget(uid)
 		case "useraccount":
 			username := teamtalk_param_str(params, "username")
 			password := teamtalk_param_str(params, "password")
 			usertype, _ := teamtalk_param_int(params, "usertype")
 			userrights, _ := teamtalk_param_int(params, "userrights")
+			note := teamtalk_param_str(params, "note")
+			initchan := teamtalk_param_str(params, "initchan")
 			server.Lock()
 			if server.accounts_cached == nil {
 				server.accounts_cached = make(map[string]map[string]string)
@@ -925,18 +1071,16 @@ loop:
 			server.accounts_cached[username]["password"] = password
 			server.accounts_cached[username]["usertype"] = teamtalk_flags_usertype_str(usertype)
 			server.accounts_cached[username]["rights"] = teamtalk_flags_userrights_str(userrights)
+			server.accounts_cached[username]["note"] = note
+			server.accounts_cached[username]["initchan"] = initchan
 			server.Unlock()
 		case "userbanned":
-			ip := teamtalk_param_str(params, "ipaddr")
-			delete(params, "ipaddr")
+			key := ban_key(params)
 			server.Lock()
 			if server.bans_cached == nil {
 				server.bans_cached = make(map[string]map[string]string)
 			}
-			if _, exists := server.bans_cached[ip]; !exists {
-				server.bans_cached[ip] = make(map[string]string)
-			}
-			server.bans_cached[ip] = params
+			server.bans_cached[key] = params
 			server.Unlock()
 		default:
 			server.Log_write("Error: unrecognized command received.\r\nCommand:\r\n"+cmdline, true)
@@ -967,7 +1111,7 @@ func (server *tt_server) Account_add_prompt(username, password, usertype string)
 			return true
 		}
 	}
-	res := server.cmd_new_account(username, password, utype, urights)
+	res := server.cmd_new_account(username, password, utype, urights, "", "", true)
 	if !res {
 		console_write("Failed to add account.")
 		return true
@@ -1456,170 +1600,6 @@ func (server *tt_server) AutoSubscriptions_set(subs int) {
 	server.AutoSubscriptions = subs
 }
 
-func (server *tt_server) AutoMoveFrom_read() int {
-	defer server.Unlock()
-	server.Lock()
-	return server.autoMoveFrom
-}
-
-func (server *tt_server) AutoMoveFrom_set(cid int) {
-	defer server.Unlock()
-	server.Lock()
-	server.autoMoveFrom = cid
-}
-
-func (server *tt_server) AutoMoveFrom_config_read() int {
-	defer server.Unlock()
-	server.Lock()
-	return server.AutoMoveFrom
-}
-
-func (server *tt_server) AutoMoveFrom_config_set(cid int) {
-	defer server.Unlock()
-	server.Lock()
-	server.AutoMoveFrom = cid
-}
-
-func (server *tt_server) AutoMoveTo_read() int {
-	defer server.Unlock()
-	server.Lock()
-	return server.autoMoveTo
-}
-
-func (server *tt_server) AutoMoveTo_set(cid int) {
-	defer server.Unlock()
-	server.Lock()
-	server.autoMoveTo = cid
-}
-
-func (server *tt_server) AutoMoveTo_config_read() int {
-	defer server.Unlock()
-	server.Lock()
-	return server.AutoMoveTo
-}
-
-func (server *tt_server) AutoMoveTo_config_set(cid int) {
-	defer server.Unlock()
-	server.Lock()
-	server.AutoMoveTo = cid
-}
-
-func (server *tt_server) AutoMove_config() {
-	if server.AutoMoveFrom_read() == 0 && server.AutoMoveFrom_config_read() != 0 {
-		server.AutoMoveFrom_set(server.AutoMoveFrom_config_read())
-	}
-	if server.AutoMoveTo_read() == 0 && server.AutoMoveTo_config_read() != 0 {
-		server.AutoMoveTo_set(server.AutoMoveTo_config_read())
-	}
-}
-
-func (server *tt_server) AutoMove_enabled() bool {
-	server.AutoMove_config()
-	autoMoveFrom := server.AutoMoveFrom_read()
-	autoMoveTo := server.AutoMoveTo_read()
-	if autoMoveFrom != 0 && autoMoveTo != 0 {
-		return true
-	} else if autoMoveFrom == 0 && autoMoveTo != 0 {
-		return true
-	} else if autoMoveFrom != 0 && autoMoveTo == 0 {
-		server.Log_write("Incorrect automove settings found. Disabling automatic user moving.", true)
-		server.AutoMove_clear()
-		return false
-	}
-	return false
-}
-
-func (server *tt_server) AutoMove_clear() {
-	server.AutoMoveFrom_set(0)
-	server.AutoMoveTo_set(0)
-	server.AutoMoveFrom_config_set(0)
-	server.AutoMoveTo_config_set(0)
-}
-
-func (server *tt_server) automove(usr *tt_user) {
-	if usr == nil {
-		return
-	}
-	if usr == server.User_find_id(server.Uid_read()) {
-		return
-	}
-	autoMoveFrom := server.AutoMoveFrom_read()
-	autoMoveTo := server.AutoMoveTo_read()
-	if autoMoveFrom == 0 && autoMoveTo == 0 {
-		return
-	}
-	if autoMoveFrom != 0 && autoMoveTo == 0 {
-		server.Log_write("Incorrect automove settings found. Disabling automatic user moving.", true)
-		server.AutoMove_clear()
-		return
-	}
-	ch_dest := server.Channel_find_id(autoMoveTo)
-	lnickname := usr.NickName_log()
-	ch := usr.Channel_read()
-	if autoMoveFrom != 0 || autoMoveTo != 0 {
-		if !server.User_rights_check(TT_USERRIGHT_MOVE_USERS) {
-			server.Log_write("Insufficient user rights. Disabling automatic user moving.", true)
-			server.AutoMove_clear()
-			return
-		}
-	}
-	if autoMoveFrom == 0 && autoMoveTo != 0 {
-		if ch_dest == nil {
-			server.Log_write("Unable to find destination channel. Disabling automatic moving.", true)
-			server.AutoMove_clear()
-			return
-		}
-		if ch != nil && ch == ch_dest {
-			return
-		}
-		if ch != nil {
-			return
-		}
-		time.Sleep(time.Millisecond * 500)
-		ch = usr.Channel_read()
-		if ch == ch_dest {
-			return
-		}
-		res := server.cmd_move_user(usr.Uid_read(), ch_dest.Id_read())
-		if !res {
-			server.Log_console("Automatic user move for "+lnickname+" failed.", true)
-			return
-		}
-		server.Log_console(lnickname+" automatically moved to "+ch_dest.Path_read(), false)
-		return
-	}
-	if autoMoveFrom != 0 && autoMoveTo != 0 {
-		ch_src := server.Channel_find_id(autoMoveFrom)
-		if ch_src == nil {
-			server.Log_write("Unable to find source channel. Disabling automatic moving.", true)
-			server.AutoMove_clear()
-			return
-		}
-		if ch_dest == nil {
-			server.Log_write("Unable to find destination channel. Disabling automatic moving.", true)
-			server.AutoMove_clear()
-			return
-		}
-		if ch_src == ch_dest {
-			server.Log_write("Source and destination channels are the same. Disabling automatic moving.", true)
-			server.AutoMove_clear()
-			return
-		}
-		if ch == ch_dest {
-			return
-		}
-		if ch != ch_src {
-			return
-		}
-		res := server.cmd_move_user(usr.Uid_read(), ch_dest.Id_read())
-		if !res {
-			server.Log_console("Automatic user move for "+lnickname+" failed.", true)
-			return
-		}
-		server.Log_console(lnickname+" automatically moved from "+ch_src.Path_read()+" to "+ch_dest.Path_read(), false)
-	}
-}
-
 func (server *tt_server) DisplayExtendedConnInfo_read() bool {
 	defer server.Unlock()
 	server.Lock()
@@ -1790,7 +1770,15 @@ func (server *tt_server) Send(cmd string, genid bool) (bool, error) {
 	// Ensures we can't send a command until the other has finished first.
 	server.cl.Lock()
 	defer server.cl.Unlock()
+	return server.send_locked(cmd)
+}
+
+// Sends a command and waits for it to finish. The caller must hold server.cl.
+func (server *tt_server) send_locked(cmd string) (bool, error) {
 	server.Cmd_sent_set(true)
+	server.Lock()
+	server.cmd_written = time.Now()
+	server.Unlock()
 	err := server.Write(cmd + "\r\n")
 	if err != nil {
 		server.Cmd_sent_set(false)
@@ -1825,7 +1813,7 @@ func (server *tt_server) KeepAlive(secs int) {
 	for {
 		select {
 		case <-server.keepalive.C:
-			server.cmd_ping()
+			server.Health_ping()
 		case <-server.keepalivedone:
 			return
 		}
@@ -1847,6 +1835,7 @@ func (server *tt_server) CheckEvents(secs int) {
 	server.checkevents = time.NewTicker(time.Duration(ms) * time.Millisecond)
 	server.checkeventson = true
 	server.Unlock()
+	server.Tempbans_expire()
 	for {
 		select {
 		case <-server.checkevents.C:
@@ -1855,7 +1844,14 @@ func (server *tt_server) CheckEvents(secs int) {
 			}
 			if server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
 				server.cmd_list_bans()
-			}
+				server.Tempbans_expire()
+			}
+			go server.Archive_prune_all()
+			server.Seen_write()
+			server.Stats_write()
+			server.Schedule_run()
+			server.Alerts_check()
+			server.Health_check()
 		case <-server.checkeventsdone:
 			return
 		}
@@ -1902,6 +1898,7 @@ func (server *tt_server) Write(str string) error {
 	server.Unlock()
 	if err != nil {
 		server.Log_debug("Error sending data:\r\n" + err.Error())
+		server.Health_disconnect("Error sending data: " + err.Error())
 		server.disconnect()
 	} else {
 		server.Log_debug("Sent data:\r\n" + str)
@@ -2100,6 +2097,22 @@ func (server *tt_server) Channel_find_path(path string) []*tt_channel {
 	return channels
 }
 
+// Returns the channel whose path is exactly path, ignoring case, or nil.
+func (server *tt_server) Channel_find_path_exact(path string) *tt_channel {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	for _, channel := range server.Channels_sort() {
+		if strings.ToLower(channel.Path_read()) == strings.ToLower(path) {
+			return channel
+		}
+	}
+	return nil
+}
+
 func (server *tt_server) Shutdown() {
 	if server.Shutdown_read() {
 		return
@@ -2108,6 +2121,7 @@ func (server *tt_server) Shutdown() {
 	server.shutdown = true
 	server.Unlock()
 	if server.connected() {
+		server.Health_disconnect("Disconnected by request.")
 		server.cl.Lock()
 		server.Write("quit\r\n")
 		server.Cmd_sent_set(true)
@@ -2534,10 +2548,14 @@ func (server *tt_server) autosubscribe(usr *tt_user) bool {
 	if usr == nil {
 		return false
 	}
-	autosubs := server.AutoSubscriptions_read()
-	if autosubs == 0 {
+	// Users who change channels are evaluated when they leave and when they join, which mustn't overlap.
+	defer server.autosub_lock.Unlock()
+	server.autosub_lock.Lock()
+	autosubs, source, enabled := server.AutoSub_evaluate(usr)
+	if !enabled {
 		return false
 	}
+	usr.AutoSubSource_set(source)
 	old_subscriptions_local := usr.Subscriptions_local_read()
 	if old_subscriptions_local == autosubs {
 		return false
@@ -2560,7 +2578,7 @@ func (server *tt_server) autosubscribe(usr *tt_user) bool {
 			sub_local_msg += "Subscriptions removed: " + subs_local_removed_str + "\r\n"
 		}
 		if sub_local_msg != "" {
-			server.Log_console(usr.NickName_log()+": local subscription change.\r\n"+sub_local_msg, false)
+			server.Log_console(usr.NickName_log()+": local subscription change from "+source+".\r\n"+sub_local_msg, false)
 		}
 	}
 	return true
@@ -2727,6 +2745,111 @@ func (server *tt_server) cmd_move_user(uid, cid int) bool {
 	return res
 }
 
+// Updates properties of a channel, given as pairs of parameter names and values.
+func (server *tt_server) cmd_update_channel(cid int, params ...string) bool {
+	if !server.cmd_can_send("Unable to update channel.") {
+		return false
+	}
+	if server.Channel_find_id(cid) == nil {
+		server.Log_write("Failed to update channel: invalid channel ID.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("updatechannel", append([]string{"chanid", strconv.Itoa(cid)}, params...)...)
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to update channel: "+err.Error(), true)
+	}
+	return res
+}
+
+// Creates a channel with properties given as pairs of parameter names and values.
+func (server *tt_server) cmd_make_channel(name string, pid int, params ...string) bool {
+	if !server.cmd_can_send("Unable to create channel.") {
+		return false
+	}
+	if server.Channel_find_id(pid) == nil {
+		server.Log_write("Failed to create channel: invalid parent channel ID.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("makechannel", append([]string{"name", name, "parentid", strconv.Itoa(pid)}, params...)...)
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to create channel: "+err.Error(), true)
+	}
+	return res
+}
+
+func (server *tt_server) cmd_remove_channel(cid int) bool {
+	if !server.cmd_can_send("Unable to remove channel.") {
+		return false
+	}
+	if server.Channel_find_id(cid) == nil {
+		server.Log_write("Failed to remove channel: invalid channel ID.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("removechannel", "chanid", strconv.Itoa(cid))
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to remove channel: "+err.Error(), true)
+	}
+	return res
+}
+
+// Registers a file transfer with the server, returning the parameters of the accepted transfer.
+func (server *tt_server) cmd_register_transfer(command string, cid int, params ...string) map[string]string {
+	if !server.cmd_can_send("Unable to start file transfer.") {
+		return nil
+	}
+	if server.Channel_find_id(cid) == nil {
+		server.Log_write("Failed to start file transfer: invalid channel ID.", true)
+		return nil
+	}
+	scmd := teamtalk_format_cmd(command, append([]string{"chanid", strconv.Itoa(cid)}, params...)...)
+	scmd += " id=" + strconv.Itoa(server.Cmdid_add())
+	// The command lock is held until the accepted parameters are read, so another transfer can't replace them.
+	server.cl.Lock()
+	defer server.cl.Unlock()
+	server.Lock()
+	server.file_accepted = nil
+	server.Unlock()
+	res, err := server.send_locked(scmd)
+	if err != nil {
+		server.Log_write("Failed to start file transfer: "+err.Error(), true)
+	}
+	server.Lock()
+	accepted := server.file_accepted
+	server.file_accepted = nil
+	server.Unlock()
+	if !res || accepted == nil {
+		return nil
+	}
+	return accepted
+}
+
+func (server *tt_server) cmd_send_file(cid int, fname string, fsize int) map[string]string {
+	return server.cmd_register_transfer("regsendfile", cid, "filename", fname, "filesize", strconv.Itoa(fsize))
+}
+
+func (server *tt_server) cmd_recv_file(cid int, fname string) map[string]string {
+	return server.cmd_register_transfer("regrecvfile", cid, "filename", fname)
+}
+
+func (server *tt_server) cmd_delete_file(cid int, fname string) bool {
+	if !server.cmd_can_send("Unable to delete file.") {
+		return false
+	}
+	if server.Channel_find_id(cid) == nil {
+		server.Log_write("Failed to delete file: invalid channel ID.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("deletefile", "chanid", strconv.Itoa(cid), "filename", fname)
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to delete file: "+err.Error(), true)
+	}
+	return res
+}
+
 func (server *tt_server) cmd_ping() bool {
 	if !server.cmd_can_send("Unable to ping server.") {
 		return false
@@ -2930,32 +3053,28 @@ func (server *tt_server) cmd_list_bans() bool {
 	msg_added := ""
 	msg_removed := ""
 	if len(added) != 0 {
-		msg_added = "The following IP address"
+		msg_added = "The following ban"
 		if len(added) != 1 {
-			msg_added += "es are"
+			msg_added += "s have"
 		} else {
-			msg_added += " is"
+			msg_added += " has"
 		}
-		msg_added += " ban:\r\n"
-		addrs := ""
-		for addr := range added {
-			addrs += addr + "\r\n"
+		msg_added += " been added:\r\n"
+		for _, key := range mapKeys_sorted(added) {
+			msg_added += ban_info_str(added[key]) + "\r\n"
 		}
-		msg_added += addrs
 	}
 	if len(removed) != 0 {
-		msg_removed = "The following IP address"
+		msg_removed = "The following ban"
 		if len(removed) != 1 {
-			msg_removed += "es are"
+			msg_removed += "s have"
 		} else {
-			msg_removed += " is"
+			msg_removed += " has"
 		}
-		msg_removed += " no longer ban:\r\n"
-		addrs := ""
-		for addr := range removed {
-			addrs += addr + "\r\n"
+		msg_removed += " been removed:\r\n"
+		for _, key := range mapKeys_sorted(removed) {
+			msg_removed += ban_info_str(removed[key]) + "\r\n"
 		}
-		msg_removed += addrs
 	}
 	msg := ""
 	if msg_added != "" || msg_removed != "" {
@@ -2967,7 +3086,7 @@ func (server *tt_server) cmd_list_bans() bool {
 	return true
 }
 
-func (server *tt_server) cmd_new_account(username, password string, utype, urights int) bool {
+func (server *tt_server) cmd_new_account(username, password string, utype, urights int, note, initchan string, refresh bool) bool {
 	if !server.cmd_can_send("Unable to add user account.") {
 		return false
 	}
@@ -2979,14 +3098,102 @@ func (server *tt_server) cmd_new_account(username, password string, utype, urigh
 		"username", username,
 		"password", password,
 		"usertype", strconv.Itoa(utype),
-		"userrights", strconv.Itoa(urights)),
+		"userrights", strconv.Itoa(urights),
+		"note", note,
+		"initchan", initchan),
 		true)
 	if !res {
 		if err != nil {
-			server.Log_write("Failed to list user bans: "+err.Error(), true)
+			server.Log_write("Failed to add user account: "+err.Error(), true)
 		}
 		return false
 	}
-	server.cmd_list_accounts()
+	// Bulk callers refresh the account list once they have finished.
+	if refresh {
+		server.cmd_list_accounts()
+	}
 	return true
 }
+
+func (server *tt_server) cmd_ban(uid, bantype, cid int) bool {
+	if !server.cmd_can_send("Unable to ban user.") {
+		return false
+	}
+	if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		server.Log_write("Unable to ban user. Insufficient permission.", true)
+		return false
+	}
+	if server.User_find_id(uid) == nil {
+		server.Log_write("Failed to ban user: invalid user ID.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("ban", "userid", strconv.Itoa(uid), "bantype", strconv.Itoa(bantype))
+	if teamtalk_flags_read(bantype, TT_BANTYPE_CHANNEL) {
+		if server.Channel_find_id(cid) == nil {
+			server.Log_write("Failed to ban user: invalid channel ID.", true)
+			return false
+		}
+		scmd += " chanid=" + strconv.Itoa(cid)
+	}
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to ban user: "+err.Error(), true)
+	}
+	if res {
+		server.cmd_list_bans()
+	}
+	return res
+}
+
+func (server *tt_server) cmd_unban(ip, username, chanpath string, bantype int) bool {
+	if !server.cmd_can_send("Unable to remove ban.") {
+		return false
+	}
+	if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		server.Log_write("Unable to remove ban. Insufficient permission.", true)
+		return false
+	}
+	res, err := server.Send(teamtalk_format_cmd("unban",
+		"ipaddr", ip,
+		"username", username,
+		"chanpath", chanpath,
+		"bantype", strconv.Itoa(bantype)),
+		true)
+	if err != nil {
+		server.Log_write("Failed to remove ban: "+err.Error(), true)
+	}
+	if res {
+		server.cmd_list_bans()
+	}
+	return res
+}
+
+func (server *tt_server) cmd_kick(uid, cid int) bool {
+	if !server.cmd_can_send("Unable to kick user.") {
+		return false
+	}
+	if server.User_find_id(uid) == nil {
+		server.Log_write("Failed to kick user: invalid user ID.", true)
+		return false
+	}
+	if uid == server.Uid_read() {
+		server.Log_write("Failed to kick user: user ID is the bot.", true)
+		return false
+	}
+	scmd := teamtalk_format_cmd("kick", "userid", strconv.Itoa(uid))
+	if cid != 0 {
+		if server.Channel_find_id(cid) == nil {
+			server.Log_write("Failed to kick user: invalid channel ID.", true)
+			return false
+		}
+		scmd += " chanid=" + strconv.Itoa(cid)
+	} else if !server.User_rights_check(TT_USERRIGHT_KICK_USERS) {
+		server.Log_write("Unable to kick user. Insufficient permission.", true)
+		return false
+	}
+	res, err := server.Send(scmd, true)
+	if err != nil {
+		server.Log_write("Failed to kick user: "+err.Error(), true)
+	}
+	return res
+}
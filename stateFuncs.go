@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+)
+
+// Functions for storing state the bot keeps between runs.
+
+func (server *tt_server) State_path() string {
+	ps := string(filepath.Separator)
+	path, err := filepath.Abs(wd + ps + "state" + ps + server.DisplayName_read())
+	if err != nil {
+		return ""
+	}
+	return path + ps
+}
+
+func state_read(fname string, v interface{}) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return xml.NewDecoder(f).Decode(v)
+}
+
+func state_write(fname string, v interface{}) error {
+	if err := dir_create(filepath.Dir(fname)); err != nil {
+		return err
+	}
+	tmpname := fname + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		os.Remove(tmpname)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpname)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	return os.Rename(tmpname, fname)
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
 const TT_BEEP string = "\a"
 
 func str_yes_no(yesno bool) string {
@@ -9,6 +15,15 @@ func str_yes_no(yesno bool) string {
 	return "no"
 }
 
+// Returns str with its first character in upper case.
+func str_capitalize(str string) string {
+	r, size := utf8.DecodeRuneInString(str)
+	if r == utf8.RuneError {
+		return str
+	}
+	return string(unicode.ToUpper(r)) + str[size:]
+}
+
 func stringFormatWithBS(str string) string {
 	if str == "" {
 		return ""
@@ -29,3 +44,23 @@ func stringFormatWithBS(str string) string {
 	}
 	return ts
 }
+
+// Matches str against a pattern in which * matches any run of characters and ? matches one character, ignoring case.
+func str_glob_match(pattern, str string) bool {
+	expr := ""
+	for _, chr := range pattern {
+		switch chr {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(chr))
+		}
+	}
+	re, err := regexp.Compile("(?is)^" + expr + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(str)
+}
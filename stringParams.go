@@ -89,3 +89,20 @@ func restoreParams(params []string, delim, wordchar string) []string {
 	}
 	return params
 }
+
+// Splits the first parameter off str, which may be surrounded by wordchar if it contains delim.
+// The rest of str is returned as it was given, so messages keep their spacing and quotes.
+func stringSplitParam(str, delim, wordchar string) (string, string) {
+	str = strings.TrimLeft(str, delim)
+	if wordchar != "" && strings.HasPrefix(str, wordchar) {
+		if end := strings.Index(str[len(wordchar):], wordchar); end != -1 {
+			end += len(wordchar)
+			return str[len(wordchar):end], strings.TrimPrefix(str[end+len(wordchar):], delim)
+		}
+	}
+	fields := strings.SplitN(str, delim, 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+	return fields[0], fields[1]
+}
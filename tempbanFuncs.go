@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Functions for bans that are removed automatically once they expire.
+
+type tt_tempban struct {
+	IpAddr   string    `xml:"ipaddr,omitempty"`
+	UserName string    `xml:"username,omitempty"`
+	NickName string    `xml:"nickname,omitempty"`
+	ChanPath string    `xml:"chanpath,omitempty"`
+	BanType  int       `xml:"bantype"`
+	Reason   string    `xml:"reason,omitempty"`
+	Owner    string    `xml:"owner,omitempty"`
+	Created  time.Time `xml:"created"`
+	Expires  time.Time `xml:"expires"`
+}
+
+type tt_tempbans struct {
+	XMLName xml.Name      `xml:"tempbans"`
+	Bans    []*tt_tempban `xml:"ban"`
+}
+
+func (tb *tt_tempban) Info_str() string {
+	str := ""
+	if tb.IpAddr != "" {
+		str += tb.IpAddr
+	}
+	if tb.UserName != "" {
+		if str != "" {
+			str += ", "
+		}
+		str += "username " + tb.UserName
+	}
+	if tb.NickName != "" {
+		str += " (" + tb.NickName + ")"
+	}
+	if tb.ChanPath != "" {
+		str += ", banned from " + tb.ChanPath
+	} else {
+		str += ", banned from the server"
+	}
+	return str
+}
+
+func (tb *tt_tempban) Expiry_str() string {
+	str := ""
+	remaining := time.Until(tb.Expires)
+	if remaining > 0 {
+		str = "expires in " + time_duration_str(remaining.Round(time.Second))
+	} else {
+		str = "expired"
+	}
+	if tb.Reason != "" {
+		str += ", reason: " + tb.Reason
+	}
+	return str
+}
+
+func (tb *tt_tempban) Matches(ban map[string]string) bool {
+	if teamtalk_param_str(ban, "chanpath") != tb.ChanPath {
+		return false
+	}
+	if teamtalk_flags_read(tb.BanType, TT_BANTYPE_IPADDR) && teamtalk_param_str(ban, "ipaddr") != tb.IpAddr {
+		return false
+	}
+	if teamtalk_flags_read(tb.BanType, TT_BANTYPE_USERNAME) && teamtalk_param_str(ban, "username") != tb.UserName {
+		return false
+	}
+	return true
+}
+
+func (server *tt_server) Tempbans_path() string {
+	path := server.State_path()
+	if path == "" {
+		return ""
+	}
+	return path + "tempbans.xml"
+}
+
+// Loads the temporary bans file the first time temporary bans are needed.
+// The load lock is held until the bans are loaded, so nothing sees or changes the list before then.
+func (server *tt_server) Tempbans_load() {
+	defer server.tempbans_lock.Unlock()
+	server.tempbans_lock.Lock()
+	server.Lock()
+	loaded := server.tempbans_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	fname := server.Tempbans_path()
+	tbs := &tt_tempbans{}
+	err := state_read(fname, tbs)
+	server.Lock()
+	server.tempbans_loaded = true
+	server.tempbans_failed = err != nil
+	if err == nil {
+		server.tempbans = append(tbs.Bans, server.tempbans...)
+	}
+	server.Unlock()
+	if err != nil {
+		server.Log_write("Error reading temporary bans from "+fname+": "+err.Error()+". The file won't be changed until the bot is restarted.", true)
+	}
+}
+
+func (server *tt_server) Tempbans_read() []*tt_tempban {
+	server.Tempbans_load()
+	defer server.Unlock()
+	server.Lock()
+	tempbans := make([]*tt_tempban, len(server.tempbans))
+	copy(tempbans, server.tempbans)
+	return tempbans
+}
+
+// Writes the temporary bans to their file, unless it couldn't be read, so it isn't overwritten.
+func (server *tt_server) Tempbans_write() bool {
+	server.Tempbans_load()
+	defer server.tempbans_lock.Unlock()
+	server.tempbans_lock.Lock()
+	server.Lock()
+	failed := server.tempbans_failed
+	tbs := &tt_tempbans{}
+	for _, t := range server.tempbans {
+		tb := *t
+		tbs.Bans = append(tbs.Bans, &tb)
+	}
+	server.Unlock()
+	fname := server.Tempbans_path()
+	if fname == "" || failed {
+		return false
+	}
+	if err := state_write(fname, tbs); err != nil {
+		server.Log_write("Error writing temporary bans to "+fname+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+func (server *tt_server) Tempban_add(tb *tt_tempban) bool {
+	server.Tempbans_load()
+	server.Lock()
+	tempbans := []*tt_tempban{}
+	for _, t := range server.tempbans {
+		if t.IpAddr == tb.IpAddr && t.UserName == tb.UserName && t.ChanPath == tb.ChanPath {
+			continue
+		}
+		tempbans = append(tempbans, t)
+	}
+	server.tempbans = append(tempbans, tb)
+	server.Unlock()
+	return server.Tempbans_write()
+}
+
+func (server *tt_server) Tempban_remove(tb *tt_tempban) bool {
+	server.Tempbans_load()
+	server.Lock()
+	tempbans := []*tt_tempban{}
+	found := false
+	for _, t := range server.tempbans {
+		if t == tb {
+			found = true
+			continue
+		}
+		tempbans = append(tempbans, t)
+	}
+	server.tempbans = tempbans
+	server.Unlock()
+	if !found {
+		return false
+	}
+	return server.Tempbans_write()
+}
+
+func (server *tt_server) Tempban_find(ban map[string]string) *tt_tempban {
+	for _, tb := range server.Tempbans_read() {
+		if tb.Matches(ban) {
+			return tb
+		}
+	}
+	return nil
+}
+
+// Removes every temporary ban whose expiry has passed,
+// including those which expired while the bot wasn't running.
+func (server *tt_server) Tempbans_expire() {
+	if !server.User_rights_check(TT_USERRIGHT_BAN_USERS) {
+		return
+	}
+	now := time.Now()
+	for _, tb := range server.Tempbans_read() {
+		if now.Before(tb.Expires) {
+			continue
+		}
+		if server.cmd_unban(tb.IpAddr, tb.UserName, tb.ChanPath, tb.BanType) {
+			server.Tempban_remove(tb)
+			server.Log_username_set(tb.UserName)
+			server.Log_write("The temporary ban of "+tb.Info_str()+" has expired and been removed.", false)
+			continue
+		}
+		if !server.connected() {
+			return
+		}
+		server.cmd_list_bans()
+		exists := false
+		for _, ban := range server.Bans_read() {
+			if tb.Matches(ban) {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		server.Tempban_remove(tb)
+		server.Log_write("The temporary ban of "+tb.Info_str()+" has expired, but had already been removed.", false)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hako/durafmt"
@@ -13,3 +15,84 @@ func time_duration_str(duration time.Duration) string {
 	}
 	return str
 }
+
+func time_duration_parse(str string) (time.Duration, bool) {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': time.Hour * 24,
+		'w': time.Hour * 24 * 7,
+	}
+	str = strings.ToLower(strings.TrimSpace(str))
+	if str == "" {
+		return 0, false
+	}
+	var duration time.Duration
+	num := ""
+	for i := 0; i < len(str); i++ {
+		ch := str[i]
+		if ch >= '0' && ch <= '9' {
+			num += string(ch)
+			continue
+		}
+		unit, exists := units[ch]
+		if !exists || num == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, false
+		}
+		duration += time.Duration(n) * unit
+		num = ""
+	}
+	if num != "" || duration <= 0 {
+		return 0, false
+	}
+	return duration, true
+}
+
+// Parses a time of day such as 9:30 or 21:00, returning the number of minutes after midnight.
+func time_of_day_parse(str string) (int, bool) {
+	fields := strings.Split(strings.TrimSpace(str), ":")
+	if len(fields) != 2 {
+		return 0, false
+	}
+	hours, err := strconv.Atoi(fields[0])
+	if err != nil || hours < 0 || hours > 23 {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(fields[1])
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, false
+	}
+	return hours*60 + minutes, true
+}
+
+// Parses a window of time during the day such as 22:00-06:00, returning the start and end in minutes after midnight.
+func time_window_parse(str string) (int, int, bool) {
+	fields := strings.Split(str, "-")
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	start, ok := time_of_day_parse(fields[0])
+	if !ok {
+		return 0, 0, false
+	}
+	end, ok := time_of_day_parse(fields[1])
+	if !ok || start == end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// Reports whether t falls within a window of time during the day.
+// Windows which end before they start span midnight.
+func time_window_check(start, end int, t time.Time) bool {
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Functions for displaying the channels of a server as a tree, with the users in each channel.
+
+const TREE_INDENT = "  "
+
+type tt_tree_options struct {
+	hideEmpty bool
+	depth     int
+}
+
+// Returns the subchannels of every channel, sorted by name.
+func (server *tt_server) Channel_children() map[int][]*tt_channel {
+	children := make(map[int][]*tt_channel)
+	for _, ch := range server.Channels_sort() {
+		if ch.Idparent_read() == 0 {
+			continue
+		}
+		children[ch.Idparent_read()] = append(children[ch.Idparent_read()], ch)
+	}
+	for _, list := range children {
+		sort.SliceStable(list, func(i, j int) bool {
+			return strings.ToLower(list[i].Name_read()) < strings.ToLower(list[j].Name_read())
+		})
+	}
+	return children
+}
+
+// Returns the number of users in ch and all of its subchannels.
+func channel_tree_users(ch *tt_channel, children map[int][]*tt_channel) int {
+	count := len(ch.Users_read())
+	for _, sub := range children[ch.Id_read()] {
+		count += channel_tree_users(sub, children)
+	}
+	return count
+}
+
+func (ch *tt_channel) Tree_str() string {
+	name := ch.Name_read()
+	if ch.Idparent_read() == 0 {
+		name = "/"
+	}
+	details := []string{}
+	count := len(ch.Users_read())
+	users := strconv.Itoa(count)
+	if max := ch.Maxusers_read(); max != 0 {
+		users += " of " + strconv.Itoa(max)
+	}
+	if count == 1 {
+		users += " user"
+	} else {
+		users += " users"
+	}
+	details = append(details, users)
+	if ch.Protected_read() != 0 {
+		details = append(details, "password protected")
+	}
+	if options := ch.Options_read(); options != TT_CHANNEL_DEFAULT {
+		details = append(details, teamtalk_flags_channel_options_str(options))
+	}
+	if topic := ch.Topic_read(); topic != "" {
+		details = append(details, "topic: "+topic)
+	}
+	return name + " (" + strings.Join(details, ", ") + ")"
+}
+
+func (usr *tt_user) Tree_str(ch *tt_channel) string {
+	details := []string{usr.StatusMode_read_str()}
+	if ch.Operator_check(usr.Uid_read()) {
+		details = append(details, "operator")
+	}
+	return usr.NickName_log() + " (" + strings.Join(details, ", ") + ")"
+}
+
+func (server *tt_server) channel_tree_write(ch *tt_channel, children map[int][]*tt_channel, options tt_tree_options, level int) string {
+	indent := strings.Repeat(TREE_INDENT, level)
+	str := indent + ch.Tree_str() + "\r\n"
+	for _, usr := range ch.Users_read() {
+		str += indent + TREE_INDENT + "- " + usr.Tree_str(ch) + "\r\n"
+	}
+	subs := []*tt_channel{}
+	for _, sub := range children[ch.Id_read()] {
+		if options.hideEmpty && channel_tree_users(sub, children) == 0 {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return str
+	}
+	if options.depth > 0 && level+1 >= options.depth {
+		str += indent + TREE_INDENT + strconv.Itoa(len(subs)) + " more subchannel"
+		if len(subs) != 1 {
+			str += "s"
+		}
+		return str + "\r\n"
+	}
+	for _, sub := range subs {
+		str += server.channel_tree_write(sub, children, options, level+1)
+	}
+	return str
+}
+
+// Returns the tree of channels starting at ch, or at the root channel if ch is nil.
+func (server *tt_server) Channel_tree(ch *tt_channel, options tt_tree_options) string {
+	if ch == nil {
+		ch = server.Channel_find_path_exact("/")
+	}
+	if ch == nil {
+		return "No channels found."
+	}
+	return server.channel_tree_write(ch, server.Channel_children(), options, 0)
+}
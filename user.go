@@ -23,6 +23,8 @@ type tt_user struct {
 	server               *tt_server
 	conntime             time.Time
 	conntime_set         bool
+	autosub_base         int
+	autosub_source       string
 }
 
 func NewUser(id int, s *tt_server) *tt_user {
@@ -285,3 +287,30 @@ func (user *tt_user) Conntime_set() {
 	user.conntime = time.Now()
 	user.conntime_set = true
 }
+
+// The local subscriptions the server gave the user when they logged in,
+// which automatic subscription rules are applied to if no automatic subscriptions are set.
+func (user *tt_user) AutoSubBase_read() int {
+	defer user.Unlock()
+	user.Lock()
+	return user.autosub_base
+}
+
+func (user *tt_user) AutoSubBase_set(subs int) {
+	defer user.Unlock()
+	user.Lock()
+	user.autosub_base = subs
+}
+
+// Describes what produced the current automatic subscriptions of the user.
+func (user *tt_user) AutoSubSource_read() string {
+	defer user.Unlock()
+	user.Lock()
+	return user.autosub_source
+}
+
+func (user *tt_user) AutoSubSource_set(source string) {
+	defer user.Unlock()
+	user.Lock()
+	user.autosub_source = source
+}
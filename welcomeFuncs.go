@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Functions for greeting users in a private message when they log in, or join particular channels.
+// Users are remembered by username, so they can be greeted differently the first time they are seen.
+
+type tt_welcome struct {
+	Enabled  bool                  `xml:"enabled,attr"`
+	Message  string                `xml:"message,omitempty"`
+	First    string                `xml:"first,omitempty"`
+	Channels []*tt_welcome_channel `xml:"channel,omitempty"`
+}
+
+type tt_welcome_channel struct {
+	Path    string `xml:"path,attr"`
+	Message string `xml:"message,omitempty"`
+	First   string `xml:"first,omitempty"`
+}
+
+type tt_welcome_user struct {
+	UserName string   `xml:"username,attr"`
+	Channels []string `xml:"channel"`
+}
+
+type tt_welcome_seen struct {
+	XMLName xml.Name           `xml:"welcome"`
+	Users   []*tt_welcome_user `xml:"user"`
+}
+
+// Returns the template for a user, preferring the first time template for users who haven't been seen.
+func welcome_template(message, first string, seen bool) string {
+	if !seen && first != "" {
+		return first
+	}
+	return message
+}
+
+func welcome_template_info(message, first string) string {
+	str := ""
+	if message != "" {
+		str += "Message: " + message + "\r\n"
+	}
+	if first != "" {
+		str += "First time message: " + first + "\r\n"
+	}
+	return str
+}
+
+func (welcome tt_welcome) Info_str() string {
+	if !welcome.Enabled {
+		return "Welcome messages are disabled."
+	}
+	str := "Welcome messages are enabled.\r\n"
+	if welcome.Message == "" && welcome.First == "" {
+		str += "No message is sent when users log in.\r\n"
+	} else {
+		str += welcome_template_info(welcome.Message, welcome.First)
+	}
+	for _, wc := range welcome.Channels {
+		str += "When joining " + wc.Path + ":\r\n" + welcome_template_info(wc.Message, wc.First)
+	}
+	return str
+}
+
+// Returns the channel settings for path, or nil if users joining it aren't greeted.
+func (welcome tt_welcome) Channel_find(path string) *tt_welcome_channel {
+	path = strings.ToLower(path)
+	for _, wc := range welcome.Channels {
+		if strings.ToLower(wc.Path) == path {
+			return wc
+		}
+	}
+	return nil
+}
+
+// Changes the message sent when joining path, adding the channel if needed and removing it once it has no messages.
+func (welcome *tt_welcome) Channel_set(path string, first bool, message string) {
+	wc := welcome.Channel_find(path)
+	if wc == nil {
+		wc = &tt_welcome_channel{Path: path}
+		welcome.Channels = append(welcome.Channels, wc)
+	}
+	if first {
+		wc.First = message
+	} else {
+		wc.Message = message
+	}
+	if wc.Message != "" || wc.First != "" {
+		return
+	}
+	for i, c := range welcome.Channels {
+		if c == wc {
+			welcome.Channels = append(welcome.Channels[:i], welcome.Channels[i+1:]...)
+			break
+		}
+	}
+}
+
+func (server *tt_server) Welcome_read() tt_welcome {
+	defer server.Unlock()
+	server.Lock()
+	if server.Welcome == nil {
+		return tt_welcome{}
+	}
+	welcome := *server.Welcome
+	welcome.Channels = []*tt_welcome_channel{}
+	for _, wc := range server.Welcome.Channels {
+		c := *wc
+		welcome.Channels = append(welcome.Channels, &c)
+	}
+	return welcome
+}
+
+func (server *tt_server) Welcome_set(welcome tt_welcome) {
+	defer server.Unlock()
+	server.Lock()
+	server.Welcome = &welcome
+}
+
+func (server *tt_server) Welcome_path() string {
+	path := server.State_path()
+	if path == "" {
+		return ""
+	}
+	return path + "welcome.xml"
+}
+
+func (server *tt_server) Welcome_load() {
+	server.Lock()
+	loaded := server.welcome_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	defer server.welcome_lock.Unlock()
+	server.welcome_lock.Lock()
+	server.Lock()
+	loaded = server.welcome_loaded
+	server.Unlock()
+	if loaded {
+		return
+	}
+	fname := server.Welcome_path()
+	seen := &tt_welcome_seen{}
+	err := state_read(fname, seen)
+	server.Lock()
+	server.welcome_loaded = true
+	server.welcome_failed = err != nil
+	if server.welcome_seen == nil {
+		server.welcome_seen = make(map[string]map[string]bool)
+	}
+	if err == nil {
+		for _, wu := range seen.Users {
+			channels, exists := server.welcome_seen[wu.UserName]
+			if !exists {
+				channels = make(map[string]bool)
+				server.welcome_seen[wu.UserName] = channels
+			}
+			for _, path := range wu.Channels {
+				channels[path] = true
+			}
+		}
+	}
+	server.Unlock()
+	if err != nil {
+		server.Log_write("Error reading welcomed users from "+fname+": "+err.Error()+". The file won't be changed until the bot is restarted.", true)
+	}
+}
+
+// Writes the welcomed users. The lock is held until the file is written, so an older list can't replace a newer one.
+func (server *tt_server) Welcome_write() bool {
+	server.Welcome_load()
+	defer server.welcome_lock.Unlock()
+	server.welcome_lock.Lock()
+	server.Lock()
+	if server.welcome_failed {
+		server.Unlock()
+		return false
+	}
+	seen := &tt_welcome_seen{}
+	for username, channels := range server.welcome_seen {
+		wu := &tt_welcome_user{UserName: username}
+		for path := range channels {
+			wu.Channels = append(wu.Channels, path)
+		}
+		seen.Users = append(seen.Users, wu)
+	}
+	server.Unlock()
+	fname := server.Welcome_path()
+	if fname == "" {
+		return false
+	}
+	if err := state_write(fname, seen); err != nil {
+		server.Log_write("Error writing welcomed users to "+fname+": "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// Records that username has been seen on the server, or in a channel if path isn't empty.
+// Returns whether they had been seen before.
+func (server *tt_server) Welcome_seen(username, path string) bool {
+	server.Welcome_load()
+	path = strings.ToLower(path)
+	server.Lock()
+	channels, seen := server.welcome_seen[username]
+	if !seen {
+		channels = make(map[string]bool)
+		server.welcome_seen[username] = channels
+	}
+	if path != "" {
+		seen = channels[path]
+		channels[path] = true
+	}
+	server.Unlock()
+	if !seen {
+		server.Welcome_write()
+	}
+	return seen
+}
+
+// Fills in a welcome template for usr.
+func (server *tt_server) Welcome_format(template string, usr *tt_user, ch *tt_channel) string {
+	username := usr.UserName_read()
+	if username == "" {
+		username = "guest"
+	}
+	nickname := usr.NickName_read()
+	if nickname == "" {
+		nickname = username
+	}
+	path := ""
+	if ch != nil {
+		path = ch.Path_read()
+	}
+	return strings.NewReplacer(
+		"{nickname}", nickname,
+		"{username}", username,
+		"{users}", strconv.Itoa(len(server.Users_sort(server.Uid_read()))),
+		"{server}", server.Name_read(),
+		"{channel}", path,
+	).Replace(template)
+}
+
+// Greets usr when they log in. Users without a username always get the regular message.
+func (server *tt_server) Welcome_login(usr *tt_user) {
+	welcome := server.Welcome_read()
+	if !welcome.Enabled || usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	seen := true
+	if username := usr.UserName_read(); username != "" {
+		seen = server.Welcome_seen(username, "")
+	}
+	template := welcome_template(welcome.Message, welcome.First, seen)
+	if template == "" {
+		return
+	}
+	server.cmd_message_user(usr.Uid_read(), server.Welcome_format(template, usr, nil))
+}
+
+// Greets usr when they join ch, if ch has a welcome message.
+func (server *tt_server) Welcome_channel(usr *tt_user, ch *tt_channel) {
+	welcome := server.Welcome_read()
+	if !welcome.Enabled || usr.Uid_read() == server.Uid_read() {
+		return
+	}
+	wc := welcome.Channel_find(ch.Path_read())
+	if wc == nil {
+		return
+	}
+	seen := true
+	if username := usr.UserName_read(); username != "" {
+		seen = server.Welcome_seen(username, wc.Path)
+	}
+	template := welcome_template(wc.Message, wc.First, seen)
+	if template == "" {
+		return
+	}
+	server.cmd_message_user(usr.Uid_read(), server.Welcome_format(template, usr, ch))
+}